@@ -3,33 +3,104 @@ package api
 import (
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/openapi"
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/server"
+	"github.com/rokusei/gopass-server/api/v1/session"
 	"github.com/rokusei/gopass-server/api/v1/user"
 	"github.com/rokusei/gopass-server/api/v1/vault"
 	"github.com/rokusei/gopass-server/api/v1/vault/entry"
-	"gorm.io/gorm"
+	"github.com/rokusei/gopass-server/db"
 )
 
+// Version is the version of gopass-server advertised on /v1/server, release
+// builds set it with -ldflags "-X github.com/rokusei/gopass-server/api.Version=..."
+var Version = "dev"
+
+// APIVersions lists the versions of the API this server speaks
+var APIVersions = []string{"v1"}
+
 type APIConfig struct {
-	DB *gorm.DB
+	// Store holds the users, their vaults and sessions
+	Store db.Store
+	// PreloginKey derives the made up KDF parameters /prelogin returns for
+	// unknown emails, it must stay the same across restarts and servers
+	PreloginKey []byte
+	// Padding lists the sizes encrypted entries must be padded to, it is
+	// advertised on /v1/server
+	Padding padding.Policy
+	// MaxBodySize is the size in bytes of the largest request body read,
+	// zero is unlimited. db.Quotas bound what each user stores
+	MaxBodySize int64
 }
 
 type api struct {
 	*http.ServeMux
 }
 
-func NewAPI(apiConfig APIConfig) http.Handler {
-	mux := http.NewServeMux()
+type route struct {
+	path    string
+	handler http.Handler
+}
+
+// routes lists every endpoint served by the API, each of which must also be
+// described in the OpenAPI document returned by Spec
+func routes(apiConfig APIConfig) []route {
+	return []route{
+		// user
+		{"/user", user.GetUserAPI(apiConfig.Store)},
+		{"/user/create", user.CreateUserAPI(apiConfig.Store)},
+		{"/user/kdf/update", user.UpdateKDFAPI(apiConfig.Store, apiConfig.Padding)},
+		{"/prelogin", user.PreloginAPI(apiConfig.Store, apiConfig.PreloginKey)},
+
+		// session
+		{"/session/create", session.CreateSessionAPI(apiConfig.Store)},
+		{"/session/delete", session.DeleteSessionAPI(apiConfig.Store)},
 
-	// user
-	mux.Handle("/user", user.GetUserAPI(apiConfig.DB))
-	mux.Handle("/user/create", user.CreateUserAPI(apiConfig.DB))
+		// vault
+		{"/vault", vault.GetVaultAPI(apiConfig.Store)},
 
-	// vault
-	mux.Handle("/vault", vault.GetVaultAPI(apiConfig.DB))
+		// vault/entry
+		{"/vault/entry", entry.GetVaultEntryAPI(apiConfig.Store)},
+		{"/vault/entry/create", entry.CreateVaultEntryAPI(apiConfig.Store, apiConfig.Padding)},
+		{"/vault/entry/update", entry.UpdateVaultEntryAPI(apiConfig.Store, apiConfig.Padding)},
+		{"/vault/entry/delete", entry.DeleteVaultEntryAPI(apiConfig.Store)},
+		{"/vault/entry/batch", entry.VaultEntryBatchAPI(apiConfig.Store, apiConfig.Padding)},
 
-	// vault/entry
-	mux.Handle("/vault/entry", entry.GetVaultEntryAPI(apiConfig.DB))
-	mux.Handle("/vault/entry/create", entry.CreateVaultEntryAPI(apiConfig.DB))
-	mux.Handle("/vault/entry/update", entry.UpdateVaultEntryAPI(apiConfig.DB))
+		// server
+		{"/v1/server", server.GetServerAPI(capabilities(apiConfig))},
+
+		// spec
+		{"/v1/openapi.json", openapi.Handler(Spec())},
+	}
+}
+
+// capabilities describes the server to its clients on /v1/server
+func capabilities(apiConfig APIConfig) server.Capabilities {
+	return server.Capabilities{
+		Version:     Version,
+		APIVersions: APIVersions,
+		Features:    server.Features{TwoFactor: []string{}},
+		Limits: server.Limits{
+			MaxBodySize:        apiConfig.MaxBodySize,
+			MaxEntrySize:       db.Quotas.MaxEntrySize,
+			MaxEntries:         db.Quotas.MaxEntries,
+			MaxBatchOperations: db.MaxBatchOperations,
+		},
+		KDF:     db.GetKDFPolicy(),
+		Padding: apiConfig.Padding,
+	}
+}
+
+func NewAPI(apiConfig APIConfig) http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range routes(apiConfig) {
+		handler := r.handler
+		if apiConfig.MaxBodySize != 0 {
+			handler = quota.LimitBody(handler, apiConfig.MaxBodySize)
+		}
+		mux.Handle(r.path, handler)
+	}
 	return &api{mux}
 }
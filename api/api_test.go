@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/rokusei/gopass-server/api/openapi"
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/v1/server"
+	"github.com/rokusei/gopass-server/api/v1/user"
+	"github.com/rokusei/gopass-server/api/v1/vault/entry"
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SpecCoversRoutes(t *testing.T) {
+	spec := Spec()
+
+	paths := make(map[string]bool)
+	for _, r := range routes(APIConfig{}) {
+		paths[r.path] = true
+		require.Contains(t, spec.Paths, r.path, "route %s has no OpenAPI spec entry", r.path)
+	}
+	for path := range spec.Paths {
+		require.True(t, paths[path], "OpenAPI spec describes %s which is not routed", path)
+	}
+}
+
+func Test_SpecSchemasMatchJSON(t *testing.T) {
+	spec := Spec()
+
+	testCases := []struct {
+		name  string
+		value interface{}
+	}{
+		{"User", db.User{}},
+		{"Vault", db.Vault{}},
+		{"VaultEntry", db.VaultEntry{}},
+		{"Session", db.Session{Token: "token"}},
+		{"Verification", db.Verification{}},
+		{"KDF", db.DefaultKDF([]byte("salt"))},
+		{"BatchOperation", db.BatchOperation{Op: db.BatchUpdate, ID: "id", EncryptedEntry: []byte("entry")}},
+		{"BatchResult", db.BatchResult{Entry: &db.VaultEntry{}, Error: "error"}},
+		{"BatchResponse", entry.BatchResponse{}},
+		{"UserResponse", user.UserResponse{User: &db.User{}}},
+		{"Usage", db.Usage{}},
+		{"Quota", db.Quota{}},
+		{"Capabilities", server.Capabilities{}},
+		{"Features", server.Features{}},
+		{"Limits", server.Limits{}},
+		{"KDFPolicy", db.GetKDFPolicy()},
+		{"Policy", padding.Default()},
+	}
+
+	for _, test := range testCases {
+		b, err := json.Marshal(test.value)
+		require.NoError(t, err)
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &fields))
+
+		schema, ok := spec.Components.Schemas[test.name]
+		require.True(t, ok, test.name)
+		require.Equal(t, keys(fields), schemaKeys(schema), test.name)
+	}
+}
+
+func Test_ServeSpec(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewAPI(APIConfig{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Equal(t, openapi.Version, doc.OpenAPI)
+	require.Len(t, doc.Paths, len(routes(APIConfig{})))
+}
+
+func keys(m map[string]interface{}) []string {
+	var k []string
+	for key := range m {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func schemaKeys(s *openapi.Schema) []string {
+	var k []string
+	for key := range s.Properties {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func Test_ServeCapabilities(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewAPI(APIConfig{Padding: padding.Default()}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/server", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var capabilities server.Capabilities
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &capabilities))
+	require.Equal(t, Version, capabilities.Version)
+	require.Equal(t, []string{"v1"}, capabilities.APIVersions)
+	require.Equal(t, db.MaxBatchOperations, capabilities.Limits.MaxBatchOperations)
+	require.Equal(t, db.GetKDFPolicy(), capabilities.KDF)
+	require.Equal(t, padding.Default(), capabilities.Padding)
+}
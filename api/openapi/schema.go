@@ -0,0 +1,193 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// a Generator derives Schemas from Go types following the same rules
+// encoding/json uses to marshal them, so the documented shape of a response
+// matches what json.Marshal actually writes
+type Generator struct {
+	schemas map[string]*Schema
+	known   map[reflect.Type]*Schema
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: make(map[string]*Schema),
+		known: map[reflect.Type]*Schema{
+			timeType: {Type: "string", Format: "date-time"},
+		},
+	}
+}
+
+// Register overrides the schema of a type, used for types with custom JSON marshalling
+func (g *Generator) Register(v interface{}, s *Schema) {
+	g.known[reflect.TypeOf(v)] = s
+}
+
+// Ref returns a reference to the component schema of v, generating it if needed
+func (g *Generator) Ref(v interface{}) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+// Schemas returns every component schema generated so far
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	if s, ok := g.known[t]; ok {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := *g.schemaOf(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return &s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes []byte as a base64 string
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+			// unknown custom encoding, don't guess at the shape
+			return &Schema{}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// reserve the name first so recursive types terminate
+			g.schemas[t.Name()] = &Schema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, f := range jsonFields(t) {
+		s.Properties[f.name] = g.schemaOf(f.typ)
+	}
+	return s
+}
+
+type jsonField struct {
+	name   string
+	typ    reflect.Type
+	depth  int
+	tagged bool
+}
+
+// jsonFields lists the fields encoding/json would write for t, applying the
+// same embedding and name dominance rules
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	collectFields(t, 0, &fields)
+
+	byName := make(map[string][]jsonField)
+	var order []string
+	for _, f := range fields {
+		if _, ok := byName[f.name]; !ok {
+			order = append(order, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	var out []jsonField
+	for _, name := range order {
+		if f, ok := dominantField(byName[name]); ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func collectFields(t reflect.Type, depth int, fields *[]jsonField) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			collectFields(ft, depth+1, fields)
+			continue
+		}
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		f := jsonField{name: name, typ: sf.Type, depth: depth, tagged: name != ""}
+		if name == "" {
+			f.name = sf.Name
+		}
+		*fields = append(*fields, f)
+	}
+}
+
+func dominantField(fields []jsonField) (jsonField, bool) {
+	min := fields[0].depth
+	for _, f := range fields {
+		if f.depth < min {
+			min = f.depth
+		}
+	}
+
+	var shallow []jsonField
+	for _, f := range fields {
+		if f.depth == min {
+			shallow = append(shallow, f)
+		}
+	}
+	if len(shallow) == 1 {
+		return shallow[0], true
+	}
+
+	var tagged []jsonField
+	for _, f := range shallow {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return jsonField{}, false
+}
@@ -0,0 +1,119 @@
+// Package padding buckets the sizes of encrypted entries, so that the size of
+// an entry says little about what kind of secret it holds
+//
+// Clients pad their plaintext so that the EncryptedEntry they upload is
+// exactly as large as one of the buckets of the server's Policy, entries
+// larger than the largest bucket are padded to a multiple of it
+package padding
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rokusei/gopass-server/db"
+)
+
+var ErrUnpadded = errors.New("encrypted entry is not padded to an allowed size")
+var ErrInvalidPolicy = errors.New("invalid padding policy")
+
+// BlockSize is the AES block size, every bucket must be a multiple of it since
+// entries are encrypted with AES-CBC
+const BlockSize = 16
+
+// a Policy lists the sizes encrypted entries must be padded to
+type Policy struct {
+	// Buckets are the allowed sizes in bytes, ascending. any size is
+	// allowed when there are none
+	Buckets []int `json:",omitempty"`
+}
+
+// Default buckets entries in powers of two from 128 bytes to 1 MiB, so
+// that a PIN and a password look the same while large entries waste at most
+// half their size
+func Default() Policy {
+	var p Policy
+	for size := 128; size <= 1<<20; size *= 2 {
+		p.Buckets = append(p.Buckets, size)
+	}
+	return p
+}
+
+// ParsePolicy reads a policy from comma separated sizes in bytes, "default"
+// is Default and an empty string allows any size
+func ParsePolicy(s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return Policy{}, nil
+	case "default":
+		return Default(), nil
+	}
+
+	var p Policy
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return Policy{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		if size <= BlockSize || size%BlockSize != 0 {
+			return Policy{}, fmt.Errorf("%w: bucket %d is not a multiple of %d larger than it", ErrInvalidPolicy, size, BlockSize)
+		}
+		p.Buckets = append(p.Buckets, size)
+	}
+	sort.Ints(p.Buckets)
+	for i := 1; i < len(p.Buckets); i++ {
+		if p.Buckets[i] == p.Buckets[i-1] {
+			return Policy{}, fmt.Errorf("%w: bucket %d is listed twice", ErrInvalidPolicy, p.Buckets[i])
+		}
+	}
+	return p, nil
+}
+
+// String formats the policy as ParsePolicy reads it
+func (p Policy) String() string {
+	sizes := make([]string, len(p.Buckets))
+	for i, size := range p.Buckets {
+		sizes[i] = strconv.Itoa(size)
+	}
+	return strings.Join(sizes, ",")
+}
+
+// Size returns the smallest allowed size of at least n bytes
+func (p Policy) Size(n int) int {
+	if len(p.Buckets) == 0 {
+		return n
+	}
+	for _, size := range p.Buckets {
+		if n <= size {
+			return size
+		}
+	}
+	largest := p.Buckets[len(p.Buckets)-1]
+	return (n + largest - 1) / largest * largest
+}
+
+// Check fails with ErrUnpadded unless every entry has an allowed size
+func (p Policy) Check(encryptedEntries ...[]byte) error {
+	for _, e := range encryptedEntries {
+		if p.Size(len(e)) != len(e) {
+			return fmt.Errorf("%w: %d bytes, expected %d", ErrUnpadded, len(e), p.Size(len(e)))
+		}
+	}
+	return nil
+}
+
+// CheckOperations checks the entries created and updated by a batch
+func (p Policy) CheckOperations(ops []db.BatchOperation) error {
+	for i, op := range ops {
+		if op.Op != db.BatchCreate && op.Op != db.BatchUpdate {
+			continue
+		}
+		if err := p.Check(op.EncryptedEntry); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return nil
+}
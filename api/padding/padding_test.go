@@ -0,0 +1,40 @@
+package padding_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParsePolicy(t *testing.T) {
+	p, err := padding.ParsePolicy("256, 64,1024")
+	require.NoError(t, err)
+	require.Equal(t, []int{64, 256, 1024}, p.Buckets)
+	require.Equal(t, "64,256,1024", p.String())
+
+	p, err = padding.ParsePolicy("default")
+	require.NoError(t, err)
+	require.Equal(t, padding.Default(), p)
+
+	p, err = padding.ParsePolicy("")
+	require.NoError(t, err)
+	require.Empty(t, p.Buckets)
+
+	for _, s := range []string{"abc", "16", "100", "64,64"} {
+		_, err := padding.ParsePolicy(s)
+		require.ErrorIs(t, err, padding.ErrInvalidPolicy, s)
+	}
+}
+
+func Test_PolicyCheck(t *testing.T) {
+	p := padding.Policy{Buckets: []int{64, 256}}
+	for n, size := range map[int]int{1: 64, 64: 64, 65: 256, 256: 256, 257: 512, 600: 768} {
+		require.Equal(t, size, p.Size(n), n)
+	}
+
+	require.NoError(t, p.Check(make([]byte, 64), make([]byte, 512)))
+	require.ErrorIs(t, p.Check(make([]byte, 64), make([]byte, 100)), padding.ErrUnpadded)
+	require.NoError(t, padding.Policy{}.Check(bytes.Repeat([]byte("x"), 100)))
+}
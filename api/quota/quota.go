@@ -0,0 +1,47 @@
+// Package quota limits the size of requests and tells clients which of their
+// requests went over a limit or quota
+package quota
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rokusei/gopass-server/db"
+)
+
+var ErrBodyTooLarge = errors.New("request body is larger than the server allows")
+
+// maxBytesMessage is the error of a body read past http.MaxBytesReader, which
+// isn't typed before Go 1.19
+const maxBytesMessage = "http: request body too large"
+
+type limitBody struct {
+	handler http.Handler
+	max     int64
+}
+
+// LimitBody stops handler from reading more than max bytes of a request body,
+// requests declaring a larger body are refused before it runs
+func LimitBody(handler http.Handler, max int64) http.Handler {
+	return &limitBody{handler, max}
+}
+
+func (l *limitBody) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > l.max {
+		Error(w, ErrBodyTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, l.max)
+	l.handler.ServeHTTP(w, r)
+}
+
+// Error responds with err, as 413 Request Entity Too Large when a limit or
+// quota was exceeded and as 500 Internal Server Error otherwise
+func Error(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, db.ErrEntryTooLarge) ||
+		errors.Is(err, db.ErrQuotaExceeded) || err.Error() == maxBytesMessage {
+		status = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, err.Error(), status)
+}
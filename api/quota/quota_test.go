@@ -0,0 +1,54 @@
+package quota_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LimitBody(t *testing.T) {
+	handler := quota.LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			quota.Error(w, err)
+		}
+	}), 16)
+
+	testCases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		status        int
+	}{
+		{"small", "a=b", 3, http.StatusOK},
+		{"declared too large", strings.Repeat("a", 32), 32, http.StatusRequestEntityTooLarge},
+		// chunked bodies only fail once read past the limit
+		{"chunked too large", strings.Repeat("a", 32), -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(test.body)))
+		req.ContentLength = test.contentLength
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, test.status, rec.Code, test.name)
+	}
+}
+
+func Test_Error(t *testing.T) {
+	for err, status := range map[error]int{
+		db.ErrQuotaExceeded:   http.StatusRequestEntityTooLarge,
+		db.ErrEntryTooLarge:   http.StatusRequestEntityTooLarge,
+		db.ErrEntryNotFound:   http.StatusInternalServerError,
+		quota.ErrBodyTooLarge: http.StatusRequestEntityTooLarge,
+	} {
+		rec := httptest.NewRecorder()
+		quota.Error(rec, err)
+		require.Equal(t, status, rec.Code, err.Error())
+	}
+}
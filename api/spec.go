@@ -0,0 +1,246 @@
+package api
+
+import (
+	"github.com/rokusei/gopass-server/api/openapi"
+	"github.com/rokusei/gopass-server/api/v1/server"
+	"github.com/rokusei/gopass-server/api/v1/user"
+	"github.com/rokusei/gopass-server/api/v1/vault/entry"
+	"github.com/rokusei/gopass-server/db"
+	"gorm.io/gorm"
+)
+
+// SpecVersion is the version of the API described by Spec
+const SpecVersion = "1.0.0"
+
+// form fields shared by the endpoints, all endpoints take their parameters
+// as an application/x-www-form-urlencoded body
+var (
+	emailField         = formField{"email", "email address of the user", true, false}
+	authHashField      = formField{"auth-hash", "AuthenticationHash derived from the master password", true, false}
+	entryUUIDField     = formField{"entry-uuid", "ID of the vault entry", true, false}
+	encEntryField      = formField{"encrypted-entry", "vault entry encrypted client side with the EncryptionKey, padded to a size allowed by /v1/server", true, false}
+	schemaVersionField = formField{"schema-version", "entry schema version the client writes and understands, clients older than the newest entry of the vault are refused", false, false}
+	operationsField    = formField{"operations", "JSON array of BatchOperation objects, applied in order", true, false}
+	newAuthHashField   = formField{"new-auth-hash", "AuthenticationHash derived with the new KDF parameters", true, false}
+	rekeyField         = formField{"operations", "JSON array of update BatchOperation objects re-encrypting every vault entry with the new EncryptionKey", false, false}
+)
+
+// kdfFields describe the KDF parameters of a user, users registered without
+// them derive their keys with PBKDF2-SHA512 and a salt only they know
+var kdfFields = []formField{
+	{"kdf", "key derivation function, pbkdf2-sha512 or argon2id", false, false},
+	{"kdf-iterations", "iterations, or Argon2id time cost", false, false},
+	{"kdf-memory", "Argon2id memory cost in KiB", false, false},
+	{"kdf-parallelism", "Argon2id parallelism", false, false},
+	{"kdf-salt", "base64 encoded salt, required for argon2id", false, false},
+}
+
+type formField struct {
+	name        string
+	description string
+	required    bool
+	// repeated fields may be given several times
+	repeated bool
+}
+
+// an endpoint describes a form-encoded POST endpoint returning a JSON encoded result
+type endpoint struct {
+	id      string
+	summary string
+	tag     string
+	// session endpoints accept a bearer session token in place of the
+	// email and auth-hash form values
+	session bool
+	fields  []formField
+	// a nil result is documented as 204 No Content
+	result *openapi.Schema
+	// quota endpoints store entries, and fail with 413 over a limit or quota
+	// and with 400 when an entry isn't padded
+	quota bool
+}
+
+// Spec builds the OpenAPI document describing every route served by NewAPI
+func Spec() *openapi.Document {
+	g := openapi.NewGenerator()
+	g.Register(gorm.DeletedAt{}, &openapi.Schema{Type: "string", Format: "date-time", Nullable: true})
+
+	userSchema := g.Ref(db.User{})
+	userResponseSchema := g.Ref(user.UserResponse{})
+	kdfSchema := g.Ref(db.KDF{})
+	sessionSchema := g.Ref(db.Session{})
+	vaultSchema := g.Ref(db.Vault{})
+	entrySchema := g.Ref(db.VaultEntry{})
+	g.Ref(db.BatchOperation{})
+	batchSchema := g.Ref(entry.BatchResponse{})
+	serverSchema := g.Ref(server.Capabilities{})
+
+	return &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "gopass-server",
+			Description: "Privacy oriented password vault. Vault entries are encrypted client side and stored as opaque blobs.",
+			Version:     SpecVersion,
+		},
+		Paths: map[string]*openapi.PathItem{
+			"/user": endpoint{
+				id: "getUser", summary: "Fetch the authenticated user and their vault", tag: "user",
+				session: true, fields: []formField{schemaVersionField}, result: userResponseSchema,
+			}.pathItem(),
+			"/user/create": endpoint{
+				id: "createUser", summary: "Register a new user and create their vault", tag: "user",
+				fields: append([]formField{emailField, authHashField}, kdfFields...), result: userSchema,
+			}.pathItem(),
+			"/user/kdf/update": endpoint{
+				id: "updateUserKDF", summary: "Change the KDF parameters and AuthenticationHash of a verified user, re-encrypting their vault and ending their other sessions", tag: "user",
+				fields: append([]formField{emailField, authHashField, schemaVersionField, newAuthHashField, rekeyField}, kdfFields...), quota: true,
+			}.pathItem(),
+			"/prelogin": endpoint{
+				id: "prelogin", summary: "Fetch the KDF parameters to derive a user's keys with, unknown emails get made up ones", tag: "user",
+				fields: []formField{emailField}, result: kdfSchema,
+			}.pathItem(),
+			"/session/create": endpoint{
+				id: "createSession", summary: "Start a session for a verified user", tag: "session",
+				fields: []formField{emailField, authHashField}, result: sessionSchema,
+			}.pathItem(),
+			"/session/delete": endpoint{
+				id: "deleteSession", summary: "End the session of the bearer token", tag: "session",
+				session: true,
+			}.pathItem(),
+			"/vault": endpoint{
+				id: "getVault", summary: "Fetch the vault of a verified user", tag: "vault",
+				session: true, fields: []formField{schemaVersionField}, result: vaultSchema,
+			}.pathItem(),
+			"/vault/entry": endpoint{
+				id: "getVaultEntry", summary: "Fetch a single vault entry", tag: "vault",
+				session: true, fields: []formField{schemaVersionField, entryUUIDField}, result: entrySchema,
+			}.pathItem(),
+			"/vault/entry/create": endpoint{
+				id: "createVaultEntry", summary: "Add an entry to the vault", tag: "vault",
+				session: true, fields: []formField{schemaVersionField, encEntryField}, result: entrySchema, quota: true,
+			}.pathItem(),
+			"/vault/entry/update": endpoint{
+				id: "updateVaultEntry", summary: "Replace the encrypted contents of a vault entry", tag: "vault",
+				session: true, fields: []formField{schemaVersionField, entryUUIDField, encEntryField}, result: entrySchema, quota: true,
+			}.pathItem(),
+			"/vault/entry/delete": endpoint{
+				id: "deleteVaultEntry", summary: "Remove an entry from the vault", tag: "vault",
+				session: true, fields: []formField{schemaVersionField, entryUUIDField},
+			}.pathItem(),
+			"/vault/entry/batch": endpoint{
+				id: "vaultEntryBatch", summary: "Create, update and delete entries in one transaction, all or nothing", tag: "vault",
+				session: true, fields: []formField{schemaVersionField, operationsField}, result: batchSchema, quota: true,
+			}.pathItem(),
+			"/v1/server": {
+				Get: &openapi.Operation{
+					OperationID: "getServer",
+					Summary:     "Fetch the version, API versions, features, limits, KDF policy and entry padding of the server",
+					Tags:        []string{"meta"},
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "Server capabilities",
+							Content:     jsonContent(serverSchema),
+						},
+					},
+				},
+			},
+			"/v1/openapi.json": {
+				Get: &openapi.Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "This document",
+					Tags:        []string{"meta"},
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "OpenAPI document",
+							Content:     jsonContent(&openapi.Schema{Type: "object"}),
+						},
+					},
+				},
+			},
+		},
+		Components: openapi.Components{
+			Schemas: g.Schemas(),
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"session": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "Token returned by /session/create",
+				},
+			},
+		},
+	}
+}
+
+func (e endpoint) pathItem() *openapi.PathItem {
+	fields := e.fields
+	var security []openapi.SecurityRequirement
+	if e.session {
+		// the session token and the credentials are alternatives
+		security = []openapi.SecurityRequirement{{"session": {}}, {}}
+		fields = append([]formField{
+			{emailField.name, emailField.description + ", required without a session token", false, false},
+			{authHashField.name, authHashField.description + ", required without a session token", false, false},
+		}, fields...)
+	}
+
+	form := &openapi.Schema{Type: "object", Properties: make(map[string]*openapi.Schema)}
+	for _, f := range fields {
+		prop := &openapi.Schema{Type: "string", Description: f.description}
+		if f.repeated {
+			prop = &openapi.Schema{Type: "array", Description: f.description, Items: &openapi.Schema{Type: "string"}}
+		}
+		form.Properties[f.name] = prop
+		if f.required {
+			form.Required = append(form.Required, f.name)
+		}
+	}
+
+	responses := map[string]*openapi.Response{
+		"500": {
+			Description: "Error",
+			Content: map[string]*openapi.MediaType{
+				"text/plain": {Schema: &openapi.Schema{Type: "string"}},
+			},
+		},
+		"413": {
+			Description: "Request body over the limit advertised on /v1/server",
+			Content: map[string]*openapi.MediaType{
+				"text/plain": {Schema: &openapi.Schema{Type: "string"}},
+			},
+		},
+	}
+	if e.quota {
+		responses["413"].Description = "Request body, entry size or entry count over the limits advertised on /v1/server"
+		responses["400"] = &openapi.Response{
+			Description: "Encrypted entry not padded to a size advertised on /v1/server",
+			Content: map[string]*openapi.MediaType{
+				"text/plain": {Schema: &openapi.Schema{Type: "string"}},
+			},
+		}
+	}
+	if e.result != nil {
+		responses["200"] = &openapi.Response{Description: "OK", Content: jsonContent(e.result)}
+	} else {
+		responses["204"] = &openapi.Response{Description: "No Content"}
+	}
+
+	return &openapi.PathItem{
+		Post: &openapi.Operation{
+			OperationID: e.id,
+			Summary:     e.summary,
+			Tags:        []string{e.tag},
+			Security:    security,
+			RequestBody: &openapi.RequestBody{
+				Content: map[string]*openapi.MediaType{
+					"application/x-www-form-urlencoded": {Schema: form},
+				},
+			},
+			Responses: responses,
+		},
+	}
+}
+
+func jsonContent(s *openapi.Schema) map[string]*openapi.MediaType {
+	return map[string]*openapi.MediaType{
+		"application/json": {Schema: s},
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rokusei/gopass-server/db"
+)
+
+const bearerPrefix = "Bearer "
+
+var ErrSessionOfOtherUser = errors.New("session token belongs to another user")
+
+// BearerToken returns the session token of a request's Authorization header, if any
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, bearerPrefix))
+}
+
+// SchemaVersion returns the entry schema version a client declared in the
+// schema-version form value, clients that predate it declare nothing and
+// are version 0
+func SchemaVersion(r *http.Request) (uint, error) {
+	v := r.FormValue("schema-version")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(n), nil
+}
+
+// checkSchemaVersion refuses clients too old to understand the user's vault
+func checkSchemaVersion(r *http.Request, store db.Store, user *db.User) error {
+	v, err := SchemaVersion(r)
+	if err != nil {
+		return err
+	}
+	return store.CheckSchemaVersion(r.Context(), user, v)
+}
+
+// VerifiedUser authenticates a request using either its session token
+// or its email and auth-hash form values, and ensures the user is verified
+// Sessions are only ever created for verified users
+func VerifiedUser(r *http.Request, store db.Store) (*db.User, error) {
+	var user *db.User
+	var err error
+	if token := BearerToken(r); token != "" {
+		user, err = store.GetSessionUser(r.Context(), token)
+	} else {
+		email := r.FormValue("email")
+		authHash := r.FormValue("auth-hash")
+		user, err = store.GetVerifiedUser(r.Context(), email, []byte(authHash))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSchemaVersion(r, store, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// User authenticates a request like VerifiedUser, but does not require the
+// user to be verified, the user's vault entries are loaded as well
+func User(r *http.Request, store db.Store) (*db.User, error) {
+	var user *db.User
+	var err error
+	if token := BearerToken(r); token != "" {
+		user, err = store.GetSessionUser(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		_, err = store.GetVault(r.Context(), user)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		email := r.FormValue("email")
+		authHash := r.FormValue("auth-hash")
+		user, err = store.GetUser(r.Context(), email, []byte(authHash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = checkSchemaVersion(r, store, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ReauthenticatedUser authenticates a request by its email and auth-hash form
+// values even when it carries a session token, for changes a leaked token
+// alone must not allow. a session token sent along must belong to the same user
+func ReauthenticatedUser(r *http.Request, store db.Store) (*db.User, error) {
+	user, err := store.GetVerifiedUser(r.Context(), r.FormValue("email"), []byte(r.FormValue("auth-hash")))
+	if err != nil {
+		return nil, err
+	}
+	if token := BearerToken(r); token != "" {
+		sessionUser, err := store.GetSessionUser(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		if sessionUser.ID != user.ID {
+			return nil, ErrSessionOfOtherUser
+		}
+	}
+
+	err = checkSchemaVersion(r, store, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
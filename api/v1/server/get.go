@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/db"
+)
+
+// Capabilities describe what a server supports and expects of its clients
+type Capabilities struct {
+	// Version is the version of the server software
+	Version string
+	// APIVersions lists the versions of the API the server speaks, clients
+	// refuse servers sharing none with them
+	APIVersions []string
+	Features    Features
+	Limits      Limits
+	// KDF lists the KDF parameters new users may choose
+	KDF db.KDFPolicy
+	// Padding lists the sizes encrypted entries must be padded to
+	Padding padding.Policy
+}
+
+// Features lists the optional features enabled on a server
+type Features struct {
+	// TwoFactor lists the second factors users may enroll
+	TwoFactor   []string
+	Sharing     bool
+	Attachments bool
+	Sends       bool
+}
+
+// Limits bound requests and what a single user may store, zero is unlimited
+type Limits struct {
+	// MaxBodySize is the size in bytes of the largest request body read
+	MaxBodySize int64
+	// MaxEntrySize is the size in bytes of the largest encrypted entry accepted
+	MaxEntrySize int
+	// MaxEntries is how many entries a vault may hold
+	MaxEntries int
+	// MaxBatchOperations is how many operations or entries a batch may hold
+	MaxBatchOperations int
+}
+
+type getServerAPI struct {
+	capabilities Capabilities
+}
+
+// GetServerAPI returns the capabilities of the server, without authentication
+func GetServerAPI(capabilities Capabilities) http.Handler {
+	return &getServerAPI{capabilities}
+}
+
+func (g *getServerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(g.capabilities)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/db"
+)
+
+type createSessionAPI struct {
+	store db.Store
+}
+
+func CreateSessionAPI(store db.Store) http.Handler {
+	return &createSessionAPI{store}
+}
+
+func (c *createSessionAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	email := r.FormValue("email")
+	authHash := r.FormValue("auth-hash")
+
+	// Sessions can only be started by verified users
+	user, err := c.store.GetVerifiedUser(r.Context(), email, []byte(authHash))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := c.store.CreateSession(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(b)
+}
@@ -0,0 +1,26 @@
+package session
+
+import (
+	"net/http"
+
+	"github.com/rokusei/gopass-server/api/v1/auth"
+	"github.com/rokusei/gopass-server/db"
+)
+
+type deleteSessionAPI struct {
+	store db.Store
+}
+
+func DeleteSessionAPI(store db.Store) http.Handler {
+	return &deleteSessionAPI{store}
+}
+
+func (d *deleteSessionAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := d.store.DeleteSession(r.Context(), auth.BearerToken(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
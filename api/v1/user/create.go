@@ -4,29 +4,34 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/quota"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
 type createUserAPI struct {
-	db *gorm.DB
+	store db.Store
 }
 
-func CreateUserAPI(db *gorm.DB) http.Handler {
-	return &createUserAPI{db}
+func CreateUserAPI(store db.Store) http.Handler {
+	return &createUserAPI{store}
 }
 
 func (c *createUserAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
 	email := r.FormValue("email")
 	authHash := r.FormValue("auth-hash")
+	kdf, err := parseKDF(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	user, err := db.CreateUser(r.Context(), c.db, email, []byte(authHash))
+	user, err := c.store.CreateUser(r.Context(), email, []byte(authHash), kdf)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4,35 +4,39 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
+// a UserResponse is a user along with what their vault holds against the quotas
+type UserResponse struct {
+	*db.User
+	Usage db.Usage
+}
+
 type getUserAPI struct {
-	db *gorm.DB
+	store db.Store
 }
 
-func GetUserAPI(db *gorm.DB) http.Handler {
-	return &getUserAPI{db}
+func GetUserAPI(store db.Store) http.Handler {
+	return &getUserAPI{store}
 }
 
 func (c *getUserAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
-	email := r.FormValue("email")
-	authHash := r.FormValue("auth-hash")
-
-	user, err := db.GetUser(r.Context(), c.db, email, []byte(authHash))
+	user, err := auth.User(r, c.store)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	b, err := json.Marshal(user)
+	b, err := json.Marshal(UserResponse{User: user, Usage: db.VaultUsage(&user.Vault)})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
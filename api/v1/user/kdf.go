@@ -0,0 +1,157 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
+	"github.com/rokusei/gopass-server/db"
+)
+
+// parseKDF reads the kdf form values, requests without them describe the
+// PBKDF2 derivation of clients that predate stored KDF parameters
+func parseKDF(r *http.Request) (db.KDF, error) {
+	if r.FormValue("kdf") == "" {
+		return db.LegacyKDF(), nil
+	}
+
+	kdf := db.KDF{Algorithm: r.FormValue("kdf")}
+	for _, field := range []struct {
+		name  string
+		value *uint
+	}{
+		{"kdf-iterations", &kdf.Iterations},
+		{"kdf-memory", &kdf.Memory},
+		{"kdf-parallelism", &kdf.Parallelism},
+	} {
+		v := r.FormValue(field.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return db.KDF{}, err
+		}
+		*field.value = uint(n)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(r.FormValue("kdf-salt"))
+	if err != nil {
+		return db.KDF{}, err
+	}
+	if len(salt) > 0 {
+		kdf.Salt = salt
+	}
+	return kdf, nil
+}
+
+type preloginAPI struct {
+	store db.Store
+	key   []byte
+}
+
+// PreloginAPI returns the KDF parameters of a user, key makes up the salt of
+// unknown emails
+func PreloginAPI(store db.Store, key []byte) http.Handler {
+	return &preloginAPI{store, key}
+}
+
+func (p *preloginAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	kdf, err := p.store.GetKDF(r.Context(), p.key, r.FormValue("email"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(kdf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(b)
+}
+
+type updateKDFAPI struct {
+	store   db.Store
+	padding padding.Policy
+}
+
+func UpdateKDFAPI(store db.Store, padding padding.Policy) http.Handler {
+	return &updateKDFAPI{store, padding}
+}
+
+func (u *updateKDFAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	kdf, err := parseKDF(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	newAuthHash := r.FormValue("new-auth-hash")
+
+	// every entry is re-encrypted with the new key
+	var ops []db.BatchOperation
+	if v := r.FormValue("operations"); v != "" {
+		err = json.Unmarshal([]byte(v), &ops)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = u.padding.CheckOperations(ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = db.Quotas.CheckOperations(ops)
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	schemaVersion, err := auth.SchemaVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the current AuthenticationHash is required, a session token alone could take over the account
+	user, err := auth.ReauthenticatedUser(r, u.store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = u.store.UpdateUserKDF(r.Context(), user, kdf, []byte(newAuthHash), ops, schemaVersion)
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	// sessions started with the old AuthenticationHash end, but the one making the change
+	err = u.store.DeleteUserSessions(r.Context(), user, auth.BearerToken(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package entry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
+	"github.com/rokusei/gopass-server/db"
+)
+
+// a BatchResponse lists the result of every operation of a batch, in order
+type BatchResponse struct {
+	// Committed is false when an operation failed and the batch was rolled back
+	Committed bool
+	Results   []db.BatchResult
+}
+
+type vaultEntryBatchAPI struct {
+	store   db.Store
+	padding padding.Policy
+}
+
+func VaultEntryBatchAPI(store db.Store, padding padding.Policy) http.Handler {
+	return &vaultEntryBatchAPI{store, padding}
+}
+
+func (b *vaultEntryBatchAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	var ops []db.BatchOperation
+	err = json.Unmarshal([]byte(r.FormValue("operations")), &ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = b.padding.CheckOperations(ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = db.Quotas.CheckOperations(ops)
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	schemaVersion, err := auth.SchemaVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Get the user, which in the process authenticates the request
+	user, err := auth.VerifiedUser(r, b.store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Apply every operation, or none of them
+	results, err := b.store.ApplyVaultEntryBatch(r.Context(), user, ops, schemaVersion)
+	if err != nil && !errors.Is(err, db.ErrBatchFailed) {
+		quota.Error(w, err)
+		return
+	}
+
+	committed := err == nil
+	res, err := json.Marshal(BatchResponse{Committed: committed, Results: results})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
@@ -4,40 +4,59 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
 type createVaultEntryAPI struct {
-	db *gorm.DB
+	store   db.Store
+	padding padding.Policy
 }
 
-func CreateVaultEntryAPI(db *gorm.DB) http.Handler {
-	return &createVaultEntryAPI{db}
+func CreateVaultEntryAPI(store db.Store, padding padding.Policy) http.Handler {
+	return &createVaultEntryAPI{store, padding}
 }
 
 func (c *createVaultEntryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
-	email := r.FormValue("email")
-	authHash := r.FormValue("auth-hash")
 	encEntry := r.FormValue("encrypted-entry")
 
+	err = c.padding.Check([]byte(encEntry))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = db.Quotas.CheckEntries([]byte(encEntry))
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	schemaVersion, err := auth.SchemaVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Get the user, which in the process authenticates the request
-	user, err := db.GetVerifiedUser(r.Context(), c.db, email, []byte(authHash))
+	user, err := auth.VerifiedUser(r, c.store)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Create the vault entry
-	entry, err := db.CreateVaultEntry(r.Context(), c.db, user, []byte(encEntry))
+	entry, err := c.store.CreateVaultEntry(r.Context(), user, []byte(encEntry), schemaVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
@@ -0,0 +1,43 @@
+package entry
+
+import (
+	"net/http"
+
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
+	"github.com/rokusei/gopass-server/db"
+)
+
+type deleteVaultEntryAPI struct {
+	store db.Store
+}
+
+func DeleteVaultEntryAPI(store db.Store) http.Handler {
+	return &deleteVaultEntryAPI{store}
+}
+
+func (d *deleteVaultEntryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	entryUUID := r.FormValue("entry-uuid")
+
+	// Get the user, which in the process authenticates the request
+	user, err := auth.VerifiedUser(r, d.store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Delete the specified entry by UUID
+	err = d.store.DeleteVaultEntry(r.Context(), user, entryUUID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
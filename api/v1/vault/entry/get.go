@@ -4,38 +4,37 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
 type getVaultEntryAPI struct {
-	db *gorm.DB
+	store db.Store
 }
 
-func GetVaultEntryAPI(db *gorm.DB) http.Handler {
-	return &getVaultEntryAPI{db}
+func GetVaultEntryAPI(store db.Store) http.Handler {
+	return &getVaultEntryAPI{store}
 }
 
 func (c *getVaultEntryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
-	email := r.FormValue("email")
-	authHash := r.FormValue("auth-hash")
 	entryUUID := r.FormValue("entry-uuid")
 
 	// Get the user, which in the process authenticates the request
-	user, err := db.GetVerifiedUser(r.Context(), c.db, email, []byte(authHash))
+	user, err := auth.VerifiedUser(r, c.store)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get the requested vault entry by UUID
-	entry, err := db.GetVaultEntry(r.Context(), c.db, user, entryUUID)
+	entry, err := c.store.GetVaultEntry(r.Context(), user, entryUUID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
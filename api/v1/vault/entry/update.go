@@ -4,41 +4,60 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
 type updateVaultEntryAPI struct {
-	db *gorm.DB
+	store   db.Store
+	padding padding.Policy
 }
 
-func UpdateVaultEntryAPI(db *gorm.DB) http.Handler {
-	return &updateVaultEntryAPI{db}
+func UpdateVaultEntryAPI(store db.Store, padding padding.Policy) http.Handler {
+	return &updateVaultEntryAPI{store, padding}
 }
 
 func (u *updateVaultEntryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
-	email := r.FormValue("email")
-	authHash := r.FormValue("auth-hash")
 	entryUUID := r.FormValue("entry-uuid")
 	encEntry := r.FormValue("encrypted-entry")
 
+	err = u.padding.Check([]byte(encEntry))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = db.Quotas.CheckEntries([]byte(encEntry))
+	if err != nil {
+		quota.Error(w, err)
+		return
+	}
+
+	schemaVersion, err := auth.SchemaVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Get the user, which in the process authenticates the request
-	user, err := db.GetVerifiedUser(r.Context(), u.db, email, []byte(authHash))
+	user, err := auth.VerifiedUser(r, u.store)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Update the specified entry by UUID
-	entry, err := db.UpdateVaultEntry(r.Context(), u.db, user, entryUUID, []byte(encEntry))
+	entry, err := u.store.UpdateVaultEntry(r.Context(), user, entryUUID, []byte(encEntry), schemaVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
@@ -2,41 +2,39 @@ package vault
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"github.com/rokusei/gopass-server/api/quota"
+	"github.com/rokusei/gopass-server/api/v1/auth"
 	"github.com/rokusei/gopass-server/db"
-	"gorm.io/gorm"
 )
 
 type getVaultAPI struct {
-	db *gorm.DB
+	store db.Store
 }
 
-func GetVaultAPI(db *gorm.DB) http.Handler {
-	return &getVaultAPI{db}
+func GetVaultAPI(store db.Store) http.Handler {
+	return &getVaultAPI{store}
 }
 
 func (c *getVaultAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		quota.Error(w, err)
 		return
 	}
 
-	email := r.FormValue("email")
-	authHash := r.FormValue("auth-hash")
-
-	//fmt.Printf("email: %s, authHash: %s", email, authHash)
-
-	user, err := db.GetVerifiedUser(r.Context(), c.db, email, []byte(authHash))
+	user, err := auth.VerifiedUser(r, c.store)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	vault, err := db.GetVault(r.Context(), c.db, user)
-	fmt.Printf("user: %#v\nvault: %#v\nvault entries: %#v", user, vault, vault.VaultEntries)
+	vault, err := c.store.GetVault(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	b, err := json.Marshal(vault)
 	if err != nil {
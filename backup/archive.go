@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// an archive is a header line, a base64 Ed25519 signature line and the gzip
+// compressed JSON snapshot the signature covers. the whole archive may be
+// encrypted to one or more age recipients
+const archiveHeader = "gopass-server backup v1\n"
+
+// every age file starts with this line
+const ageHeader = "age-encryption.org/"
+
+var ErrNotAnArchive = errors.New("not a gopass-server backup")
+var ErrInvalidSignature = errors.New("backup signature is invalid")
+var ErrEncrypted = errors.New("backup is encrypted, an age identity is required")
+
+// Write signs the snapshot with key and writes it to w as an archive,
+// encrypted to recipients if any are given
+func Write(w io.Writer, snap *Snapshot, key ed25519.PrivateKey, recipients ...age.Recipient) (err error) {
+	var payload bytes.Buffer
+	zw := gzip.NewWriter(&payload)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	sig := ed25519.Sign(key, payload.Bytes())
+
+	if len(recipients) > 0 {
+		aw, err := age.Encrypt(w, recipients...)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := aw.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = aw
+	}
+
+	if _, err := io.WriteString(w, archiveHeader); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, base64.StdEncoding.EncodeToString(sig)+"\n"); err != nil {
+		return err
+	}
+	_, err = w.Write(payload.Bytes())
+	return err
+}
+
+// Read decrypts an archive with identities if it is encrypted, verifies its
+// signature against key and decodes the snapshot
+func Read(r io.Reader, key ed25519.PublicKey, identities ...age.Identity) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	prefix, err := br.Peek(len(ageHeader))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(prefix) == ageHeader {
+		if len(identities) == 0 {
+			return nil, ErrEncrypted
+		}
+		ar, err := age.Decrypt(br, identities...)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(ar)
+	}
+
+	header, err := br.ReadString('\n')
+	if err != nil || header != archiveHeader {
+		return nil, ErrNotAnArchive
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, ErrNotAnArchive
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return nil, ErrNotAnArchive
+	}
+	payload, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, snap.Version)
+	}
+	return &snap, nil
+}
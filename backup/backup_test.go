@@ -0,0 +1,149 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/rokusei/gopass-server/backup"
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T, name string) *gorm.DB {
+	gdb, err := db.Open("sqlite", "file:"+name+"?mode=memory&cache=shared", &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	return gdb
+}
+
+// seed creates two users with a couple of entries, one of them deleted, and
+// data keys as if their vaults were encrypted at rest
+func seed(t *testing.T, gdb *gorm.DB) {
+	ctx := context.Background()
+	require.NoError(t, db.Migrate(gdb))
+
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		authHash := sha256.Sum256([]byte(email))
+		u, err := db.CreateUser(ctx, gdb, email, append(authHash[:], authHash[:]...), db.DefaultKDF([]byte("salt of "+email)))
+		require.NoError(t, err)
+		_, err = db.CreateVaultEntry(ctx, gdb, u, []byte("ciphertext of "+email), 1)
+		require.NoError(t, err)
+		e, err := db.CreateVaultEntry(ctx, gdb, u, []byte("deleted"), 1)
+		require.NoError(t, err)
+		require.NoError(t, db.DeleteVaultEntry(ctx, gdb, u, e.UUID))
+		require.NoError(t, db.CreateDataKey(ctx, gdb, &db.DataKey{VaultID: u.Vault.ID, MasterKeyID: "one", WrappedKey: []byte("wrapped key of " + email)}))
+	}
+}
+
+func signingKeys(t *testing.T) ([]byte, []byte) {
+	priv, pub, err := backup.GenerateKey()
+	require.NoError(t, err)
+	return priv, pub
+}
+
+func Test_BackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src := openDB(t, t.Name()+"-src")
+	seed(t, src)
+
+	snap, err := backup.Dump(ctx, src)
+	require.NoError(t, err)
+	require.Len(t, snap.Users, 2)
+	require.Len(t, snap.Vaults, 2)
+	require.Len(t, snap.Entries, 4)
+	require.Len(t, snap.DataKeys, 2)
+
+	privPEM, pubPEM := signingKeys(t)
+	priv, err := backup.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pub, err := backup.ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, backup.Write(&buf, snap, priv, identity.Recipient()))
+	require.NotContains(t, buf.String(), "gopass-server backup")
+
+	_, err = backup.Read(bytes.NewReader(buf.Bytes()), pub)
+	require.Equal(t, backup.ErrEncrypted, err)
+
+	restored, err := backup.Read(bytes.NewReader(buf.Bytes()), pub, identity)
+	require.NoError(t, err)
+
+	dst := openDB(t, t.Name()+"-dst")
+	require.NoError(t, backup.Restore(ctx, dst, restored))
+
+	again, err := backup.Dump(ctx, dst)
+	require.NoError(t, err)
+	again.CreatedAt = snap.CreatedAt
+	require.Equal(t, snap, again)
+
+	// restored users can still log in and see their live entries only
+	authHash := sha256.Sum256([]byte("a@example.com"))
+	u, err := db.GetUser(ctx, dst, "a@example.com", append(authHash[:], authHash[:]...))
+	require.NoError(t, err)
+	require.Len(t, u.Vault.VaultEntries, 1)
+	require.Equal(t, []byte("ciphertext of a@example.com"), u.Vault.VaultEntries[0].EncryptedEntry)
+
+	// new rows don't collide with the restored IDs
+	_, err = db.CreateVaultEntry(ctx, dst, u, []byte("new"), 1)
+	require.NoError(t, err)
+
+	require.Equal(t, backup.ErrDatabaseNotEmpty, backup.Restore(ctx, dst, restored))
+}
+
+func Test_RestoreLeavesDatabaseWithData(t *testing.T) {
+	ctx := context.Background()
+	src := openDB(t, t.Name()+"-src")
+	seed(t, src)
+	snap, err := backup.Dump(ctx, src)
+	require.NoError(t, err)
+
+	// a database of an older schema holding data isn't migrated
+	dst := openDB(t, t.Name()+"-dst")
+	require.NoError(t, db.MigrateTo(ctx, dst, 1))
+	require.NoError(t, dst.Exec("INSERT INTO vaults (uuid) VALUES (?)", "existing").Error)
+	require.Equal(t, backup.ErrDatabaseNotEmpty, backup.Restore(ctx, dst, snap))
+	version, err := db.SchemaVersion(ctx, dst)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), version)
+}
+
+func Test_ReadRejectsTampering(t *testing.T) {
+	privPEM, pubPEM := signingKeys(t)
+	priv, err := backup.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pub, err := backup.ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+
+	snap := &backup.Snapshot{Version: backup.FormatVersion, Vaults: []backup.Vault{{ID: 1, UUID: "v"}}}
+	var buf bytes.Buffer
+	require.NoError(t, backup.Write(&buf, snap, priv))
+
+	got, err := backup.Read(bytes.NewReader(buf.Bytes()), pub)
+	require.NoError(t, err)
+	require.Equal(t, "v", got.Vaults[0].UUID)
+
+	b := buf.Bytes()
+	b[len(b)-5] ^= 0xff
+	_, err = backup.Read(bytes.NewReader(b), pub)
+	require.Equal(t, backup.ErrInvalidSignature, err)
+
+	_, otherPub := signingKeys(t)
+	other, err := backup.ParsePublicKey(otherPub)
+	require.NoError(t, err)
+	b[len(b)-5] ^= 0xff
+	_, err = backup.Read(bytes.NewReader(b), other)
+	require.Equal(t, backup.ErrInvalidSignature, err)
+
+	_, err = backup.Read(bytes.NewReader([]byte("SQLite format 3\x00")), pub)
+	require.Equal(t, backup.ErrNotAnArchive, err)
+}
@@ -0,0 +1,324 @@
+// Package backup dumps the contents of a gopass-server database into a
+// portable snapshot and restores it into an empty database of any supported
+// driver. Vault entries stay encrypted with the users' EncryptionKeys, the
+// snapshot holds the same data the database does, entries encrypted at rest
+// stay so along with their wrapped data keys.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rokusei/gopass-server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FormatVersion is the version of the snapshot format written by Dump,
+// version 2 added DataKeys and Restore still reads version 1
+const FormatVersion = 2
+
+var ErrUnsupportedVersion = errors.New("unsupported backup format version")
+var ErrDatabaseNotEmpty = errors.New("restore requires an empty database")
+
+// restored rows are inserted in batches of this size
+const batchSize = 500
+
+// a Snapshot is a consistent copy of every user, vault and vault entry.
+// sessions are not included, users log in again after a restore
+type Snapshot struct {
+	Version   int
+	CreatedAt time.Time
+	// Driver is the database driver the snapshot was dumped from
+	Driver  string
+	Users   []User
+	Vaults  []Vault
+	Entries []Entry
+	// DataKeys are the data keys of vaults encrypted at rest, still wrapped
+	// by the master key, which isn't part of the snapshot
+	DataKeys []DataKey
+}
+
+// the record types mirror the db models field by field, they are kept
+// separate so the archive format doesn't follow the API's JSON encoding,
+// which hides most of these fields
+type User struct {
+	ID                    uint
+	UUID                  string
+	EmailHash             string
+	AuthHashHash          []byte
+	AuthHashPepper        string
+	KDF                   db.KDF
+	VerificationHash      string
+	VerificationCompleted bool
+	VerificationAttempts  uint
+	VaultID               uint
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	DeletedAt             gorm.DeletedAt
+}
+
+type Vault struct {
+	ID        uint
+	UUID      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+type Entry struct {
+	ID             uint
+	UUID           string
+	VaultID        uint
+	EncryptedEntry []byte
+	SchemaVersion  uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt
+}
+
+type DataKey struct {
+	ID          uint
+	VaultID     uint
+	MasterKeyID string
+	WrappedKey  []byte
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt
+}
+
+// Dump copies the database into a Snapshot from a single read only
+// transaction, so the snapshot is consistent even while the server is running
+func Dump(ctx context.Context, gdb *gorm.DB) (*Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Driver:    gdb.Dialector.Name(),
+	}
+	err := gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// soft deleted rows are part of the database too
+		tx = tx.Unscoped().Order("id").Session(&gorm.Session{})
+
+		var vaults []db.Vault
+		if err := tx.Find(&vaults).Error; err != nil {
+			return err
+		}
+		for _, v := range vaults {
+			snap.Vaults = append(snap.Vaults, Vault{
+				ID:        v.ID,
+				UUID:      v.UUID,
+				CreatedAt: v.CreatedAt,
+				UpdatedAt: v.UpdatedAt,
+				DeletedAt: v.DeletedAt,
+			})
+		}
+
+		var users []db.User
+		if err := tx.Find(&users).Error; err != nil {
+			return err
+		}
+		for _, u := range users {
+			snap.Users = append(snap.Users, User{
+				ID:                    u.ID,
+				UUID:                  u.UUID,
+				EmailHash:             u.EmailHash,
+				AuthHashHash:          u.AuthHashHash,
+				AuthHashPepper:        u.AuthHashPepper,
+				KDF:                   u.KDF,
+				VerificationHash:      u.Verification.Hash,
+				VerificationCompleted: u.Verification.Completed,
+				VerificationAttempts:  u.Verification.Attempts,
+				VaultID:               u.VaultID,
+				CreatedAt:             u.CreatedAt,
+				UpdatedAt:             u.UpdatedAt,
+				DeletedAt:             u.DeletedAt,
+			})
+		}
+
+		var entries []db.VaultEntry
+		if err := tx.Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, e := range entries {
+			snap.Entries = append(snap.Entries, Entry{
+				ID:             e.ID,
+				UUID:           e.UUID,
+				VaultID:        e.VaultID,
+				EncryptedEntry: e.EncryptedEntry,
+				SchemaVersion:  e.SchemaVersion,
+				CreatedAt:      e.CreatedAt,
+				UpdatedAt:      e.UpdatedAt,
+				DeletedAt:      e.DeletedAt,
+			})
+		}
+
+		var keys []db.DataKey
+		if err := tx.Find(&keys).Error; err != nil {
+			return err
+		}
+		for _, k := range keys {
+			snap.DataKeys = append(snap.DataKeys, DataKey{
+				ID:          k.ID,
+				VaultID:     k.VaultID,
+				MasterKeyID: k.MasterKeyID,
+				WrappedKey:  k.WrappedKey,
+				CreatedAt:   k.CreatedAt,
+				UpdatedAt:   k.UpdatedAt,
+				DeletedAt:   k.DeletedAt,
+			})
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// checkEmpty refuses databases holding any record, tables that don't exist yet are empty
+func checkEmpty(tx *gorm.DB) error {
+	for _, m := range db.Models() {
+		if !tx.Migrator().HasTable(m) {
+			continue
+		}
+		var n int64
+		if err := tx.Unscoped().Model(m).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrDatabaseNotEmpty
+		}
+	}
+	return nil
+}
+
+// Restore migrates an empty database and inserts the snapshot into it in a
+// single transaction, keeping the original row IDs
+func Restore(ctx context.Context, gdb *gorm.DB, snap *Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if snap.Version < 1 || snap.Version > FormatVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, snap.Version)
+	}
+
+	// a database holding data is left as it is, migrations included
+	gdb = gdb.WithContext(ctx)
+	if err := checkEmpty(gdb); err != nil {
+		return err
+	}
+	if err := db.Migrate(gdb); err != nil {
+		return err
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := checkEmpty(tx); err != nil {
+			return err
+		}
+
+		// the associations are restored through their own records
+		tx = tx.Omit(clause.Associations).Session(&gorm.Session{})
+
+		vaults := make([]db.Vault, 0, len(snap.Vaults))
+		for _, v := range snap.Vaults {
+			vaults = append(vaults, db.Vault{
+				ID:    v.ID,
+				UUID:  v.UUID,
+				Model: gorm.Model{CreatedAt: v.CreatedAt, UpdatedAt: v.UpdatedAt, DeletedAt: v.DeletedAt},
+			})
+		}
+		if len(vaults) > 0 {
+			if err := tx.CreateInBatches(&vaults, batchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		users := make([]db.User, 0, len(snap.Users))
+		for _, u := range snap.Users {
+			users = append(users, db.User{
+				ID:             u.ID,
+				UUID:           u.UUID,
+				EmailHash:      u.EmailHash,
+				AuthHashHash:   u.AuthHashHash,
+				AuthHashPepper: u.AuthHashPepper,
+				KDF:            u.KDF,
+				Verification: db.Verification{
+					Hash:      u.VerificationHash,
+					Completed: u.VerificationCompleted,
+					Attempts:  u.VerificationAttempts,
+				},
+				VaultID: u.VaultID,
+				Model:   gorm.Model{CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt, DeletedAt: u.DeletedAt},
+			})
+		}
+		if len(users) > 0 {
+			if err := tx.CreateInBatches(&users, batchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		entries := make([]db.VaultEntry, 0, len(snap.Entries))
+		for _, e := range snap.Entries {
+			entries = append(entries, db.VaultEntry{
+				ID:             e.ID,
+				UUID:           e.UUID,
+				VaultID:        e.VaultID,
+				EncryptedEntry: e.EncryptedEntry,
+				SchemaVersion:  e.SchemaVersion,
+				Model:          gorm.Model{CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt, DeletedAt: e.DeletedAt},
+			})
+		}
+		if len(entries) > 0 {
+			if err := tx.CreateInBatches(&entries, batchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		keys := make([]db.DataKey, 0, len(snap.DataKeys))
+		for _, k := range snap.DataKeys {
+			keys = append(keys, db.DataKey{
+				VaultID:     k.VaultID,
+				MasterKeyID: k.MasterKeyID,
+				WrappedKey:  k.WrappedKey,
+				Model:       gorm.Model{ID: k.ID, CreatedAt: k.CreatedAt, UpdatedAt: k.UpdatedAt, DeletedAt: k.DeletedAt},
+			})
+		}
+		if len(keys) > 0 {
+			if err := tx.CreateInBatches(&keys, batchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		return resetSequences(tx)
+	})
+}
+
+// resetSequences moves the ID sequences past the restored rows, inserting
+// explicit IDs doesn't advance them on postgres
+func resetSequences(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, m := range db.Models() {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		err := tx.Exec(fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s",
+			table, tx.Statement.Quote(table),
+		)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+var ErrBatchFailed = errors.New("batch failed, no operation was applied")
+
+// a BatchOperation creates, updates or deletes a single vault entry, build
+// them with CreateOp, UpdateOp and DeleteOp
+type BatchOperation struct {
+	op   string
+	id   string
+	data []byte
+}
+
+// CreateOp stores data as a new vault entry
+func CreateOp(data []byte) BatchOperation {
+	return BatchOperation{op: "create", data: data}
+}
+
+// UpdateOp replaces the contents of the vault entry id with data
+func UpdateOp(id string, data []byte) BatchOperation {
+	return BatchOperation{op: "update", id: id, data: data}
+}
+
+// DeleteOp removes the vault entry id
+func DeleteOp(id string) BatchOperation {
+	return BatchOperation{op: "delete", id: id}
+}
+
+// a BatchResult is the outcome of the BatchOperation at the same index
+type BatchResult struct {
+	// Entry is the created or updated entry
+	Entry *Entry
+	Err   error
+}
+
+type wireBatchOperation struct {
+	Op             string
+	ID             string `json:",omitempty"`
+	EncryptedEntry []byte `json:",omitempty"`
+}
+
+type wireBatchResponse struct {
+	Committed bool
+	Results   []struct {
+		Entry *wireEntry
+		Error string
+	}
+}
+
+// Batch applies every operation in a single transaction on the server. if any
+// of them fails none are applied, ErrBatchFailed is returned and the result
+// of the failing operation says why
+func (s *Session) Batch(ctx context.Context, ops []BatchOperation) ([]BatchResult, error) {
+	if err := s.checkBatch(ctx, len(ops)); err != nil {
+		return nil, err
+	}
+	wops := make([]wireBatchOperation, 0, len(ops))
+	// a batch without creates can safely be applied twice
+	idempotent := true
+	for _, op := range ops {
+		wop := wireBatchOperation{Op: op.op, ID: op.id}
+		if op.op == "create" {
+			idempotent = false
+		}
+		if op.op != "delete" {
+			enc, err := s.seal(ctx, op.data, s.key)
+			if err != nil {
+				return nil, err
+			}
+			wop.EncryptedEntry = enc
+		}
+		wops = append(wops, wop)
+	}
+	b, err := json.Marshal(wops)
+	if err != nil {
+		return nil, err
+	}
+
+	var res wireBatchResponse
+	err = s.do(ctx, call{
+		path:       "/vault/entry/batch",
+		form:       url.Values{"operations": {string(b)}},
+		idempotent: idempotent,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, 0, len(res.Results))
+	for _, r := range res.Results {
+		var result BatchResult
+		if r.Error != "" {
+			result.Err = errors.New(r.Error)
+		}
+		if r.Entry != nil {
+			result.Entry, err = r.Entry.entry(s.key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, result)
+	}
+	if !res.Committed {
+		return results, ErrBatchFailed
+	}
+	return results, nil
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultRetries = 3
+const DefaultRetryBackoff = 250 * time.Millisecond
+
+// an Error is returned when the server responds with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gopass-server: %d %s", e.StatusCode, e.Message)
+}
+
+// a Client talks to a gopass-server over HTTP
+// all encryption and decryption happens client side, the server only ever
+// sees the AuthenticationHash and encrypted vault entries
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	mu           sync.Mutex
+	capabilities *Capabilities
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used to make requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times an idempotent request is retried after a
+// network error or an unavailable server, waiting backoff (doubled every
+// attempt) in between
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates a Client for the server at baseURL, e.g. https://vault.example.com
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retries:    DefaultRetries,
+		backoff:    DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// call is a single API request
+type call struct {
+	// method defaults to POST, GET calls send no form
+	method string
+	path   string
+	form   url.Values
+	token  string
+	// only idempotent calls are retried, retrying a create could store the entry twice
+	idempotent bool
+	// done is the error message a retry gets when an earlier attempt already
+	// succeeded but its response was lost, e.g. deleting an entry twice
+	done string
+}
+
+// do performs the call and decodes the JSON response into out, if out is not nil
+func (c *Client) do(ctx context.Context, cl call, out interface{}) error {
+	attempts := 1
+	if cl.idempotent {
+		attempts += c.retries
+	}
+
+	var err error
+	backoff := c.backoff
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = c.doOnce(ctx, cl, out)
+		if i > 0 && cl.done != "" && errorMessage(err, cl.done) {
+			return nil
+		}
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// doOnce performs a single attempt of the call, reporting whether a failure may be retried
+func (c *Client) doOnce(ctx context.Context, cl call, out interface{}) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var req *http.Request
+	var err error
+	if cl.method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+cl.path, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+cl.path, strings.NewReader(cl.form.Encode()))
+	}
+	if err != nil {
+		return false, err
+	}
+	if cl.method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if cl.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// the context ending is final, anything else is a network error
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(b)),
+		}
+		return retryable(resp.StatusCode), apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return false, nil
+	}
+	return false, json.Unmarshal(b, out)
+}
+
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// errorMessage reports whether err is a server Error with the given message
+func errorMessage(err error, msg string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Message == msg
+}
@@ -0,0 +1,572 @@
+package client_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/api"
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/client/schema"
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testEmail    = "abc@123.com"
+	testPassword = "abc123"
+)
+
+// newTestServer serves api.NewAPI backed by a fresh in-memory sqlite database,
+// requiring entries to be padded
+func newTestServer(t *testing.T) (*httptest.Server, *gorm.DB) {
+	gdb := newTestDB(t)
+	srv := httptest.NewServer(api.NewAPI(api.APIConfig{Store: db.NewGormStore(gdb), Padding: padding.Default()}))
+	t.Cleanup(srv.Close)
+	return srv, gdb
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	gdb, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Migrate(gdb))
+	return gdb
+}
+
+// register creates a verified user, returning their salt
+func register(t *testing.T, c *client.Client, gdb *gorm.DB) []byte {
+	u, salt, err := c.Register(context.Background(), testEmail, testPassword)
+	require.NoError(t, err)
+	require.False(t, u.Verified)
+
+	// there is no verification flow yet, complete it directly
+	result := gdb.Model(&db.User{}).Where("uuid = ?", u.ID).Update("verification_completed", true)
+	require.NoError(t, result.Error)
+	return salt
+}
+
+func Test_EntryLifecycle(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	e, err := s.CreateEntry(ctx, []byte("hunter2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hunter2"), e.Data)
+
+	// the server only ever stores the ciphertext
+	var stored db.VaultEntry
+	require.NoError(t, gdb.Where("uuid = ?", e.ID).First(&stored).Error)
+	require.NotContains(t, string(stored.EncryptedEntry), "hunter2")
+
+	got, err := s.Entry(ctx, e.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hunter2"), got.Data)
+
+	_, err = s.UpdateEntry(ctx, e.ID, []byte("correct horse battery staple"))
+	require.NoError(t, err)
+
+	v, err := s.Vault(ctx)
+	require.NoError(t, err)
+	require.Len(t, v.Entries, 1)
+	require.Equal(t, []byte("correct horse battery staple"), v.Entries[0].Data)
+
+	u, err := s.User(ctx)
+	require.NoError(t, err)
+	require.True(t, u.Verified)
+	require.Equal(t, v.ID, u.Vault.ID)
+	require.Len(t, u.Vault.Entries, 1)
+
+	require.NoError(t, s.DeleteEntry(ctx, e.ID))
+	_, err = s.Entry(ctx, e.ID)
+	require.Error(t, err)
+
+	v, err = s.Vault(ctx)
+	require.NoError(t, err)
+	require.Empty(t, v.Entries)
+
+	require.NoError(t, s.Logout(ctx))
+	token, expiresAt := s.Token()
+	_, err = c.Resume(token, expiresAt, s.Key()).Vault(ctx)
+	require.Error(t, err)
+}
+
+func Test_LoginWrongPassword(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	c := client.New(srv.URL, client.WithRetries(0, 0))
+	salt := register(t, c, gdb)
+
+	_, err := c.Login(context.Background(), testEmail, "wrong", salt)
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+}
+
+func Test_SessionRenewal(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+	oldToken, _ := s.Token()
+
+	result := gdb.Model(&db.Session{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+	require.NoError(t, result.Error)
+
+	_, err = s.Vault(ctx)
+	require.NoError(t, err)
+	newToken, _ := s.Token()
+	require.NotEqual(t, oldToken, newToken)
+
+	// resumed sessions have no credentials to renew with
+	gdb.Model(&db.Session{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+	token, expiresAt := s.Token()
+	_, err = c.Resume(token, expiresAt, s.Key()).Vault(ctx)
+	require.Error(t, err)
+}
+
+func Test_Retries(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+
+	// fail every other request with 503
+	var requests int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	salt := register(t, client.New(srv.URL), gdb)
+	c := client.New(flaky.URL, client.WithRetries(1, time.Millisecond))
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	// creates are not idempotent so are never retried
+	_, err = s.CreateEntry(ctx, []byte("hunter2"))
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+
+	_, err = s.Vault(ctx)
+	require.NoError(t, err)
+}
+
+func Test_DeleteRetried(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+
+	// the first delete is applied but its response is lost
+	var lost int32
+	lossy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vault/entry/delete" && atomic.AddInt32(&lost, 1) == 1 {
+			srv.Config.Handler.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer lossy.Close()
+
+	salt := register(t, client.New(srv.URL), gdb)
+	c := client.New(lossy.URL, client.WithRetries(1, time.Millisecond))
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	e, err := s.CreateEntry(ctx, []byte("hunter2"))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteEntry(ctx, e.ID))
+
+	// deleting a missing entry without a retry still fails
+	require.Error(t, s.DeleteEntry(ctx, e.ID))
+}
+
+func Test_CreateEntries(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	entries, err := s.CreateEntries(ctx, [][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, []byte("two"), entries[1].Data)
+
+	v, err := s.Vault(ctx)
+	require.NoError(t, err)
+	require.Len(t, v.Entries, 3)
+	require.Equal(t, []byte("three"), v.Entries[2].Data)
+}
+
+func Test_Batch(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	keep, err := s.CreateEntry(ctx, []byte("keep"))
+	require.NoError(t, err)
+	drop, err := s.CreateEntry(ctx, []byte("drop"))
+	require.NoError(t, err)
+
+	results, err := s.Batch(ctx, []client.BatchOperation{
+		client.CreateOp([]byte("new")),
+		client.UpdateOp(keep.ID, []byte("kept")),
+		client.DeleteOp(drop.ID),
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, []byte("new"), results[0].Entry.Data)
+	require.Equal(t, []byte("kept"), results[1].Entry.Data)
+	require.Nil(t, results[2].Entry)
+	require.NoError(t, results[2].Err)
+
+	// a single failing operation rolls back the whole batch
+	results, err = s.Batch(ctx, []client.BatchOperation{
+		client.UpdateOp(keep.ID, []byte("lost")),
+		client.DeleteOp(drop.ID),
+	})
+	require.Equal(t, client.ErrBatchFailed, err)
+	require.Error(t, results[0].Err)
+	require.EqualError(t, results[1].Err, "entry not found")
+
+	v, err := s.Vault(ctx)
+	require.NoError(t, err)
+	require.Len(t, v.Entries, 2)
+	require.Equal(t, []byte("kept"), v.Entries[0].Data)
+	require.Equal(t, []byte("new"), v.Entries[1].Data)
+}
+
+func Test_SchemaVersion(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	r := schema.New(schema.TypeCard)
+	r.Name = "visa"
+	r.Card = &schema.Card{Number: "4111111111111111", Expiry: "12/2030"}
+	e, err := s.CreateRecord(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, schema.Version, e.SchemaVersion)
+
+	got, err := s.Entry(ctx, e.ID)
+	require.NoError(t, err)
+	gotRecord, err := got.Record()
+	require.NoError(t, err)
+	require.Equal(t, "4111111111111111", gotRecord.Card.Number)
+
+	// an entry written by a newer client locks this one out of the vault
+	result := gdb.Model(&db.VaultEntry{}).Where("uuid = ?", e.ID).Update("schema_version", schema.Version+1)
+	require.NoError(t, result.Error)
+	_, err = s.Vault(ctx)
+	require.Equal(t, client.ErrClientTooOld, err)
+}
+
+func Test_Prelogin(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+
+	kdf, err := c.Prelogin(ctx, testEmail)
+	require.NoError(t, err)
+	require.Equal(t, client.KDFArgon2id, kdf.Algorithm)
+	require.Equal(t, salt, kdf.Salt)
+
+	// the salt is stored by the server, logging in doesn't need it
+	_, err = c.Login(ctx, testEmail, testPassword, nil)
+	require.NoError(t, err)
+
+	// unknown emails get made up parameters that don't change between calls
+	unknown, err := c.Prelogin(ctx, "nobody@123.com")
+	require.NoError(t, err)
+	again, err := c.Prelogin(ctx, "nobody@123.com")
+	require.NoError(t, err)
+	require.Equal(t, unknown, again)
+	require.Equal(t, kdf.Algorithm, unknown.Algorithm)
+	require.Len(t, unknown.Salt, len(kdf.Salt))
+	require.NotEqual(t, kdf.Salt, unknown.Salt)
+}
+
+func Test_UpgradeKDF(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+
+	// an account registered before the server stored KDF parameters
+	salt := []byte("legacy salt")
+	cr, err := client.DeriveCredentials(testEmail, testPassword, salt)
+	require.NoError(t, err)
+	_, err = db.CreateUser(ctx, gdb, testEmail, cr.AuthHash, db.LegacyKDF())
+	require.NoError(t, err)
+	require.NoError(t, gdb.Model(&db.User{}).Where("1 = 1").Update("verification_completed", true).Error)
+
+	_, err = c.Login(ctx, testEmail, testPassword, nil)
+	require.Equal(t, client.ErrSaltRequired, err)
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+	e, err := s.CreateEntry(ctx, []byte("hunter2"))
+	require.NoError(t, err)
+	other, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	// the session token alone doesn't allow the change
+	token, _ := s.Token()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/user/kdf/update", strings.NewReader(url.Values{"new-auth-hash": {"x"}, "algorithm": {"argon2id"}}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotEqual(t, http.StatusNoContent, resp.StatusCode)
+
+	require.Equal(t, client.ErrWrongMasterPassword, s.UpgradeKDF(ctx, testEmail, "wrong", salt))
+	require.NoError(t, s.UpgradeKDF(ctx, testEmail, testPassword, salt))
+
+	// other sessions end with the old AuthenticationHash
+	_, err = other.Vault(ctx)
+	require.Error(t, err)
+
+	// the session carries on with the new key
+	got, err := s.Entry(ctx, e.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hunter2"), got.Data)
+
+	s, err = c.Login(ctx, testEmail, testPassword, nil)
+	require.NoError(t, err)
+	u, err := s.User(ctx)
+	require.NoError(t, err)
+	require.Equal(t, client.KDFArgon2id, u.KDF.Algorithm)
+	require.Len(t, u.Vault.Entries, 1)
+	require.Equal(t, []byte("hunter2"), u.Vault.Entries[0].Data)
+}
+
+func Test_Padding(t *testing.T) {
+	srv, gdb := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	capabilities, err := c.Capabilities(ctx)
+	require.NoError(t, err)
+	require.Equal(t, padding.Default().Buckets, capabilities.Padding.Buckets)
+
+	// a PIN and a password are as large as each other
+	for data, size := range map[string]int{"1234": 128, "correct horse battery staple": 128, strings.Repeat("k", 200): 256} {
+		e, err := s.CreateEntry(ctx, []byte(data))
+		require.NoError(t, err)
+		require.Equal(t, []byte(data), e.Data)
+
+		var stored db.VaultEntry
+		require.NoError(t, gdb.Where("uuid = ?", e.ID).First(&stored).Error)
+		require.Len(t, stored.EncryptedEntry, size, data)
+	}
+
+	// unpadded entries are refused
+	token, _ := s.Token()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/vault/entry/create", strings.NewReader(url.Values{"encrypted-entry": {"unpadded"}}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_PaddingOlderServer(t *testing.T) {
+	gdb := newTestDB(t)
+	apiHandler := api.NewAPI(api.APIConfig{Store: db.NewGormStore(gdb)})
+	// servers predating /v1/server don't pad
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/server" {
+			http.NotFound(w, r)
+			return
+		}
+		apiHandler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	e, err := s.CreateEntry(ctx, []byte("1234"))
+	require.NoError(t, err)
+	var stored db.VaultEntry
+	require.NoError(t, gdb.Where("uuid = ?", e.ID).First(&stored).Error)
+	require.Len(t, stored.EncryptedEntry, 32)
+}
+
+// newServerWithCapabilities serves api.NewAPI backed by gdb, advertising the
+// given JSON on /v1/server instead of its own capabilities
+func newServerWithCapabilities(t *testing.T, gdb *gorm.DB, capabilities string) *httptest.Server {
+	apiHandler := api.NewAPI(api.APIConfig{Store: db.NewGormStore(gdb)})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/server" {
+			w.Write([]byte(capabilities))
+			return
+		}
+		apiHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_Capabilities(t *testing.T) {
+	srv, _ := newTestServer(t)
+	capabilities, err := client.New(srv.URL).Capabilities(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, api.Version, capabilities.Version)
+	require.Equal(t, []string{client.APIVersion}, capabilities.APIVersions)
+	require.Equal(t, db.MaxBatchOperations, capabilities.Limits.MaxBatchOperations)
+	require.Equal(t, client.KDFArgon2id, capabilities.KDF.Default.Algorithm)
+	require.False(t, capabilities.Features.Sharing)
+}
+
+func Test_IncompatibleServer(t *testing.T) {
+	gdb := newTestDB(t)
+	srv := newServerWithCapabilities(t, gdb, `{"APIVersions":["v2"]}`)
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	_, _, err := c.Register(ctx, testEmail, testPassword)
+	require.ErrorIs(t, err, client.ErrIncompatibleServer)
+	_, err = c.Login(ctx, testEmail, testPassword, nil)
+	require.ErrorIs(t, err, client.ErrIncompatibleServer)
+
+	var count int64
+	require.NoError(t, gdb.Model(&db.User{}).Count(&count).Error)
+	require.Zero(t, count)
+}
+
+func Test_ServerLimits(t *testing.T) {
+	gdb := newTestDB(t)
+	srv := newServerWithCapabilities(t, gdb, `{"APIVersions":["v1"],"Limits":{"MaxEntrySize":64,"MaxBatchOperations":2}}`)
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	_, err = s.CreateEntry(ctx, make([]byte, 200))
+	require.ErrorIs(t, err, client.ErrLimitExceeded)
+	_, err = s.CreateEntries(ctx, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	require.ErrorIs(t, err, client.ErrLimitExceeded)
+	_, err = s.Batch(ctx, []client.BatchOperation{client.DeleteOp("a"), client.DeleteOp("b"), client.DeleteOp("c")})
+	require.ErrorIs(t, err, client.ErrLimitExceeded)
+
+	entries, err := s.CreateEntries(ctx, [][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func Test_RegisterServerKDF(t *testing.T) {
+	gdb := newTestDB(t)
+	// the server asks for more memory but fewer iterations than the client's defaults
+	srv := newServerWithCapabilities(t, gdb, `{"APIVersions":["v1"],"KDF":{"Default":{"Algorithm":"argon2id","Iterations":2,"Memory":131072,"Parallelism":4}}}`)
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+	kdf, err := c.Prelogin(ctx, testEmail)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), kdf.Iterations)
+	require.Equal(t, uint32(131072), kdf.Memory)
+
+	_, err = c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+}
+
+func Test_Quotas(t *testing.T) {
+	db.Quotas = db.Quota{MaxEntrySize: 256, MaxEntries: 2}
+	t.Cleanup(func() { db.Quotas = db.Quota{} })
+	gdb := newTestDB(t)
+	srv := httptest.NewServer(api.NewAPI(api.APIConfig{Store: db.NewGormStore(gdb), Padding: padding.Default(), MaxBodySize: 4096}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	salt := register(t, c, gdb)
+	s, err := c.Login(ctx, testEmail, testPassword, salt)
+	require.NoError(t, err)
+
+	capabilities, err := c.Capabilities(ctx)
+	require.NoError(t, err)
+	require.Equal(t, client.Limits{MaxBodySize: 4096, MaxEntrySize: 256, MaxEntries: 2, MaxBatchOperations: db.MaxBatchOperations}, capabilities.Limits)
+
+	_, err = s.CreateEntries(ctx, [][]byte{[]byte("one"), []byte("two")})
+	require.NoError(t, err)
+	// the server refuses a third entry
+	_, err = s.CreateEntry(ctx, []byte("three"))
+	require.ErrorIs(t, err, client.ErrLimitExceeded)
+
+	u, err := s.User(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &client.Usage{Entries: 2, EntryBytes: 256, Quota: client.Quota{MaxEntrySize: 256, MaxEntries: 2}}, u.Usage)
+
+	// entries and bodies over the limits are refused with 413
+	token, _ := s.Token()
+	for _, body := range []string{
+		url.Values{"encrypted-entry": {strings.Repeat("x", 512)}}.Encode(),
+		url.Values{"encrypted-entry": {strings.Repeat("x", 8192)}}.Encode(),
+	} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/vault/entry/create", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+
+	// and so are bodies of unknown length, once read past the limit
+	for _, path := range []string{"/user", "/user/create", "/user/kdf/update", "/prelogin", "/session/create", "/vault", "/vault/entry", "/vault/entry/delete"} {
+		body := ioutil.NopCloser(strings.NewReader(url.Values{"email": {strings.Repeat("x", 8192)}}.Encode()))
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+
+	"github.com/rokusei/gopass"
+)
+
+// frameHeaderSize is the size of the plaintext length prefix
+const frameHeaderSize = 4
+
+// gopass.Decrypt can't always tell its padding apart from the plaintext, it
+// leaves 15 and 16 byte padding in place and strips trailing plaintext bytes
+// that look like padding. To get back exactly what was encrypted, plaintext
+// is framed with its length and padded with at least one zero byte, which
+// gopass never mistakes for padding, before being encrypted. The zero bytes
+// also pad the frame so that the encrypted entry, the IV and the encrypted
+// frame, is as large as a bucket of the server's padding
+func frame(data []byte, padding Padding) []byte {
+	n := frameHeaderSize + len(data) + 1
+	if n%aes.BlockSize != 0 {
+		n += aes.BlockSize - n%aes.BlockSize
+	}
+	n = padding.size(aes.BlockSize+n) - aes.BlockSize
+
+	b := make([]byte, n)
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[frameHeaderSize:], data)
+	return b
+}
+
+// unframe returns the plaintext of a frame, anything that isn't a valid
+// frame was written by a different client and is returned as is
+func unframe(b []byte) []byte {
+	if len(b) < frameHeaderSize+1 {
+		return b
+	}
+	n := int(binary.BigEndian.Uint32(b))
+	if n > len(b)-frameHeaderSize-1 {
+		return b
+	}
+	for _, p := range b[frameHeaderSize+n:] {
+		if p != 0 {
+			return b
+		}
+	}
+	return b[frameHeaderSize : frameHeaderSize+n]
+}
+
+// seal frames, pads and encrypts the plaintext of an entry
+func seal(data []byte, key gopass.EncryptionKey, padding Padding) ([]byte, error) {
+	return gopass.Encrypt(frame(data, padding), key)
+}
+
+// open decrypts and unframes the plaintext of an entry
+func open(enc []byte, key gopass.EncryptionKey) ([]byte, error) {
+	b, err := gopass.Decrypt(enc, key)
+	if err != nil {
+		return nil, err
+	}
+	return unframe(b), nil
+}
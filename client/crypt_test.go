@@ -0,0 +1,35 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rokusei/gopass"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SealOpen(t *testing.T) {
+	key := gopass.DeriveEncryptionKey("abc123", []byte("salt"))
+
+	// every padding length, including plaintext ending in bytes that look like padding
+	for n := 0; n < 3*16; n++ {
+		for _, fill := range []byte{'a', 0x01, 0x0f, 0x10} {
+			data := bytes.Repeat([]byte{fill}, n)
+			enc, err := seal(data, key, Padding{})
+			require.NoError(t, err)
+			got, err := open(enc, key)
+			require.NoError(t, err)
+			require.Equal(t, data, got, "%d bytes of %#x", n, fill)
+		}
+	}
+}
+
+func Test_OpenUnframed(t *testing.T) {
+	key := gopass.DeriveEncryptionKey("abc123", []byte("salt"))
+
+	enc, err := gopass.Encrypt([]byte("written by another client"), key)
+	require.NoError(t, err)
+	got, err := open(enc, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("written by another client"), got)
+}
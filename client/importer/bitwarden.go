@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// bitwarden item types, everything else is imported as a login
+const (
+	bitwardenNote     = 2
+	bitwardenCard     = 3
+	bitwardenIdentity = 4
+)
+
+type bitwardenExport struct {
+	Encrypted bool
+	Folders   []struct {
+		ID   string
+		Name string
+	}
+	Items []bitwardenItem
+}
+
+type bitwardenItem struct {
+	Type     int
+	Name     string
+	Notes    string
+	FolderID string
+	Fields   []struct {
+		Name  string
+		Value string
+	}
+	Login *struct {
+		Username string
+		Password string
+		TOTP     string
+		URIs     []struct {
+			URI string
+		}
+	}
+	Card *struct {
+		CardholderName string
+		Brand          string
+		Number         string
+		ExpMonth       string
+		ExpYear        string
+		Code           string
+	}
+	Identity map[string]string
+}
+
+// bitwarden identity properties and the fields they're imported as
+var bitwardenIdentityFields = []struct{ property, field string }{
+	{"title", "title"},
+	{"firstName", "first-name"},
+	{"middleName", "middle-name"},
+	{"lastName", "last-name"},
+	{"username", FieldUsername},
+	{"company", "company"},
+	{"email", "email"},
+	{"phone", "phone"},
+	{"address1", "address"},
+	{"address2", "address 2"},
+	{"address3", "address 3"},
+	{"city", "city"},
+	{"state", "state"},
+	{"postalCode", "postal-code"},
+	{"country", "country"},
+	{"ssn", "ssn"},
+	{"passportNumber", "passport-number"},
+	{"licenseNumber", "license-number"},
+}
+
+// Bitwarden reads an unencrypted Bitwarden JSON export
+func Bitwarden(r io.Reader) ([]Entry, error) {
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("bitwarden: %w", err)
+	}
+	if export.Encrypted {
+		return nil, ErrEncryptedExport
+	}
+
+	folders := make(map[string]string)
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+
+	entries := make([]Entry, 0, len(export.Items))
+	for _, item := range export.Items {
+		e := Entry{}
+		e.set(FieldName, item.Name)
+		e.set(FieldFolder, folders[item.FolderID])
+		e.set(FieldNotes, item.Notes)
+
+		switch item.Type {
+		case bitwardenNote:
+			e.set(FieldType, TypeNote)
+		case bitwardenCard:
+			e.set(FieldType, TypeCard)
+			if c := item.Card; c != nil {
+				e.set("cardholder", c.CardholderName)
+				e.set("brand", c.Brand)
+				e.set("number", c.Number)
+				if c.ExpMonth != "" || c.ExpYear != "" {
+					e.set("expiry", c.ExpMonth+"/"+c.ExpYear)
+				}
+				e.set("code", c.Code)
+			}
+		case bitwardenIdentity:
+			e.set(FieldType, TypeIdentity)
+			for _, f := range bitwardenIdentityFields {
+				e.set(f.field, item.Identity[f.property])
+			}
+		default:
+			if l := item.Login; l != nil {
+				e.set(FieldUsername, l.Username)
+				e.set(FieldPassword, l.Password)
+				e.set(FieldTOTP, l.TOTP)
+				for _, u := range l.URIs {
+					if e[FieldURL] == "" {
+						e.set(FieldURL, u.URI)
+					} else {
+						e.add(FieldURL, u.URI)
+					}
+				}
+			}
+		}
+
+		for _, f := range item.Fields {
+			e.add(f.Name, f.Value)
+		}
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
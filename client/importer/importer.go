@@ -0,0 +1,88 @@
+// Package importer reads the exports of other password managers and maps
+// their items onto the flat fields of schema.Record, see schema.FromFlat
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rokusei/gopass-server/client/schema"
+)
+
+// well known Entry fields, the flat field names of schema.Record
+const (
+	FieldType     = schema.FieldType
+	FieldName     = schema.FieldName
+	FieldUsername = schema.FieldUsername
+	FieldPassword = schema.FieldPassword
+	FieldURL      = schema.FieldURL
+	FieldNotes    = schema.FieldNotes
+	FieldTOTP     = schema.FieldTOTP
+	// FieldFolder holds the folder, group or vault an item was filed under,
+	// nested folders are separated by slashes
+	FieldFolder = schema.FieldFolder
+)
+
+// entry types, entries without a type are logins
+const (
+	TypeLogin    = ""
+	TypeNote     = string(schema.TypeNote)
+	TypeCard     = string(schema.TypeCard)
+	TypeIdentity = string(schema.TypeIdentity)
+	TypeSSHKey   = string(schema.TypeSSHKey)
+)
+
+// Formats lists the names of the supported export formats
+var Formats = []string{"bitwarden", "1password", "keepass", "lastpass", "pass"}
+
+var ErrEncryptedExport = errors.New("encrypted exports are not supported, export unencrypted instead")
+
+// an Entry is an imported item as a flat set of named fields
+type Entry map[string]string
+
+// set stores a field, empty values are left out
+func (e Entry) set(field, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	e[field] = value
+}
+
+// add stores a custom field, numbering it when the name is already taken
+func (e Entry) add(field, value string) {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		field = "field"
+	}
+	name := field
+	for i := 2; e[name] != ""; i++ {
+		name = fmt.Sprintf("%s %d", field, i)
+	}
+	e.set(name, value)
+}
+
+// appendNotes adds a paragraph to the entry's notes
+func (e Entry) appendNotes(notes string) {
+	notes = strings.TrimSpace(notes)
+	if notes == "" {
+		return
+	}
+	if e[FieldNotes] != "" {
+		notes = e[FieldNotes] + "\n\n" + notes
+	}
+	e[FieldNotes] = notes
+}
+
+// sortEntries orders entries by folder and name, so an import lists the same
+// way whatever order the export happened to be written in
+func sortEntries(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i][FieldFolder] != entries[j][FieldFolder] {
+			return entries[i][FieldFolder] < entries[j][FieldFolder]
+		}
+		return entries[i][FieldName] < entries[j][FieldName]
+	})
+}
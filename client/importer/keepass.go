@@ -0,0 +1,72 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// keepass standard strings and the fields they're imported as, other strings
+// become custom fields
+var keepassFields = map[string]string{
+	"Title":    FieldName,
+	"UserName": FieldUsername,
+	"Password": FieldPassword,
+	"URL":      FieldURL,
+	"Notes":    FieldNotes,
+	"otp":      FieldTOTP,
+}
+
+// KeePass reads a KDBX 3.1 or 4 database unlocked with password and, if not
+// nil, the contents of a key file
+func KeePass(r io.Reader, password string, keyFile []byte) ([]Entry, error) {
+	db := gokeepasslib.NewDatabase()
+	var err error
+	if keyFile != nil {
+		db.Credentials, err = gokeepasslib.NewPasswordAndKeyDataCredentials(password, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("keepass: %w", err)
+		}
+	} else {
+		db.Credentials = gokeepasslib.NewPasswordCredentials(password)
+	}
+
+	if err := gokeepasslib.NewDecoder(r).Decode(db); err != nil {
+		return nil, fmt.Errorf("keepass: %w", err)
+	}
+	if err := db.UnlockProtectedEntries(); err != nil {
+		return nil, fmt.Errorf("keepass: %w", err)
+	}
+
+	meta := db.Content.Meta
+	var entries []Entry
+	var walk func(g gokeepasslib.Group, folder []string)
+	walk = func(g gokeepasslib.Group, folder []string) {
+		if meta.RecycleBinEnabled.Bool && g.UUID == meta.RecycleBinUUID {
+			return
+		}
+		for _, ke := range g.Entries {
+			e := Entry{}
+			e.set(FieldFolder, strings.Join(folder, "/"))
+			for _, v := range ke.Values {
+				if field, ok := keepassFields[v.Key]; ok {
+					e.set(field, v.Value.Content)
+				} else {
+					e.add(v.Key, v.Value.Content)
+				}
+			}
+			entries = append(entries, e)
+		}
+		for _, sub := range g.Groups {
+			walk(sub, append(folder[:len(folder):len(folder)], sub.Name))
+		}
+	}
+	// the root group is named after the database, leave it out of folders
+	for _, g := range db.Content.Root.Groups {
+		walk(g, nil)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
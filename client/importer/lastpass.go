@@ -0,0 +1,139 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lastpass exports secure notes with this placeholder URL
+const lastpassNoteURL = "http://sn"
+
+// lastpass secure notes of these types hold "Key:Value" lines instead of free text
+var lastpassNoteTypes = map[string]string{
+	"Credit Card":      TypeCard,
+	"Address":          TypeIdentity,
+	"SSH Key":          TypeSSHKey,
+	"Driver's License": TypeIdentity,
+	"Passport":         TypeIdentity,
+}
+
+// lastpass note keys and the fields they're imported as, other keys are lowercased
+var lastpassNoteFields = map[string]string{
+	"Private Key":     "private-key",
+	"Public Key":      "public-key",
+	"Passphrase":      "passphrase",
+	"Number":          "number",
+	"Security Code":   "code",
+	"Name on Card":    "cardholder",
+	"Expiration Date": "expiry",
+	"Username":        FieldUsername,
+	"Password":        FieldPassword,
+}
+
+var errLastPassHeader = errors.New("lastpass: missing url,username,password header")
+
+// LastPass reads a LastPass CSV export
+func LastPass(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("lastpass: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		// the export starts with a UTF-8 byte order mark
+		h = strings.TrimPrefix(h, "\ufeff")
+		columns[strings.TrimSpace(h)] = i
+	}
+	for _, c := range []string{"url", "username", "password"} {
+		if _, ok := columns[c]; !ok {
+			return nil, errLastPassHeader
+		}
+	}
+	col := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	var entries []Entry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lastpass: %w", err)
+		}
+
+		e := Entry{}
+		e.set(FieldName, col(record, "name"))
+		e.set(FieldFolder, strings.ReplaceAll(col(record, "grouping"), `\`, "/"))
+		if col(record, "url") == lastpassNoteURL {
+			lastpassNote(e, col(record, "extra"))
+		} else {
+			e.set(FieldURL, col(record, "url"))
+			e.set(FieldUsername, col(record, "username"))
+			e.set(FieldPassword, col(record, "password"))
+			e.set(FieldTOTP, col(record, "totp"))
+			e.set(FieldNotes, col(record, "extra"))
+		}
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// lastpassNote imports a secure note, structured notes start with a
+// NoteType line followed by Key:Value lines and a free text Notes field
+func lastpassNote(e Entry, extra string) {
+	e.set(FieldType, TypeNote)
+	if !strings.HasPrefix(extra, "NoteType:") {
+		e.set(FieldNotes, extra)
+		return
+	}
+
+	// lines without a key continue the previous field, e.g. a private key
+	var last string
+	s := bufio.NewScanner(bytes.NewBufferString(extra))
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), ":", 2)
+		if len(kv) != 2 {
+			if last != "" && e[last] != "" {
+				e[last] += "\n" + s.Text()
+			} else {
+				e.appendNotes(s.Text())
+			}
+			continue
+		}
+		key, value := kv[0], kv[1]
+		last = lastpassNoteFields[key]
+		if last == "" {
+			last = strings.ToLower(key)
+		}
+		switch key {
+		case "NoteType":
+			if typ, ok := lastpassNoteTypes[value]; ok {
+				e.set(FieldType, typ)
+			}
+		case "Notes":
+			// the free text runs to the end of the note
+			var notes []string
+			notes = append(notes, value)
+			for s.Scan() {
+				notes = append(notes, s.Text())
+			}
+			e.appendNotes(strings.Join(notes, "\n"))
+		default:
+			e.add(last, value)
+		}
+	}
+}
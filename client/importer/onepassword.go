@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// the item data inside a 1PUX archive
+const onePasswordData = "export.data"
+
+// 1password item categories, everything else is imported as a login
+var onePasswordCategories = map[string]string{
+	"002": TypeCard,
+	"003": TypeNote,
+	"004": TypeIdentity,
+	"114": TypeSSHKey,
+}
+
+var errOnePasswordData = errors.New("1password: archive has no " + onePasswordData)
+
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string
+			}
+			Items []onePasswordItem
+		}
+	}
+}
+
+type onePasswordItem struct {
+	State        string
+	CategoryUUID string
+	Overview     struct {
+		Title string
+		URL   string
+		URLs  []struct {
+			URL string
+		}
+	}
+	Details struct {
+		LoginFields []struct {
+			Value       string
+			Name        string
+			Designation string
+		}
+		NotesPlain string
+		Password   string
+		Sections   []struct {
+			Title  string
+			Fields []struct {
+				Title string
+				ID    string
+				Value map[string]json.RawMessage
+			}
+		}
+	}
+}
+
+// OnePassword reads a 1Password 1PUX export, a zip archive of size bytes
+func OnePassword(r io.ReaderAt, size int64) ([]Entry, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("1password: %w", err)
+	}
+	var export onePasswordExport
+	found := false
+	for _, f := range zr.File {
+		if f.Name != onePasswordData {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(rc).Decode(&export)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("1password: %w", err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, errOnePasswordData
+	}
+
+	var entries []Entry
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				if item.State == "deleted" {
+					continue
+				}
+				e := onePasswordEntry(item)
+				e.set(FieldFolder, vault.Attrs.Name)
+				entries = append(entries, e)
+			}
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func onePasswordEntry(item onePasswordItem) Entry {
+	e := Entry{}
+	e.set(FieldType, onePasswordCategories[item.CategoryUUID])
+	e.set(FieldName, item.Overview.Title)
+	e.set(FieldURL, item.Overview.URL)
+	for _, u := range item.Overview.URLs {
+		if u.URL != e[FieldURL] {
+			e.add(FieldURL, u.URL)
+		}
+	}
+	e.set(FieldNotes, item.Details.NotesPlain)
+	e.set(FieldPassword, item.Details.Password)
+
+	for _, f := range item.Details.LoginFields {
+		switch f.Designation {
+		case "username":
+			e.set(FieldUsername, f.Value)
+		case "password":
+			e.set(FieldPassword, f.Value)
+		default:
+			e.add(f.Name, f.Value)
+		}
+	}
+
+	for _, section := range item.Details.Sections {
+		for _, f := range section.Fields {
+			value, kind := onePasswordValue(f.Value)
+			name := f.Title
+			if name == "" {
+				name = f.ID
+			}
+			switch {
+			case kind == "totp":
+				e.set(FieldTOTP, value)
+			case kind == "sshKey" && e["private-key"] == "":
+				e.set("private-key", value)
+			case f.ID == "username" && e[FieldUsername] == "":
+				e.set(FieldUsername, value)
+			default:
+				e.add(name, value)
+			}
+		}
+	}
+	return e
+}
+
+// onePasswordValue returns a field value as text along with its kind, values
+// are objects with a single key naming their kind, e.g. {"concealed": "..."}
+func onePasswordValue(v map[string]json.RawMessage) (string, string) {
+	for kind, raw := range v {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s, kind
+		}
+		var n json.Number
+		if json.Unmarshal(raw, &n) == nil {
+			return n.String(), kind
+		}
+		// structured values, e.g. ssh keys and addresses
+		var obj map[string]interface{}
+		if json.Unmarshal(raw, &obj) == nil {
+			if key, ok := obj["privateKey"].(string); ok {
+				return key, kind
+			}
+			return onePasswordObject(obj), kind
+		}
+		return string(raw), kind
+	}
+	return "", ""
+}
+
+// onePasswordObject flattens a structured value into "key: value" lines
+func onePasswordObject(obj map[string]interface{}) string {
+	var s string
+	for _, key := range sortedKeys(obj) {
+		var v string
+		switch x := obj[key].(type) {
+		case string:
+			v = x
+		case float64:
+			v = strconv.FormatFloat(x, 'f', -1, 64)
+		default:
+			continue
+		}
+		if v == "" {
+			continue
+		}
+		if s != "" {
+			s += "\n"
+		}
+		s += key + ": " + v
+	}
+	return s
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// pass(1) lines of the form "key: value" with these keys are imported as well
+// known fields, other keys become custom fields
+var passFields = map[string]string{
+	"user":     FieldUsername,
+	"username": FieldUsername,
+	"login":    FieldUsername,
+	"email":    FieldUsername,
+	"url":      FieldURL,
+	"website":  FieldURL,
+}
+
+// a Decrypter returns the plaintext of an encrypted file
+type Decrypter func(path string) ([]byte, error)
+
+// GPG decrypts files with the gpg binary, the way pass(1) does
+func GPG(path string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--quiet", "--batch", "--yes", "--decrypt", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: decrypting %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Pass reads a pass(1) password store rooted at dir, decrypting every .gpg
+// file with decrypt. entries are named after their path in the store
+func Pass(dir string, decrypt Decrypter) ([]Entry, error) {
+	var entries []Entry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// .git, .extensions and the like
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".gpg" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		plaintext, err := decrypt(path)
+		if err != nil {
+			return err
+		}
+
+		e := passEntry(plaintext)
+		folder, name := filepath.Split(strings.TrimSuffix(filepath.ToSlash(rel), ".gpg"))
+		e.set(FieldName, name)
+		e.set(FieldFolder, strings.TrimSuffix(folder, "/"))
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// passEntry parses the pass(1) convention of a password on the first line
+// followed by "key: value" lines and free text
+func passEntry(plaintext []byte) Entry {
+	e := Entry{}
+	lines := strings.Split(strings.TrimRight(string(plaintext), "\n"), "\n")
+	e.set(FieldPassword, lines[0])
+
+	var notes []string
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "otpauth://") {
+			e.set(FieldTOTP, line)
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && !strings.Contains(kv[0], " ") && !strings.HasPrefix(kv[1], "//") {
+			key := strings.ToLower(strings.TrimSpace(kv[0]))
+			if field, ok := passFields[key]; ok && e[field] == "" {
+				e.set(field, kv[1])
+			} else {
+				e.add(key, kv[1])
+			}
+			continue
+		}
+		notes = append(notes, line)
+	}
+	e.appendNotes(strings.Join(notes, "\n"))
+	return e
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/rokusei/gopass"
+	"golang.org/x/crypto/argon2"
+)
+
+// key derivation functions
+const (
+	KDFPBKDF2   = "pbkdf2-sha512"
+	KDFArgon2id = "argon2id"
+)
+
+// keySize is the size of the EncryptionKey gopass encrypts with
+const keySize = 32
+
+var ErrSaltRequired = errors.New("the salt printed at registration is required to log in to this account")
+var ErrUnsupportedKDF = errors.New("unsupported or too weak KDF parameters")
+var ErrWrongMasterPassword = errors.New("wrong master password")
+
+// a KDF describes how a user derives their keys from their master password,
+// it is stored by the server and returned by Prelogin
+type KDF struct {
+	Algorithm  string
+	Iterations uint32
+	// Memory is the Argon2id memory cost in KiB
+	Memory      uint32
+	Parallelism uint8
+	// Salt is empty for accounts registered before the server stored it,
+	// their salt was printed at registration and must be passed in instead
+	Salt []byte
+}
+
+// LegacyKDF returns the PBKDF2 parameters hard-coded in gopass
+func LegacyKDF() *KDF {
+	return &KDF{Algorithm: KDFPBKDF2, Iterations: 101101}
+}
+
+// NewKDF returns the Argon2id parameters used for new accounts, with a fresh salt
+func NewKDF() (*KDF, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &KDF{
+		Algorithm:   KDFArgon2id,
+		Iterations:  3,
+		Memory:      64 * 1024,
+		Parallelism: 4,
+		Salt:        salt,
+	}, nil
+}
+
+// newKDF returns fresh parameters for a new account, taking the stronger of
+// every Argon2id parameter of NewKDF and the server's defaults
+func (p *KDFPolicy) newKDF() (*KDF, error) {
+	kdf, err := NewKDF()
+	if err != nil {
+		return nil, err
+	}
+	d := p.Default
+	if d.Algorithm != KDFArgon2id {
+		return kdf, nil
+	}
+	stronger := *kdf
+	if d.Iterations > stronger.Iterations {
+		stronger.Iterations = d.Iterations
+	}
+	if d.Memory > stronger.Memory {
+		stronger.Memory = d.Memory
+	}
+	if d.Parallelism > stronger.Parallelism {
+		stronger.Parallelism = d.Parallelism
+	}
+	// a server can't make the client exhaust its memory
+	if stronger.check() != nil {
+		return kdf, nil
+	}
+	return &stronger, nil
+}
+
+// check refuses parameters a server could use to weaken the derived keys,
+// or to make deriving them exhaust the client's memory
+func (k *KDF) check() error {
+	switch k.Algorithm {
+	case KDFPBKDF2:
+		// gopass has its iteration count built in
+		if k.Iterations == 101101 {
+			return nil
+		}
+	case KDFArgon2id:
+		if k.Iterations >= 2 && k.Iterations <= 10 && k.Memory >= 19*1024 && k.Memory <= 1024*1024 &&
+			k.Parallelism >= 1 && len(k.Salt) >= 16 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedKDF, k.Algorithm)
+}
+
+// Credentials derives the credentials of a user from their master password
+// salt is only used when the parameters don't hold one
+func (k *KDF) Credentials(email, masterPassword string, salt []byte) (*Credentials, error) {
+	err := k.check()
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Salt) > 0 {
+		salt = k.Salt
+	}
+	if len(salt) == 0 {
+		return nil, ErrSaltRequired
+	}
+
+	var key gopass.EncryptionKey
+	switch k.Algorithm {
+	case KDFPBKDF2:
+		key = gopass.DeriveEncryptionKey(masterPassword, salt)
+	case KDFArgon2id:
+		key = argon2.IDKey([]byte(masterPassword), salt, k.Iterations, k.Memory, k.Parallelism, keySize)
+	}
+	authHash, err := gopass.DeriveAuthenticationHash(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{
+		Email:    email,
+		AuthHash: authHash,
+		Key:      key,
+	}, nil
+}
+
+func (k *KDF) form() url.Values {
+	return url.Values{
+		"kdf":             {k.Algorithm},
+		"kdf-iterations":  {strconv.FormatUint(uint64(k.Iterations), 10)},
+		"kdf-memory":      {strconv.FormatUint(uint64(k.Memory), 10)},
+		"kdf-parallelism": {strconv.FormatUint(uint64(k.Parallelism), 10)},
+		"kdf-salt":        {base64.StdEncoding.EncodeToString(k.Salt)},
+	}
+}
+
+// Prelogin fetches the KDF parameters of the account with the given email
+// the server makes up parameters for unknown emails, logging in with them fails
+func (c *Client) Prelogin(ctx context.Context, email string) (*KDF, error) {
+	if _, err := c.Capabilities(ctx); err != nil {
+		return nil, err
+	}
+	var k KDF
+	err := c.do(ctx, call{path: "/prelogin", form: url.Values{"email": {email}}, idempotent: true}, &k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// UpgradeKDF moves the session's user to fresh Argon2id parameters, at least
+// as strong as the server's defaults, every
+// vault entry is re-encrypted with the new EncryptionKey in one transaction
+// salt is only needed for accounts whose salt the server doesn't hold
+// email and the master password authenticate the change, the session token
+// alone isn't enough, and every other session of the user ends
+// The session must not be used concurrently while it is upgraded
+func (s *Session) UpgradeKDF(ctx context.Context, email, masterPassword string, salt []byte) error {
+	var u wireUser
+	err := s.do(ctx, call{path: "/user", idempotent: true}, &u)
+	if err != nil {
+		return err
+	}
+
+	// make sure the vault isn't re-encrypted with a mistyped master password
+	current := u.kdf()
+	old, err := current.Credentials(email, masterPassword, salt)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(old.Key, s.key) != 1 {
+		return ErrWrongMasterPassword
+	}
+
+	capabilities, err := s.c.Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	kdf, err := capabilities.KDF.newKDF()
+	if err != nil {
+		return err
+	}
+	cr, err := kdf.Credentials("", masterPassword, nil)
+	if err != nil {
+		return err
+	}
+
+	v, err := u.Vault.vault(s.key)
+	if err != nil {
+		return err
+	}
+	ops := make([]wireBatchOperation, 0, len(v.Entries))
+	for _, e := range v.Entries {
+		enc, err := s.seal(ctx, e.Data, cr.Key)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, wireBatchOperation{Op: "update", ID: e.ID, EncryptedEntry: enc})
+	}
+	b, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	form := kdf.form()
+	form.Set("email", old.Email)
+	form.Set("auth-hash", string(old.AuthHash))
+	form.Set("new-auth-hash", string(cr.AuthHash))
+	form.Set("operations", string(b))
+	err = s.do(ctx, call{path: "/user/kdf/update", form: form}, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = cr.Key
+	if s.creds != nil {
+		cr.Email = email
+		s.creds = cr
+	}
+	return nil
+}
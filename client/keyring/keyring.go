@@ -0,0 +1,139 @@
+package keyring
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrNotFound = errors.New("secret not found in keyring")
+
+// a Keyring stores small secrets, such as session tokens, by name
+type Keyring interface {
+	Get(name string) ([]byte, error)
+	Set(name string, secret []byte) error
+	Delete(name string) error
+}
+
+// File is a Keyring stand-in for systems without an OS keyring
+// secrets are kept in a single JSON file only readable by the current user
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultPath is keyring.json in the gopass-server directory of the user's config dir
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gopass-server", "keyring.json"), nil
+}
+
+// Default opens the File keyring at DefaultPath
+func Default() (*File, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return NewFile(path), nil
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Get(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *File) Set(name string, secret []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	secrets[name] = secret
+	return f.write(secrets)
+}
+
+func (f *File) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, name)
+	return f.write(secrets)
+}
+
+func (f *File) read() (map[string][]byte, error) {
+	secrets := make(map[string][]byte)
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &secrets)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// write replaces the keyring file atomically so a crash never leaves it half written
+func (f *File) write(secrets map[string][]byte) error {
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(f.path)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile already creates the file with 0600
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
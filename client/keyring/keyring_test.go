@@ -0,0 +1,39 @@
+package keyring_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rokusei/gopass-server/client/keyring"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gopass-server", "keyring.json")
+	kr := keyring.NewFile(path)
+
+	_, err := kr.Get("session")
+	require.ErrorIs(t, err, keyring.ErrNotFound)
+
+	require.NoError(t, kr.Set("session", []byte("token")))
+	require.NoError(t, kr.Set("other", []byte("secret")))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a fresh File sees what was written
+	secret, err := keyring.NewFile(path).Get("session")
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), secret)
+
+	require.NoError(t, kr.Delete("session"))
+	_, err = kr.Get("session")
+	require.ErrorIs(t, err, keyring.ErrNotFound)
+	require.ErrorIs(t, kr.Delete("session"), keyring.ErrNotFound)
+
+	secret, err = kr.Get("other")
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), secret)
+}
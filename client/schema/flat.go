@@ -0,0 +1,220 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flat field names, version 0 entries were flat JSON objects of string
+// fields and tools working on named fields still see records that way
+const (
+	FieldType     = "type"
+	FieldName     = "name"
+	FieldFolder   = "folder"
+	FieldNotes    = "notes"
+	FieldURL      = "url"
+	FieldUsername = "username"
+	FieldPassword = "password"
+	FieldTOTP     = "totp"
+)
+
+// the flat fields of each type, other flat fields become custom fields
+type flatField struct {
+	name string
+	get  func(r *Record) *string
+}
+
+var flatFields = map[Type][]flatField{
+	TypeLogin: {
+		{FieldUsername, func(r *Record) *string { return &r.Login.Username }},
+		{FieldPassword, func(r *Record) *string { return &r.Login.Password }},
+		{FieldTOTP, func(r *Record) *string { return &r.Login.TOTP }},
+	},
+	TypeCard: {
+		{"cardholder", func(r *Record) *string { return &r.Card.Cardholder }},
+		{"brand", func(r *Record) *string { return &r.Card.Brand }},
+		{"number", func(r *Record) *string { return &r.Card.Number }},
+		{"expiry", func(r *Record) *string { return &r.Card.Expiry }},
+		{"code", func(r *Record) *string { return &r.Card.Code }},
+	},
+	TypeIdentity: {
+		{"title", func(r *Record) *string { return &r.Identity.Title }},
+		{"first-name", func(r *Record) *string { return &r.Identity.FirstName }},
+		{"middle-name", func(r *Record) *string { return &r.Identity.MiddleName }},
+		{"last-name", func(r *Record) *string { return &r.Identity.LastName }},
+		{FieldUsername, func(r *Record) *string { return &r.Identity.Username }},
+		{"company", func(r *Record) *string { return &r.Identity.Company }},
+		{"email", func(r *Record) *string { return &r.Identity.Email }},
+		{"phone", func(r *Record) *string { return &r.Identity.Phone }},
+		{"address", func(r *Record) *string { return &r.Identity.Address }},
+		{"city", func(r *Record) *string { return &r.Identity.City }},
+		{"state", func(r *Record) *string { return &r.Identity.State }},
+		{"postal-code", func(r *Record) *string { return &r.Identity.PostalCode }},
+		{"country", func(r *Record) *string { return &r.Identity.Country }},
+		{"ssn", func(r *Record) *string { return &r.Identity.SSN }},
+		{"passport-number", func(r *Record) *string { return &r.Identity.PassportNumber }},
+		{"license-number", func(r *Record) *string { return &r.Identity.LicenseNumber }},
+	},
+	TypeSSHKey: {
+		{"private-key", func(r *Record) *string { return &r.SSHKey.PrivateKey }},
+		{"public-key", func(r *Record) *string { return &r.SSHKey.PublicKey }},
+		{"passphrase", func(r *Record) *string { return &r.SSHKey.Passphrase }},
+	},
+	TypeAPIToken: {
+		{"token", func(r *Record) *string { return &r.APIToken.Token }},
+		{"expires", func(r *Record) *string { return &r.APIToken.Expires }},
+	},
+	TypeTOTP: {
+		{FieldTOTP, func(r *Record) *string { return &r.TOTP.Seed }},
+	},
+}
+
+// the flat field of SSHKey.Confirm
+const fieldConfirm = "confirm"
+
+// withParts returns a copy of r with the part matching its type allocated
+func (r *Record) withParts() *Record {
+	c := *r
+	switch c.Type {
+	case TypeLogin:
+		if c.Login == nil {
+			c.Login = &Login{}
+		}
+	case TypeCard:
+		if c.Card == nil {
+			c.Card = &Card{}
+		}
+	case TypeIdentity:
+		if c.Identity == nil {
+			c.Identity = &Identity{}
+		}
+	case TypeSSHKey:
+		if c.SSHKey == nil {
+			c.SSHKey = &SSHKey{}
+		}
+	case TypeAPIToken:
+		if c.APIToken == nil {
+			c.APIToken = &APIToken{}
+		}
+	case TypeTOTP:
+		if c.TOTP == nil {
+			c.TOTP = &TOTP{}
+		}
+	}
+	return &c
+}
+
+// FromFlat builds a record from flat fields. the type field picks the record
+// type, logins when empty. a type this schema doesn't know, like the ones the
+// credential helpers use, makes a login keeping the type as a custom field
+func FromFlat(flat map[string]string) *Record {
+	rest := make(map[string]string, len(flat))
+	for k, v := range flat {
+		if v != "" {
+			rest[k] = v
+		}
+	}
+	take := func(name string) string {
+		v := rest[name]
+		delete(rest, name)
+		return v
+	}
+
+	typ := TypeLogin
+	if t := Type(rest[FieldType]); t != "" {
+		for _, known := range Types {
+			if t == known {
+				typ = t
+				delete(rest, FieldType)
+			}
+		}
+	}
+
+	r := New(typ).withParts()
+	r.Name = take(FieldName)
+	r.Folder = take(FieldFolder)
+	r.Notes = take(FieldNotes)
+	if u := take(FieldURL); u != "" {
+		r.URIs = append(r.URIs, URI{URI: u})
+	}
+	// further URLs were numbered, "url 2", "url 3"...
+	for i := 2; rest[fmt.Sprintf("%s %d", FieldURL, i)] != ""; i++ {
+		r.URIs = append(r.URIs, URI{URI: take(fmt.Sprintf("%s %d", FieldURL, i))})
+	}
+	for _, f := range flatFields[typ] {
+		*f.get(r) = take(f.name)
+	}
+	if typ == TypeSSHKey {
+		r.SSHKey.Confirm, _ = strconv.ParseBool(take(fieldConfirm))
+	}
+
+	names := make([]string, 0, len(rest))
+	for name := range rest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.Fields = append(r.Fields, Field{Name: name, Value: rest[name], Hidden: hiddenField(name)})
+	}
+	return r
+}
+
+// Flat returns the record as flat fields, the inverse of FromFlat. logins
+// have no type field
+func (r *Record) Flat() map[string]string {
+	flat := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			flat[name] = value
+		}
+	}
+
+	c := r.withParts()
+	if c.Type != TypeLogin && c.Type != "" {
+		set(FieldType, string(c.Type))
+	}
+	set(FieldName, c.Name)
+	set(FieldFolder, c.Folder)
+	set(FieldNotes, c.Notes)
+	for i, u := range c.URIs {
+		if i == 0 {
+			set(FieldURL, u.URI)
+		} else {
+			set(fmt.Sprintf("%s %d", FieldURL, i+1), u.URI)
+		}
+	}
+	for _, f := range flatFields[c.Type] {
+		set(f.name, *f.get(c))
+	}
+	if c.Type == TypeSSHKey && c.SSHKey.Confirm {
+		set(fieldConfirm, "true")
+	}
+	for _, f := range c.Fields {
+		set(f.Name, f.Value)
+	}
+	return flat
+}
+
+// hiddenField guesses whether a version 0 custom field holds a secret
+func hiddenField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "secret", "pin", "passphrase", "key", "token", "code"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateV0 upgrades the flat JSON objects written before the schema existed,
+// plaintext that isn't a JSON object was stored as a bare password
+func migrateV0(data []byte) ([]byte, error) {
+	flat := make(map[string]string)
+	if err := json.Unmarshal(data, &flat); err != nil {
+		flat = map[string]string{FieldPassword: string(data)}
+	}
+	return FromFlat(flat).Marshal()
+}
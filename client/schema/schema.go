@@ -0,0 +1,197 @@
+// Package schema defines the plaintext format of vault entries shared by every
+// gopass-server client. Entries are versioned JSON records, older versions
+// are migrated to the current one when they are parsed.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the schema version written by this package
+const Version = 1
+
+var ErrUnsupportedVersion = errors.New("entry schema version is newer than this client supports")
+
+// entry types
+type Type string
+
+const (
+	TypeLogin    Type = "login"
+	TypeNote     Type = "note"
+	TypeCard     Type = "card"
+	TypeIdentity Type = "identity"
+	TypeSSHKey   Type = "ssh-key"
+	TypeAPIToken Type = "api-token"
+	TypeTOTP     Type = "totp"
+)
+
+// Types lists every entry type
+var Types = []Type{TypeLogin, TypeNote, TypeCard, TypeIdentity, TypeSSHKey, TypeAPIToken, TypeTOTP}
+
+// a Record is the plaintext of a vault entry. only the part matching Type is
+// set, fields that don't fit the type are kept in Fields
+type Record struct {
+	Version int    `json:"version"`
+	Type    Type   `json:"type"`
+	Name    string `json:"name,omitempty"`
+	// Folder holds the folder an entry is filed under, nested folders are
+	// separated by slashes
+	Folder   string    `json:"folder,omitempty"`
+	Notes    string    `json:"notes,omitempty"`
+	URIs     []URI     `json:"uris,omitempty"`
+	Login    *Login    `json:"login,omitempty"`
+	Card     *Card     `json:"card,omitempty"`
+	Identity *Identity `json:"identity,omitempty"`
+	SSHKey   *SSHKey   `json:"sshKey,omitempty"`
+	APIToken *APIToken `json:"apiToken,omitempty"`
+	TOTP     *TOTP     `json:"totp,omitempty"`
+	Fields   []Field   `json:"fields,omitempty"`
+}
+
+type URI struct {
+	URI string `json:"uri"`
+}
+
+type Login struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// TOTP is an otpauth:// URI or a base32 seed
+	TOTP string `json:"totp,omitempty"`
+}
+
+type Card struct {
+	Cardholder string `json:"cardholder,omitempty"`
+	Brand      string `json:"brand,omitempty"`
+	Number     string `json:"number,omitempty"`
+	// Expiry is written as MM/YYYY
+	Expiry string `json:"expiry,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+type Identity struct {
+	Title          string `json:"title,omitempty"`
+	FirstName      string `json:"firstName,omitempty"`
+	MiddleName     string `json:"middleName,omitempty"`
+	LastName       string `json:"lastName,omitempty"`
+	Username       string `json:"username,omitempty"`
+	Company        string `json:"company,omitempty"`
+	Email          string `json:"email,omitempty"`
+	Phone          string `json:"phone,omitempty"`
+	Address        string `json:"address,omitempty"`
+	City           string `json:"city,omitempty"`
+	State          string `json:"state,omitempty"`
+	PostalCode     string `json:"postalCode,omitempty"`
+	Country        string `json:"country,omitempty"`
+	SSN            string `json:"ssn,omitempty"`
+	PassportNumber string `json:"passportNumber,omitempty"`
+	LicenseNumber  string `json:"licenseNumber,omitempty"`
+}
+
+type SSHKey struct {
+	// PrivateKey is PEM or OpenSSH encoded
+	PrivateKey string `json:"privateKey,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// Confirm asks the user before every use of the key
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+type APIToken struct {
+	Token   string `json:"token,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+type TOTP struct {
+	// Seed is an otpauth:// URI or a base32 seed
+	Seed string `json:"seed,omitempty"`
+}
+
+// a Field is a custom named value
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Hidden fields hold secrets, clients don't show them unless asked to
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// New creates an empty record of the given type at the current version
+func New(typ Type) *Record {
+	return &Record{Version: Version, Type: typ}
+}
+
+// Field returns the value of the custom field name
+func (r *Record) Field(name string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetField sets the custom field name, adding it if needed
+func (r *Record) SetField(name, value string, hidden bool) {
+	for i, f := range r.Fields {
+		if f.Name == name {
+			r.Fields[i].Value = value
+			r.Fields[i].Hidden = hidden
+			return
+		}
+	}
+	r.Fields = append(r.Fields, Field{Name: name, Value: value, Hidden: hidden})
+}
+
+// Marshal writes the record at the current version
+func (r *Record) Marshal() ([]byte, error) {
+	c := *r
+	c.Version = Version
+	if c.Type == "" {
+		c.Type = TypeLogin
+	}
+	return json.Marshal(&c)
+}
+
+// migrations[v] upgrades the plaintext of an entry from version v to v+1
+var migrations = []func([]byte) ([]byte, error){
+	migrateV0,
+}
+
+// Parse decodes the plaintext of an entry, migrating older versions
+func Parse(data []byte) (*Record, error) {
+	v := version(data)
+	if v > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	for ; v < Version; v++ {
+		var err error
+		data, err = migrations[v](data)
+		if err != nil {
+			return nil, fmt.Errorf("migrating entry from version %d: %w", v, err)
+		}
+	}
+
+	r := &Record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// version returns the schema version of the plaintext of an entry, entries
+// written before the schema existed are version 0
+func version(data []byte) int {
+	var v struct {
+		Version json.RawMessage `json:"version"`
+	}
+	if json.Unmarshal(data, &v) != nil {
+		return 0
+	}
+	// version 0 records may have a custom "version" field, which is a string
+	var n int
+	if json.Unmarshal(v.Version, &n) != nil || n < 1 {
+		return 0
+	}
+	return n
+}
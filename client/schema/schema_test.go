@@ -0,0 +1,100 @@
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/rokusei/gopass-server/client/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseVersion0(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		want *schema.Record
+	}{
+		{
+			name: "bare password",
+			data: "hunter2",
+			want: &schema.Record{Version: 1, Type: schema.TypeLogin, Login: &schema.Login{Password: "hunter2"}},
+		},
+		{
+			name: "login",
+			data: `{"name":"github","username":"octocat","password":"hunter2","url":"https://github.com","url 2":"https://gist.github.com","pin":"1234","version":"2"}`,
+			want: &schema.Record{
+				Version: 1, Type: schema.TypeLogin, Name: "github",
+				URIs:  []schema.URI{{URI: "https://github.com"}, {URI: "https://gist.github.com"}},
+				Login: &schema.Login{Username: "octocat", Password: "hunter2"},
+				Fields: []schema.Field{
+					{Name: "pin", Value: "1234", Hidden: true},
+					{Name: "version", Value: "2"},
+				},
+			},
+		},
+		{
+			name: "ssh key",
+			data: `{"type":"ssh-key","name":"deploy","private-key":"-----BEGIN","confirm":"true"}`,
+			want: &schema.Record{
+				Version: 1, Type: schema.TypeSSHKey, Name: "deploy",
+				SSHKey: &schema.SSHKey{PrivateKey: "-----BEGIN", Confirm: true},
+			},
+		},
+		{
+			name: "unknown type",
+			data: `{"type":"git-credential","name":"git example.com","host":"example.com","username":"git","password":"s3cret"}`,
+			want: &schema.Record{
+				Version: 1, Type: schema.TypeLogin, Name: "git example.com",
+				Login: &schema.Login{Username: "git", Password: "s3cret"},
+				Fields: []schema.Field{
+					{Name: "host", Value: "example.com"},
+					{Name: "type", Value: "git-credential"},
+				},
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		r, err := schema.Parse([]byte(test.data))
+		require.NoError(t, err, test.name)
+		require.Equal(t, test.want, r, test.name)
+	}
+}
+
+func Test_FlatRoundTrip(t *testing.T) {
+	for _, flat := range []map[string]string{
+		{"name": "github", "username": "octocat", "password": "hunter2", "url": "https://github.com", "url 2": "https://gist.github.com"},
+		{"type": "git-credential", "name": "git example.com", "host": "example.com", "password": "s3cret"},
+		{"type": "card", "name": "visa", "number": "4111111111111111", "expiry": "12/2030", "notes": "backup card"},
+		{"type": "identity", "name": "me", "first-name": "Jane", "email": "jane@example.com", "nickname": "jd"},
+		{"type": "ssh-key", "name": "deploy", "private-key": "-----BEGIN", "confirm": "true"},
+		{"type": "api-token", "name": "stripe", "token": "sk_test", "folder": "work/payments"},
+		{"type": "totp", "name": "aws", "totp": "otpauth://totp/aws?secret=JBSWY3DPEHPK3PXP"},
+		{"type": "note", "name": "wifi", "notes": "on the router"},
+	} {
+		r := schema.FromFlat(flat)
+		b, err := r.Marshal()
+		require.NoError(t, err)
+		parsed, err := schema.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, r, parsed)
+		require.Equal(t, flat, parsed.Flat())
+	}
+}
+
+func Test_ParseNewerVersion(t *testing.T) {
+	_, err := schema.Parse([]byte(`{"version":2,"type":"login"}`))
+	require.ErrorIs(t, err, schema.ErrUnsupportedVersion)
+}
+
+func Test_Fields(t *testing.T) {
+	r := schema.New(schema.TypeAPIToken)
+	r.SetField("scope", "read", false)
+	r.SetField("scope", "write", false)
+	v, ok := r.Field("scope")
+	require.True(t, ok)
+	require.Equal(t, "write", v)
+	require.Len(t, r.Fields, 1)
+
+	_, ok = r.Field("missing")
+	require.False(t, ok)
+}
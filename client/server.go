@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIVersion is the version of the API this client speaks
+const APIVersion = "v1"
+
+var ErrIncompatibleServer = errors.New("the server speaks no API version this client does")
+var ErrLimitExceeded = errors.New("request exceeds a limit or quota of the server")
+
+// Capabilities describe what a server supports and expects of its clients,
+// servers that predate /v1/server only have their API version set
+type Capabilities struct {
+	Version     string
+	APIVersions []string
+	Features    Features
+	Limits      Limits
+	// KDF lists the KDF parameters the server accepts for new users
+	KDF KDFPolicy
+	// Padding lists the sizes encrypted entries are padded to
+	Padding Padding
+}
+
+// Features lists the optional features enabled on a server
+type Features struct {
+	// TwoFactor lists the second factors users may enroll
+	TwoFactor   []string
+	Sharing     bool
+	Attachments bool
+	Sends       bool
+}
+
+// Limits bound requests and what a single user may store, zero is unlimited
+type Limits struct {
+	// MaxBodySize is the size in bytes of the largest request body read
+	MaxBodySize int64
+	// MaxEntrySize is the size in bytes of the largest encrypted entry accepted
+	MaxEntrySize int
+	// MaxEntries is how many entries a vault may hold
+	MaxEntries int
+	// MaxBatchOperations is how many operations or entries a batch may hold
+	MaxBatchOperations int
+}
+
+// a KDFPolicy describes the KDF parameters a server accepts, memory is in KiB
+type KDFPolicy struct {
+	Algorithms []string
+	// Default are the parameters the server offers new users, without a salt
+	Default              KDF
+	PBKDF2MinIterations  uint32
+	Argon2MinIterations  uint32
+	Argon2MaxIterations  uint32
+	Argon2MinMemory      uint32
+	Argon2MaxMemory      uint32
+	Argon2MaxParallelism uint8
+	SaltSize             int
+}
+
+// speaks reports whether the server speaks version of the API
+func (c *Capabilities) speaks(version string) bool {
+	for _, v := range c.APIVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntry refuses encrypted entries larger than the server accepts
+func (l Limits) checkEntry(enc []byte) error {
+	if l.MaxEntrySize != 0 && len(enc) > l.MaxEntrySize {
+		return fmt.Errorf("%w: entries are limited to %d bytes", ErrLimitExceeded, l.MaxEntrySize)
+	}
+	return nil
+}
+
+// checkBatch refuses batches of more than the server accepts
+func (l Limits) checkBatch(n int) error {
+	if l.MaxBatchOperations != 0 && n > l.MaxBatchOperations {
+		return fmt.Errorf("%w: batches are limited to %d operations", ErrLimitExceeded, l.MaxBatchOperations)
+	}
+	return nil
+}
+
+// Padding lists the sizes encrypted entries must have, entries larger than
+// the largest bucket are padded to a multiple of it
+type Padding struct {
+	// Buckets are ascending sizes in bytes, entries aren't padded without any
+	Buckets []int
+}
+
+// size returns the size an encrypted entry of n bytes is padded to
+func (p Padding) size(n int) int {
+	if len(p.Buckets) == 0 {
+		return n
+	}
+	for _, size := range p.Buckets {
+		if n <= size {
+			return size
+		}
+	}
+	largest := p.Buckets[len(p.Buckets)-1]
+	return (n + largest - 1) / largest * largest
+}
+
+// Capabilities fetches the capabilities of the server once and remembers
+// them. ErrIncompatibleServer is returned for servers that don't speak
+// APIVersion, servers that predate /v1/server speak it and have no others
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capabilities != nil {
+		return c.capabilities, nil
+	}
+
+	capabilities := &Capabilities{}
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/server", idempotent: true}, capabilities)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		capabilities.APIVersions, err = []string{APIVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !capabilities.speaks(APIVersion) {
+		return nil, fmt.Errorf("%w: server speaks %v, client %s", ErrIncompatibleServer, capabilities.APIVersions, APIVersion)
+	}
+	c.capabilities = capabilities
+	return capabilities, nil
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rokusei/gopass"
+	"github.com/rokusei/gopass-server/client/schema"
+)
+
+// server error messages the client reacts to
+const (
+	errSessionExpired      = "session expired"
+	errSessionNotFound     = "session not found"
+	errSchemaVersionTooNew = "vault holds entries of a newer schema version than the client supports"
+	errEntryNotFound       = "entry not found"
+)
+
+// ErrClientTooOld is returned when the vault holds entries written by a newer
+// client, in a schema version this one doesn't understand
+var ErrClientTooOld = errors.New("the vault was written by a newer client, upgrade to use it")
+
+// Credentials are derived client side from the user's master password and salt
+// only the AuthenticationHash is ever sent to the server, never the EncryptionKey
+type Credentials struct {
+	Email    string
+	AuthHash gopass.AuthenticationHash
+	Key      gopass.EncryptionKey
+}
+
+// DeriveCredentials derives the AuthenticationHash and EncryptionKey of a user
+// with the PBKDF2 parameters of accounts registered before the server stored
+// them, see KDF.Credentials for other accounts
+func DeriveCredentials(email, masterPassword string, salt []byte) (*Credentials, error) {
+	return LegacyKDF().Credentials(email, masterPassword, salt)
+}
+
+func (cr *Credentials) form() url.Values {
+	return url.Values{
+		"email":     {cr.Email},
+		"auth-hash": {string(cr.AuthHash)},
+	}
+}
+
+// Register creates a new user deriving their keys with Argon2id and a fresh
+// salt, which the server stores along with the other KDF parameters. the
+// parameters are at least as strong as the server's defaults
+func (c *Client) Register(ctx context.Context, email, masterPassword string) (*User, []byte, error) {
+	capabilities, err := c.Capabilities(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	kdf, err := capabilities.KDF.newKDF()
+	if err != nil {
+		return nil, nil, err
+	}
+	cr, err := kdf.Credentials(email, masterPassword, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form := cr.form()
+	for k, v := range kdf.form() {
+		form[k] = v
+	}
+	var u wireUser
+	err = c.do(ctx, call{path: "/user/create", form: form}, &u)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u.user(), kdf.Salt, nil
+}
+
+// Login fetches the user's KDF parameters, derives their credentials and
+// starts a session. salt is only needed for accounts registered before the
+// server stored it, see ErrSaltRequired
+func (c *Client) Login(ctx context.Context, email, masterPassword string, salt []byte) (*Session, error) {
+	kdf, err := c.Prelogin(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := kdf.Credentials(email, masterPassword, salt)
+	if err != nil {
+		return nil, err
+	}
+	return c.LoginWithCredentials(ctx, cr)
+}
+
+// LoginWithCredentials starts a session using already derived credentials
+// the session is renewed with them when it expires
+func (c *Client) LoginWithCredentials(ctx context.Context, cr *Credentials) (*Session, error) {
+	if _, err := c.Capabilities(ctx); err != nil {
+		return nil, err
+	}
+	s := &Session{
+		c:     c,
+		creds: cr,
+		key:   cr.Key,
+	}
+	err := s.renew(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resume continues a session started earlier, e.g. by a previous process
+// a resumed session can not be renewed once it expires
+func (c *Client) Resume(token string, expiresAt time.Time, key gopass.EncryptionKey) *Session {
+	return &Session{
+		c:         c,
+		key:       key,
+		token:     token,
+		expiresAt: expiresAt,
+	}
+}
+
+// a Session is an authenticated connection to the server, holding the key
+// used to encrypt and decrypt the user's vault entries
+type Session struct {
+	c     *Client
+	creds *Credentials
+	key   gopass.EncryptionKey
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Token returns the current session token and when it expires
+func (s *Session) Token() (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, s.expiresAt
+}
+
+// Key returns the EncryptionKey of the session's user
+func (s *Session) Key() gopass.EncryptionKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.key
+}
+
+// Logout ends the session on the server
+func (s *Session) Logout(ctx context.Context) error {
+	token, _ := s.Token()
+	err := s.c.do(ctx, call{path: "/session/delete", token: token, idempotent: true}, nil)
+	if errorMessage(err, errSessionNotFound) {
+		// already gone
+		return nil
+	}
+	return err
+}
+
+func (s *Session) renew(ctx context.Context) error {
+	var ws wireSession
+	err := s.c.do(ctx, call{path: "/session/create", form: s.creds.form(), idempotent: true}, &ws)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ws.Token
+	s.expiresAt = ws.ExpiresAt
+	return nil
+}
+
+// do performs an authenticated call, renewing the session once if it expired
+func (s *Session) do(ctx context.Context, cl call, out interface{}) error {
+	// the server refuses clients older than the entries of the vault
+	form := url.Values{"schema-version": {strconv.Itoa(schema.Version)}}
+	for k, v := range cl.form {
+		form[k] = v
+	}
+	cl.form = form
+
+	cl.token, _ = s.Token()
+	err := s.c.do(ctx, cl, out)
+	if s.creds != nil && (errorMessage(err, errSessionExpired) || errorMessage(err, errSessionNotFound)) {
+		err = s.renew(ctx)
+		if err != nil {
+			return err
+		}
+		cl.token, _ = s.Token()
+		err = s.c.do(ctx, cl, out)
+	}
+	if errorMessage(err, errSchemaVersionTooNew) {
+		return ErrClientTooOld
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("%w: %s", ErrLimitExceeded, apiErr.Message)
+	}
+	return err
+}
+
+// seal encrypts the plaintext of an entry with key, padded as the server
+// expects, refusing entries larger than it accepts
+func (s *Session) seal(ctx context.Context, data []byte, key gopass.EncryptionKey) ([]byte, error) {
+	capabilities, err := s.c.Capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := seal(data, key, capabilities.Padding)
+	if err != nil {
+		return nil, err
+	}
+	if err := capabilities.Limits.checkEntry(enc); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// checkBatch refuses batches of n operations larger than the server accepts
+func (s *Session) checkBatch(ctx context.Context, n int) error {
+	capabilities, err := s.c.Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	return capabilities.Limits.checkBatch(n)
+}
@@ -0,0 +1,280 @@
+// Package sshagent implements an ssh-agent serving private keys kept in a
+// gopass-server vault
+//
+// Keys only ever exist decrypted in the agent's memory. Locking the agent,
+// by hand or after a period of inactivity, forgets them, unlocking loads them
+// from the vault again.
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var ErrLocked = errors.New("agent is locked")
+var ErrKeyNotFound = errors.New("key not found")
+var ErrNotConfirmed = errors.New("signing was not confirmed")
+var ErrReadOnly = errors.New("keys are managed in the vault, add them there instead")
+
+// a Key is a private key loaded from the vault
+type Key struct {
+	Signer  ssh.Signer
+	Comment string
+	// Confirm requires every use of the key to be confirmed
+	Confirm bool
+}
+
+// ParseKey parses a PEM encoded private key, decrypting it with the passphrase if it is not empty
+func ParseKey(pemBytes []byte, passphrase []byte, comment string) (*Key, error) {
+	var raw interface{}
+	var err error
+	if len(passphrase) > 0 {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	} else {
+		raw, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Signer: signer, Comment: comment}, nil
+}
+
+type Options struct {
+	// Load fetches the keys from the vault, it is called by Unlock with the
+	// passphrase given to it, and must verify it
+	Load func(passphrase []byte) ([]*Key, error)
+	// Confirm asks the user whether a key may be used, keys requiring
+	// confirmation are refused if it is nil
+	Confirm func(key *Key) bool
+	// ConfirmAll requires confirmation for every key
+	ConfirmAll bool
+	// LockAfter locks the agent once no key was used for this long, zero never locks it
+	LockAfter time.Duration
+}
+
+// an Agent is an agent.ExtendedAgent serving keys from the vault
+type Agent struct {
+	opts Options
+
+	mu     sync.Mutex
+	keys   []*Key
+	locked bool
+	timer  *time.Timer
+}
+
+// New creates an unlocked Agent serving keys
+func New(keys []*Key, opts Options) *Agent {
+	// the agent keeps its own slice, so that locking it drops the only reference
+	// to the signers once the caller lets go of keys
+	a := &Agent{
+		opts: opts,
+		keys: append([]*Key(nil), keys...),
+	}
+	if opts.LockAfter > 0 {
+		a.timer = time.AfterFunc(opts.LockAfter, a.lock)
+	}
+	return a
+}
+
+// Serve accepts agent connections on l until it is closed
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(a, conn)
+		}()
+	}
+}
+
+// Locked reports whether the agent forgot its keys
+func (a *Agent) Locked() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.locked
+}
+
+// touch postpones the automatic lock, must be called with mu held
+func (a *Agent) touch() {
+	if a.timer != nil {
+		a.timer.Reset(a.opts.LockAfter)
+	}
+}
+
+// lock forgets every key
+func (a *Agent) lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forget()
+	a.locked = true
+}
+
+// forget drops the signers of every key, must be called with mu held
+func (a *Agent) forget() {
+	for i := range a.keys {
+		a.keys[i] = nil
+	}
+	a.keys = nil
+}
+
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var keys []*agent.Key
+	for _, k := range a.keys {
+		pub := k.Signer.PublicKey()
+		keys = append(keys, &agent.Key{
+			Format:  pub.Type(),
+			Blob:    pub.Marshal(),
+			Comment: k.Comment,
+		})
+	}
+	return keys, nil
+}
+
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	if a.locked {
+		a.mu.Unlock()
+		return nil, ErrLocked
+	}
+	k := a.find(key)
+	a.touch()
+	a.mu.Unlock()
+
+	if k == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	// ask without holding the lock, the user may take a while
+	if k.Confirm || a.opts.ConfirmAll {
+		if a.opts.Confirm == nil || !a.opts.Confirm(k) {
+			return nil, ErrNotConfirmed
+		}
+	}
+
+	if flags == 0 {
+		return k.Signer.Sign(rand.Reader, data)
+	}
+
+	algorithmSigner, ok := k.Signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("%s keys only support the default signature algorithm", k.Signer.PublicKey().Type())
+	}
+	switch flags {
+	case agent.SignatureFlagRsaSha256:
+		return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2256)
+	case agent.SignatureFlagRsaSha512:
+		return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+	}
+	return nil, fmt.Errorf("unsupported signature flags %d", flags)
+}
+
+// find returns the key with the given public key, must be called with mu held
+func (a *Agent) find(key ssh.PublicKey) *Key {
+	wanted := key.Marshal()
+	for _, k := range a.keys {
+		if bytes.Equal(k.Signer.PublicKey().Marshal(), wanted) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (a *Agent) Add(key agent.AddedKey) error {
+	return ErrReadOnly
+}
+
+// Remove forgets a key until the agent is unlocked again
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return ErrLocked
+	}
+
+	wanted := key.Marshal()
+	for i, k := range a.keys {
+		if bytes.Equal(k.Signer.PublicKey().Marshal(), wanted) {
+			last := len(a.keys) - 1
+			copy(a.keys[i:], a.keys[i+1:])
+			a.keys[last] = nil
+			a.keys = a.keys[:last]
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+// RemoveAll forgets every key until the agent is unlocked again
+func (a *Agent) RemoveAll() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forget()
+	return nil
+}
+
+// Lock drops the signers of every key, the passphrase is ignored as unlocking
+// takes the master password
+func (a *Agent) Lock(passphrase []byte) error {
+	a.lock()
+	return nil
+}
+
+// Unlock loads the keys from the vault again, the passphrase is checked by Options.Load
+func (a *Agent) Unlock(passphrase []byte) error {
+	if a.opts.Load == nil {
+		return errors.New("agent can not be unlocked")
+	}
+	keys, err := a.opts.Load(passphrase)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forget()
+	a.keys = append([]*Key(nil), keys...)
+	a.locked = false
+	a.touch()
+	return nil
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, ErrLocked
+	}
+
+	var signers []ssh.Signer
+	for _, k := range a.keys {
+		signers = append(signers, k.Signer)
+	}
+	return signers, nil
+}
+
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
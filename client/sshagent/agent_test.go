@@ -0,0 +1,152 @@
+package sshagent_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/client/sshagent"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKeys(t *testing.T) []*sshagent.Key {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	rsaAgentKey, err := sshagent.ParseKey(rsaPEM, nil, "deploy")
+	require.NoError(t, err)
+
+	return []*sshagent.Key{
+		{Signer: edSigner, Comment: "laptop"},
+		rsaAgentKey,
+	}
+}
+
+// serve starts the agent on a unix socket and connects a client to it
+func serve(t *testing.T, a *sshagent.Agent) agent.ExtendedAgent {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go a.Serve(l)
+
+	conn, err := net.Dial("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return agent.NewClient(conn)
+}
+
+func Test_ListSign(t *testing.T) {
+	keys := newKeys(t)
+	c := serve(t, sshagent.New(keys, sshagent.Options{}))
+
+	listed, err := c.List()
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	require.Equal(t, "laptop", listed[0].Comment)
+	require.Equal(t, "deploy", listed[1].Comment)
+
+	data := []byte("session id")
+	for _, k := range keys {
+		sig, err := c.Sign(k.Signer.PublicKey(), data)
+		require.NoError(t, err)
+		require.NoError(t, k.Signer.PublicKey().Verify(data, sig))
+	}
+
+	sig, err := c.SignWithFlags(keys[1].Signer.PublicKey(), data, agent.SignatureFlagRsaSha512)
+	require.NoError(t, err)
+	require.Equal(t, ssh.SigAlgoRSASHA2512, sig.Format)
+	require.NoError(t, keys[1].Signer.PublicKey().Verify(data, sig))
+
+	// keys only come from the vault
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	require.Error(t, c.Add(agent.AddedKey{PrivateKey: edKey}))
+}
+
+func Test_Confirm(t *testing.T) {
+	keys := newKeys(t)
+	keys[0].Confirm = true
+
+	var asked []string
+	allow := false
+	c := serve(t, sshagent.New(keys, sshagent.Options{
+		Confirm: func(k *sshagent.Key) bool {
+			asked = append(asked, k.Comment)
+			return allow
+		},
+	}))
+
+	_, err := c.Sign(keys[0].Signer.PublicKey(), []byte("data"))
+	require.Error(t, err)
+	allow = true
+	_, err = c.Sign(keys[0].Signer.PublicKey(), []byte("data"))
+	require.NoError(t, err)
+
+	// keys without Confirm are used without asking
+	_, err = c.Sign(keys[1].Signer.PublicKey(), []byte("data"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"laptop", "laptop"}, asked)
+}
+
+func Test_LockUnlock(t *testing.T) {
+	keys := newKeys(t)
+	loads := 0
+	load := func(passphrase []byte) ([]*sshagent.Key, error) {
+		if string(passphrase) != "master password" {
+			return nil, errors.New("wrong master password")
+		}
+		loads++
+		return keys, nil
+	}
+	a := sshagent.New(keys, sshagent.Options{Load: load, LockAfter: 100 * time.Millisecond})
+	c := serve(t, a)
+
+	require.Eventually(t, a.Locked, time.Second, 10*time.Millisecond)
+	listed, err := c.List()
+	require.NoError(t, err)
+	require.Empty(t, listed)
+	_, err = c.Sign(keys[0].Signer.PublicKey(), []byte("data"))
+	require.Error(t, err)
+
+	require.Error(t, c.Unlock([]byte("guess")))
+	require.NoError(t, c.Unlock([]byte("master password")))
+	require.False(t, a.Locked())
+	_, err = c.Sign(keys[0].Signer.PublicKey(), []byte("data"))
+	require.NoError(t, err)
+
+	require.Equal(t, 1, loads)
+
+	// locking by hand forgets the keys as well, unlocking loads them again
+	require.NoError(t, c.Lock([]byte("anything")))
+	require.True(t, a.Locked())
+	listed, err = c.List()
+	require.NoError(t, err)
+	require.Empty(t, listed)
+	_, err = c.Sign(keys[0].Signer.PublicKey(), []byte("data"))
+	require.Error(t, err)
+
+	require.NoError(t, c.Unlock([]byte("master password")))
+	require.Equal(t, 2, loads)
+	listed, err = c.List()
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+
+	// the keys handed to the agent are left alone
+	require.NoError(t, c.Lock(nil))
+	require.NotNil(t, keys[0].Signer)
+	require.Len(t, keys, 2)
+}
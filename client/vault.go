@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/rokusei/gopass"
+	"github.com/rokusei/gopass-server/client/schema"
+)
+
+// a User as seen by the client
+type User struct {
+	ID       string
+	Verified bool
+	// KDF is how the user derives their keys
+	KDF *KDF
+	// Vault is only set when the user was fetched through a Session
+	Vault *Vault
+	// Usage is only set by Session.User, on servers reporting it
+	Usage *Usage
+}
+
+// a Usage is what a vault holds against the quotas of the server
+type Usage struct {
+	Entries int
+	// EntryBytes is the total size of the encrypted entries
+	EntryBytes int64
+	Quota      Quota
+}
+
+// a Quota limits what each user may store, zero is unlimited
+type Quota struct {
+	MaxEntrySize int
+	MaxEntries   int
+}
+
+// a Vault holds the decrypted entries of a user
+type Vault struct {
+	ID      string
+	Entries []*Entry
+}
+
+// an Entry is a decrypted vault entry
+type Entry struct {
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// SchemaVersion is the schema version of the client that wrote the entry
+	SchemaVersion int
+	Data          []byte
+}
+
+// wire types mirror the JSON written by the server
+type wireUser struct {
+	ID           string
+	Verification struct {
+		Completed bool
+	}
+	KDF   *KDF
+	Vault wireVault
+	Usage *Usage
+}
+
+type wireVault struct {
+	ID           string
+	VaultEntries []wireEntry
+}
+
+type wireEntry struct {
+	ID             string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	EncryptedEntry []byte
+	SchemaVersion  int
+}
+
+type wireSession struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (u *wireUser) user() *User {
+	return &User{
+		ID:       u.ID,
+		Verified: u.Verification.Completed,
+		KDF:      u.kdf(),
+	}
+}
+
+// kdf returns the user's KDF, servers that predate stored KDF parameters don't send any
+func (u *wireUser) kdf() *KDF {
+	if u.KDF == nil || u.KDF.Algorithm == "" {
+		return LegacyKDF()
+	}
+	return u.KDF
+}
+
+func (v *wireVault) vault(key gopass.EncryptionKey) (*Vault, error) {
+	vault := &Vault{
+		ID:      v.ID,
+		Entries: make([]*Entry, 0, len(v.VaultEntries)),
+	}
+	for _, we := range v.VaultEntries {
+		e, err := we.entry(key)
+		if err != nil {
+			return nil, err
+		}
+		vault.Entries = append(vault.Entries, e)
+	}
+	return vault, nil
+}
+
+func (e *wireEntry) entry(key gopass.EncryptionKey) (*Entry, error) {
+	data, err := open(e.EncryptedEntry, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		ID:            e.ID,
+		CreatedAt:     e.CreatedAt,
+		UpdatedAt:     e.UpdatedAt,
+		SchemaVersion: e.SchemaVersion,
+		Data:          data,
+	}, nil
+}
+
+// User fetches the session's user along with their decrypted vault
+func (s *Session) User(ctx context.Context) (*User, error) {
+	var u wireUser
+	err := s.do(ctx, call{path: "/user", idempotent: true}, &u)
+	if err != nil {
+		return nil, err
+	}
+
+	user := u.user()
+	user.Usage = u.Usage
+	user.Vault, err = u.Vault.vault(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Vault fetches and decrypts the session user's vault
+func (s *Session) Vault(ctx context.Context) (*Vault, error) {
+	var v wireVault
+	err := s.do(ctx, call{path: "/vault", idempotent: true}, &v)
+	if err != nil {
+		return nil, err
+	}
+	return v.vault(s.key)
+}
+
+// Entry fetches and decrypts a single vault entry
+func (s *Session) Entry(ctx context.Context, id string) (*Entry, error) {
+	var e wireEntry
+	err := s.do(ctx, call{
+		path:       "/vault/entry",
+		form:       url.Values{"entry-uuid": {id}},
+		idempotent: true,
+	}, &e)
+	if err != nil {
+		return nil, err
+	}
+	return e.entry(s.key)
+}
+
+// CreateEntry encrypts data and stores it as a new vault entry
+func (s *Session) CreateEntry(ctx context.Context, data []byte) (*Entry, error) {
+	enc, err := s.seal(ctx, data, s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var e wireEntry
+	err = s.do(ctx, call{
+		path: "/vault/entry/create",
+		form: url.Values{"encrypted-entry": {string(enc)}},
+	}, &e)
+	if err != nil {
+		return nil, err
+	}
+	return e.entry(s.key)
+}
+
+// CreateEntries encrypts every item of data and stores them as new vault
+// entries in one batch, either all of them are created or none are
+func (s *Session) CreateEntries(ctx context.Context, data [][]byte) ([]*Entry, error) {
+	ops := make([]BatchOperation, 0, len(data))
+	for _, d := range data {
+		ops = append(ops, CreateOp(d))
+	}
+	results, err := s.Batch(ctx, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, r.Entry)
+	}
+	return entries, nil
+}
+
+// UpdateEntry encrypts data and replaces the contents of a vault entry with it
+func (s *Session) UpdateEntry(ctx context.Context, id string, data []byte) (*Entry, error) {
+	enc, err := s.seal(ctx, data, s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var e wireEntry
+	err = s.do(ctx, call{
+		path:       "/vault/entry/update",
+		form:       url.Values{"entry-uuid": {id}, "encrypted-entry": {string(enc)}},
+		idempotent: true,
+	}, &e)
+	if err != nil {
+		return nil, err
+	}
+	return e.entry(s.key)
+}
+
+// DeleteEntry removes a vault entry
+func (s *Session) DeleteEntry(ctx context.Context, id string) error {
+	return s.do(ctx, call{
+		path:       "/vault/entry/delete",
+		form:       url.Values{"entry-uuid": {id}},
+		idempotent: true,
+		done:       errEntryNotFound,
+	}, nil)
+}
+
+// Record decodes the entry's data as a schema.Record, migrating it to the
+// current schema version
+func (e *Entry) Record() (*schema.Record, error) {
+	return schema.Parse(e.Data)
+}
+
+// CreateRecord stores r as a new vault entry
+func (s *Session) CreateRecord(ctx context.Context, r *schema.Record) (*Entry, error) {
+	data, err := r.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateEntry(ctx, data)
+}
+
+// UpdateRecord replaces the contents of a vault entry with r
+func (s *Session) UpdateRecord(ctx context.Context, id string, r *schema.Record) (*Entry, error) {
+	data, err := r.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return s.UpdateEntry(ctx, id, data)
+}
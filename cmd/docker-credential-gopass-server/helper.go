@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+// ErrCredentialsNotFound uses the exact message docker looks for to tell a
+// missing credential apart from a failing helper
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+var ErrMissingServerURL = errors.New("no credentials server URL")
+
+// recordType marks the vault entries holding registry credentials
+const recordType = "docker-credential"
+
+const fieldServerURL = "server-url"
+
+// credentials is the JSON exchanged with docker
+// see https://github.com/docker/docker-credential-helpers
+type credentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// normalizeServerURL lets "https://registry.example.com/" find credentials
+// stored for "registry.example.com"
+func normalizeServerURL(serverURL string) string {
+	u := strings.TrimSpace(serverURL)
+	u = strings.TrimPrefix(u, "https://")
+	u = strings.TrimPrefix(u, "http://")
+	return strings.TrimRight(u, "/")
+}
+
+func readServerURL(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	u := strings.TrimSpace(string(b))
+	if u == "" {
+		return "", ErrMissingServerURL
+	}
+	return u, nil
+}
+
+// find returns the stored credentials for serverURL
+func find(ctx context.Context, v cli.Vault, serverURL string) (*cli.Item, error) {
+	items, err := cli.Items(ctx, v, recordType)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if normalizeServerURL(item.Record[fieldServerURL]) == normalizeServerURL(serverURL) {
+			return &item, nil
+		}
+	}
+	return nil, ErrCredentialsNotFound
+}
+
+func store(ctx context.Context, v cli.Vault, r io.Reader) error {
+	var c credentials
+	err := json.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.ServerURL) == "" {
+		return ErrMissingServerURL
+	}
+
+	record := cli.Record{
+		cli.FieldType:     recordType,
+		cli.FieldName:     "docker " + normalizeServerURL(c.ServerURL),
+		fieldServerURL:    c.ServerURL,
+		cli.FieldUsername: c.Username,
+		cli.FieldPassword: c.Secret,
+	}
+	data, err := record.Marshal()
+	if err != nil {
+		return err
+	}
+
+	item, err := find(ctx, v, c.ServerURL)
+	if errors.Is(err, ErrCredentialsNotFound) {
+		_, err = v.CreateEntry(ctx, data)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = v.UpdateEntry(ctx, item.ID, data)
+	return err
+}
+
+func get(ctx context.Context, v cli.Vault, r io.Reader, w io.Writer) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	item, err := find(ctx, v, serverURL)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(credentials{
+		ServerURL: serverURL,
+		Username:  item.Record[cli.FieldUsername],
+		Secret:    item.Record[cli.FieldPassword],
+	})
+}
+
+func erase(ctx context.Context, v cli.Vault, r io.Reader) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	item, err := find(ctx, v, serverURL)
+	if err != nil {
+		return err
+	}
+	return v.DeleteEntry(ctx, item.ID)
+}
+
+// list writes the username of every stored server URL
+func list(ctx context.Context, v cli.Vault, w io.Writer) error {
+	items, err := cli.Items(ctx, v, recordType)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]string)
+	for _, item := range items {
+		users[item.Record[fieldServerURL]] = item.Record[cli.FieldUsername]
+	}
+	return json.NewEncoder(w).Encode(users)
+}
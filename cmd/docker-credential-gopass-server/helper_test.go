@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli/clitest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_StoreGetListErase(t *testing.T) {
+	ctx := context.Background()
+	v := &clitest.Vault{}
+
+	var out bytes.Buffer
+	err := get(ctx, v, strings.NewReader("registry.example.com\n"), &out)
+	require.ErrorIs(t, err, ErrCredentialsNotFound)
+
+	require.NoError(t, store(ctx, v, strings.NewReader(`{"ServerURL":"registry.example.com","Username":"me","Secret":"s3cret"}`)))
+	require.NoError(t, store(ctx, v, strings.NewReader(`{"ServerURL":"https://index.docker.io/v1/","Username":"hub","Secret":"hub-token"}`)))
+
+	require.NoError(t, get(ctx, v, strings.NewReader("registry.example.com\n"), &out))
+	require.JSONEq(t, `{"ServerURL":"registry.example.com","Username":"me","Secret":"s3cret"}`, out.String())
+
+	// server URLs are compared without their scheme and trailing slash
+	out.Reset()
+	require.NoError(t, get(ctx, v, strings.NewReader("index.docker.io/v1"), &out))
+	require.JSONEq(t, `{"ServerURL":"index.docker.io/v1","Username":"hub","Secret":"hub-token"}`, out.String())
+
+	// storing again replaces the credentials
+	require.NoError(t, store(ctx, v, strings.NewReader(`{"ServerURL":"https://registry.example.com","Username":"me","Secret":"rotated"}`)))
+	out.Reset()
+	require.NoError(t, get(ctx, v, strings.NewReader("registry.example.com"), &out))
+	require.Contains(t, out.String(), `"Secret":"rotated"`)
+
+	out.Reset()
+	require.NoError(t, list(ctx, v, &out))
+	require.JSONEq(t, `{"https://registry.example.com":"me","https://index.docker.io/v1/":"hub"}`, out.String())
+
+	require.NoError(t, erase(ctx, v, strings.NewReader("registry.example.com")))
+	require.ErrorIs(t, get(ctx, v, strings.NewReader("registry.example.com"), &out), ErrCredentialsNotFound)
+	require.ErrorIs(t, erase(ctx, v, strings.NewReader("registry.example.com")), ErrCredentialsNotFound)
+
+	require.ErrorIs(t, get(ctx, v, strings.NewReader(""), &out), ErrMissingServerURL)
+}
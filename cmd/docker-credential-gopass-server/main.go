@@ -0,0 +1,66 @@
+// Command docker-credential-gopass-server is a docker credential helper which
+// keeps container registry credentials in a gopass-server vault
+//
+// It uses the session cached by `gopass-server login`. To use it set
+//
+//	{"credsStore": "gopass-server"}
+//
+// in ~/.docker/config.json
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+// Version is reported by the version command
+const Version = "0.1.0"
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: docker-credential-gopass-server <store|get|erase|list|version>")
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err := run(ctx, os.Args[1])
+	if err != nil {
+		// docker reads the error from stdout
+		fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, op string) error {
+	switch op {
+	case "store", "get", "erase", "list":
+	case "version":
+		fmt.Printf("docker-credential-gopass-server %s\n", Version)
+		return nil
+	default:
+		return fmt.Errorf("unknown credential action %q", op)
+	}
+
+	s, err := cli.OpenSession()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "store":
+		return store(ctx, s, os.Stdin)
+	case "get":
+		return get(ctx, s, os.Stdin, os.Stdout)
+	case "erase":
+		return erase(ctx, s, os.Stdin)
+	case "list":
+		return list(ctx, s, os.Stdout)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+// recordType marks the vault entries holding git credentials
+const recordType = "git-credential"
+
+// record fields, besides the username and password
+const (
+	fieldProtocol = "protocol"
+	fieldHost     = "host"
+	fieldPath     = "path"
+)
+
+// a credential is the set of attributes exchanged with git
+// see https://git-scm.com/docs/git-credential#IOFMT
+type credential struct {
+	protocol string
+	host     string
+	path     string
+	username string
+	password string
+}
+
+// readCredential parses key=value lines until a blank line or EOF
+func readCredential(r io.Reader) (*credential, error) {
+	c := &credential{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+
+		switch kv[0] {
+		case "protocol":
+			c.protocol = kv[1]
+		case "host":
+			c.host = kv[1]
+		case "path":
+			c.path = kv[1]
+		case "username":
+			c.username = kv[1]
+		case "password":
+			c.password = kv[1]
+		case "url":
+			u, err := url.Parse(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			c.protocol = u.Scheme
+			c.host = u.Host
+			c.path = strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				c.username = u.User.Username()
+			}
+		}
+		// anything else is an attribute this helper doesn't use
+	}
+	return c, s.Err()
+}
+
+func (c *credential) write(w io.Writer) error {
+	for _, kv := range [][2]string{
+		{"protocol", c.protocol}, {"host", c.host}, {"path", c.path},
+		{"username", c.username}, {"password", c.password},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s=%s\n", kv[0], kv[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func credentialFromRecord(r cli.Record) *credential {
+	return &credential{
+		protocol: r[fieldProtocol],
+		host:     r[fieldHost],
+		path:     r[fieldPath],
+		username: r[cli.FieldUsername],
+		password: r[cli.FieldPassword],
+	}
+}
+
+func (c *credential) record() cli.Record {
+	r := cli.Record{
+		cli.FieldType:     recordType,
+		cli.FieldName:     "git " + c.location(),
+		fieldProtocol:     c.protocol,
+		fieldHost:         c.host,
+		cli.FieldUsername: c.username,
+		cli.FieldPassword: c.password,
+		cli.FieldURL:      c.protocol + "://" + c.location(),
+	}
+	if c.path != "" {
+		r[fieldPath] = c.path
+	}
+	return r
+}
+
+func (c *credential) location() string {
+	if c.path == "" {
+		return c.host
+	}
+	return c.host + "/" + c.path
+}
+
+// matches reports whether the stored credential s answers the request c
+// a stored credential without a path applies to every path on its host,
+// the username is only compared when the request names one
+func (c *credential) matches(s *credential) bool {
+	if c.protocol != s.protocol || c.host != s.host {
+		return false
+	}
+	if s.path != "" && s.path != c.path {
+		return false
+	}
+	if c.username != "" && c.username != s.username {
+		return false
+	}
+	return true
+}
+
+// same reports whether s is the stored version of c, rather than merely matching it
+func (c *credential) same(s *credential) bool {
+	return c.protocol == s.protocol && c.host == s.host && c.path == s.path && c.username == s.username
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+// get writes the username and password of the best matching stored credential
+// credentials stored for the exact path win over ones for the whole host
+func get(ctx context.Context, v cli.Vault, req *credential, w io.Writer) error {
+	items, err := cli.Items(ctx, v, recordType)
+	if err != nil {
+		return err
+	}
+
+	var best *credential
+	for _, item := range items {
+		s := credentialFromRecord(item.Record)
+		if !req.matches(s) {
+			continue
+		}
+		if best == nil || (best.path == "" && s.path != "") {
+			best = s
+		}
+	}
+	if best == nil {
+		// no output tells git to try the next helper or prompt
+		return nil
+	}
+
+	answer := *req
+	answer.username = best.username
+	answer.password = best.password
+	return answer.write(w)
+}
+
+// store saves a credential git successfully used, replacing the stored password if it changed
+func store(ctx context.Context, v cli.Vault, req *credential) error {
+	if req.protocol == "" || req.host == "" || req.password == "" {
+		// git only sends complete credentials, ignore anything else
+		return nil
+	}
+
+	items, err := cli.Items(ctx, v, recordType)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		s := credentialFromRecord(item.Record)
+		if !req.same(s) {
+			continue
+		}
+		if s.password == req.password {
+			return nil
+		}
+		item.Record[cli.FieldPassword] = req.password
+		data, err := item.Record.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = v.UpdateEntry(ctx, item.ID, data)
+		return err
+	}
+
+	data, err := req.record().Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = v.CreateEntry(ctx, data)
+	return err
+}
+
+// erase removes the stored credentials git rejected
+func erase(ctx context.Context, v cli.Vault, req *credential) error {
+	items, err := cli.Items(ctx, v, recordType)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		s := credentialFromRecord(item.Record)
+		if !req.matches(s) {
+			continue
+		}
+		// the password may have been updated since git was handed it
+		if req.password != "" && req.password != s.password {
+			continue
+		}
+		err = v.DeleteEntry(ctx, item.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli/clitest"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, s string) *credential {
+	c, err := readCredential(strings.NewReader(s))
+	require.NoError(t, err)
+	return c
+}
+
+func getOutput(t *testing.T, v *clitest.Vault, req string) string {
+	var out bytes.Buffer
+	require.NoError(t, get(context.Background(), v, parse(t, req), &out))
+	return out.String()
+}
+
+func Test_ReadCredential(t *testing.T) {
+	c := parse(t, "protocol=https\nhost=example.com\npath=org/repo.git\nusername=me\npassword=secret\nwwwauth[]=Basic\n\nignored=after blank line\n")
+	require.Equal(t, &credential{"https", "example.com", "org/repo.git", "me", "secret"}, c)
+
+	c = parse(t, "url=https://me@example.com:8443/org/repo.git\n")
+	require.Equal(t, &credential{"https", "example.com:8443", "org/repo.git", "me", ""}, c)
+
+	_, err := readCredential(strings.NewReader("garbage\n"))
+	require.Error(t, err)
+}
+
+func Test_GetStoreErase(t *testing.T) {
+	ctx := context.Background()
+	v := &clitest.Vault{}
+
+	require.Empty(t, getOutput(t, v, "protocol=https\nhost=example.com\n"))
+
+	// a host wide credential and one for a single repository
+	require.NoError(t, store(ctx, v, parse(t, "protocol=https\nhost=example.com\nusername=me\npassword=host-token\n")))
+	require.NoError(t, store(ctx, v, parse(t, "protocol=https\nhost=example.com\npath=org/repo.git\nusername=bot\npassword=repo-token\n")))
+
+	require.Equal(t, "protocol=https\nhost=example.com\nusername=me\npassword=host-token\n",
+		getOutput(t, v, "protocol=https\nhost=example.com\n"))
+	require.Equal(t, "protocol=https\nhost=example.com\npath=org/other.git\nusername=me\npassword=host-token\n",
+		getOutput(t, v, "protocol=https\nhost=example.com\npath=org/other.git\n"))
+	require.Equal(t, "protocol=https\nhost=example.com\npath=org/repo.git\nusername=bot\npassword=repo-token\n",
+		getOutput(t, v, "protocol=https\nhost=example.com\npath=org/repo.git\n"))
+	require.Empty(t, getOutput(t, v, "protocol=http\nhost=example.com\n"))
+	require.Empty(t, getOutput(t, v, "protocol=https\nhost=example.com\nusername=someone\n"))
+
+	// storing again only updates the password
+	require.NoError(t, store(ctx, v, parse(t, "protocol=https\nhost=example.com\nusername=me\npassword=new-token\n")))
+	vault, err := v.Vault(ctx)
+	require.NoError(t, err)
+	require.Len(t, vault.Entries, 2)
+	require.Contains(t, getOutput(t, v, "protocol=https\nhost=example.com\n"), "password=new-token")
+
+	// a stale password doesn't erase the updated credential
+	require.NoError(t, erase(ctx, v, parse(t, "protocol=https\nhost=example.com\nusername=me\npassword=host-token\n")))
+	require.Contains(t, getOutput(t, v, "protocol=https\nhost=example.com\n"), "password=new-token")
+
+	require.NoError(t, erase(ctx, v, parse(t, "protocol=https\nhost=example.com\nusername=me\npassword=new-token\n")))
+	require.Empty(t, getOutput(t, v, "protocol=https\nhost=example.com\n"))
+	require.Contains(t, getOutput(t, v, "protocol=https\nhost=example.com\npath=org/repo.git\n"), "password=repo-token")
+}
+
+func Test_IgnoresOtherEntries(t *testing.T) {
+	ctx := context.Background()
+	v := &clitest.Vault{}
+	_, err := v.CreateEntry(ctx, []byte(`{"name":"example.com","host":"example.com","protocol":"https","password":"not for git"}`))
+	require.NoError(t, err)
+
+	require.Empty(t, getOutput(t, v, "protocol=https\nhost=example.com\n"))
+}
@@ -0,0 +1,69 @@
+// Command git-credential-gopass-server is a git credential helper which keeps
+// credentials in a gopass-server vault
+//
+// Credentials are stored encrypted like any other entry and matched against
+// git's requests client side, the server never learns which hosts they are for.
+// It uses the session cached by `gopass-server login`. To use it run
+//
+//	git config --global credential.helper gopass-server
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: git-credential-gopass-server <get|store|erase>")
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err := run(ctx, os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "git-credential-gopass-server: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, op string) error {
+	switch op {
+	case "get", "store", "erase":
+	default:
+		// git may add operations in the future, which helpers are to ignore
+		return nil
+	}
+
+	req, err := readCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	s, err := cli.OpenSession()
+	if errors.Is(err, cli.ErrNotLoggedIn) && op == "get" {
+		// let git fall back to prompting
+		fmt.Fprintf(os.Stderr, "git-credential-gopass-server: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "get":
+		return get(ctx, s, req, os.Stdout)
+	case "store":
+		return store(ctx, s, req)
+	case "erase":
+		return erase(ctx, s, req)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+var ErrNoClipboard = errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+
+// clipboardHashEnv passes the hash of the copied secret to the clear-clipboard
+// process, so it only clears the clipboard if it wasn't changed since
+const clipboardHashEnv = "GOPASS_SERVER_CLIPBOARD_HASH"
+
+// a clipboardTool is a command line program used to access the clipboard
+type clipboardTool struct {
+	copy  []string
+	paste []string
+}
+
+var clipboardTools = []clipboardTool{
+	{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}},
+	{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}},
+	{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}},
+	{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}},
+	{copy: []string{"clip.exe"}},
+}
+
+func findClipboardTool() (*clipboardTool, error) {
+	for _, t := range clipboardTools {
+		if _, err := exec.LookPath(t.copy[0]); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, ErrNoClipboard
+}
+
+func writeClipboard(t *clipboardTool, text string) error {
+	cmd := exec.Command(t.copy[0], t.copy[1:]...)
+	cmd.Stdin = bytes.NewBufferString(text)
+	return cmd.Run()
+}
+
+func readClipboard(t *clipboardTool) ([]byte, error) {
+	if t.paste == nil {
+		return nil, errors.New("clipboard can not be read")
+	}
+	return exec.Command(t.paste[0], t.paste[1:]...).Output()
+}
+
+func clipboardHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func copyCommand() *command {
+	fs := newFlagSet("copy")
+	field := fs.String("field", "password", "field to copy")
+	timeout := fs.Duration("timeout", 45*time.Second, "clear the clipboard after this long, 0 to never clear it")
+
+	return &command{
+		name:    "copy",
+		args:    "<entry>",
+		summary: "Copy a field of an entry to the clipboard, clearing it after a timeout",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			ref, err := oneArg(args)
+			if err != nil {
+				return err
+			}
+			t, err := findClipboardTool()
+			if err != nil {
+				return err
+			}
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			_, r, err := findEntry(ctx, s, ref)
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				return ErrEntryUnreadable
+			}
+			v, ok := r[*field]
+			if !ok {
+				return fmt.Errorf("entry has no field %q", *field)
+			}
+
+			err = writeClipboard(t, v)
+			if err != nil {
+				return err
+			}
+			if *timeout <= 0 {
+				fmt.Fprintf(os.Stderr, "Copied %s to the clipboard\n", *field)
+				return nil
+			}
+
+			// clear the clipboard from a detached process so we can exit now
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			clear := exec.Command(exe, "clear-clipboard", "-after", timeout.String())
+			clear.Env = append(os.Environ(), clipboardHashEnv+"="+clipboardHash([]byte(v)))
+			err = clear.Start()
+			if err != nil {
+				return err
+			}
+			clear.Process.Release()
+
+			fmt.Fprintf(os.Stderr, "Copied %s to the clipboard, clearing it in %s\n", *field, timeout)
+			return nil
+		},
+	}
+}
+
+func clearClipboardCommand() *command {
+	fs := newFlagSet("clear-clipboard")
+	after := fs.Duration("after", 0, "wait this long before clearing")
+
+	return &command{
+		name:    "clear-clipboard",
+		summary: "Clear the clipboard if it still holds a copied secret",
+		hidden:  true,
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			t, err := findClipboardTool()
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(*after):
+			}
+
+			// don't clobber something the user copied since
+			if hash := os.Getenv(clipboardHashEnv); hash != "" {
+				current, err := readClipboard(t)
+				if err == nil && clipboardHash(current) != hash {
+					return nil
+				}
+			}
+			return writeClipboard(t, "")
+		},
+	}
+}
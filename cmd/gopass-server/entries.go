@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+var ErrEntryNotFound = errors.New("no entry with that ID or name")
+var ErrEntryAmbiguous = errors.New("several entries have that name, use the ID instead")
+var ErrEntryUnreadable = errors.New("entry was written by a newer client or is corrupt")
+
+// fieldsFlag collects repeated -set key=value flags
+type fieldsFlag map[string]string
+
+func (f fieldsFlag) String() string {
+	return ""
+}
+
+func (f fieldsFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New("expected key=value")
+	}
+	f[kv[0]] = kv[1]
+	return nil
+}
+
+// fileFieldsFlag collects repeated -set-file key=path flags, reading each file
+type fileFieldsFlag map[string]string
+
+func (f fileFieldsFlag) String() string {
+	return ""
+}
+
+func (f fileFieldsFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New("expected key=path")
+	}
+	b, err := ioutil.ReadFile(kv[1])
+	if err != nil {
+		return err
+	}
+	f[kv[0]] = string(b)
+	return nil
+}
+
+// namesFlag collects repeated flags naming a field
+type namesFlag []string
+
+func (n *namesFlag) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *namesFlag) Set(s string) error {
+	*n = append(*n, s)
+	return nil
+}
+
+// findEntry fetches the vault and looks up an entry of it with lookupEntry
+func findEntry(ctx context.Context, s *client.Session, ref string) (*client.Entry, cli.Record, error) {
+	v, err := s.Vault(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lookupEntry(v, ref)
+}
+
+// lookupEntry looks up an entry by its ID, or failing that by its name.
+// entries whose plaintext doesn't parse are only found by ID, with a nil record
+func lookupEntry(v *client.Vault, ref string) (*client.Entry, cli.Record, error) {
+	var found *client.Entry
+	var record cli.Record
+	for _, e := range v.Entries {
+		r := cli.ParseRecord(e.Data)
+		if e.ID == ref {
+			return e, r, nil
+		}
+		if r != nil && r[cli.FieldName] == ref {
+			if found != nil {
+				return nil, nil, ErrEntryAmbiguous
+			}
+			found, record = e, r
+		}
+	}
+	if found == nil {
+		return nil, nil, ErrEntryNotFound
+	}
+	return found, record, nil
+}
+
+func oneArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("expected a single entry ID or name")
+	}
+	return args[0], nil
+}
+
+func lsCommand() *command {
+	fs := newFlagSet("ls")
+
+	return &command{
+		name:    "ls",
+		summary: "List the entries of the vault",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			v, err := s.Vault(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tUSERNAME\tURL")
+			for _, e := range v.Entries {
+				r := cli.ParseRecord(e.Data)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.ID, r[cli.FieldName], r[cli.FieldUsername], r[cli.FieldURL])
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func showCommand() *command {
+	fs := newFlagSet("show")
+	field := fs.String("field", "", "only print the value of this field")
+
+	return &command{
+		name:    "show",
+		args:    "<entry>",
+		summary: "Print the fields of an entry",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			ref, err := oneArg(args)
+			if err != nil {
+				return err
+			}
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			e, r, err := findEntry(ctx, s, ref)
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				return ErrEntryUnreadable
+			}
+
+			if *field != "" {
+				v, ok := r[*field]
+				if !ok {
+					return fmt.Errorf("entry has no field %q", *field)
+				}
+				fmt.Println(v)
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "id:\t%s\n", e.ID)
+			for _, f := range r.Fields() {
+				fmt.Fprintf(w, "%s:\t%s\n", f, r[f])
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func addCommand() *command {
+	fs := newFlagSet("add")
+	name := fs.String("name", "", "name of the entry")
+	typ := fs.String("type", "", "type of the entry, e.g. ssh-key, entries with a type aren't prompted for a password")
+	username := fs.String("username", "", "username")
+	url := fs.String("url", "", "URL the credentials are for")
+	notes := fs.String("notes", "", "free form notes")
+	generate := fs.Bool("generate", false, "generate the password instead of prompting for it")
+	length := fs.Int("length", defaultPasswordLength, "length of a generated password")
+	fields := make(fieldsFlag)
+	fs.Var(fields, "set", "set a custom field, as key=value, may be repeated")
+	fs.Var(fileFieldsFlag(fields), "set-file", "set a custom field to the contents of a file, as key=path, may be repeated")
+
+	return &command{
+		name:    "add",
+		summary: "Add an entry, prompting for its password",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			if *name == "" {
+				return errors.New("-name is required")
+			}
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+
+			r := cli.Record{}
+			for k, v := range fields {
+				r[k] = v
+			}
+			for k, v := range map[string]string{
+				cli.FieldType: *typ, cli.FieldName: *name, cli.FieldUsername: *username, cli.FieldURL: *url, cli.FieldNotes: *notes,
+			} {
+				if v != "" {
+					r[k] = v
+				}
+			}
+
+			switch {
+			case *generate:
+				r[cli.FieldPassword], err = generatePassword(*length, true)
+			case *typ == "":
+				r[cli.FieldPassword], err = cli.PromptSecret("Password: ")
+			}
+			if err != nil {
+				return err
+			}
+
+			data, err := r.Marshal()
+			if err != nil {
+				return err
+			}
+			e, err := s.CreateEntry(ctx, data)
+			if err != nil {
+				return err
+			}
+			fmt.Println(e.ID)
+			return nil
+		},
+	}
+}
+
+func editCommand() *command {
+	fs := newFlagSet("edit")
+	fs.String("name", "", "new name of the entry")
+	fs.String("username", "", "new username")
+	fs.String("url", "", "new URL")
+	fs.String("notes", "", "new notes")
+	password := fs.Bool("password", false, "prompt for a new password")
+	generate := fs.Bool("generate", false, "generate a new password")
+	length := fs.Int("length", defaultPasswordLength, "length of a generated password")
+	fields := make(fieldsFlag)
+	fs.Var(fields, "set", "set a custom field, as key=value, may be repeated")
+	fs.Var(fileFieldsFlag(fields), "set-file", "set a custom field to the contents of a file, as key=path, may be repeated")
+	var unset namesFlag
+	fs.Var(&unset, "unset", "remove a field, may be repeated")
+
+	return &command{
+		name:    "edit",
+		args:    "<entry>",
+		summary: "Change the fields of an entry",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			ref, err := oneArg(args)
+			if err != nil {
+				return err
+			}
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			e, r, err := findEntry(ctx, s, ref)
+			if err != nil {
+				return err
+			}
+			// writing back what can't be read would lose it
+			if r == nil {
+				return ErrEntryUnreadable
+			}
+
+			// only fields given on the command line change
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case cli.FieldName, cli.FieldUsername, cli.FieldURL, cli.FieldNotes:
+					r[f.Name] = f.Value.String()
+				}
+			})
+			for k, v := range fields {
+				r[k] = v
+			}
+			for _, k := range unset {
+				delete(r, k)
+			}
+
+			switch {
+			case *generate:
+				r[cli.FieldPassword], err = generatePassword(*length, true)
+			case *password:
+				r[cli.FieldPassword], err = cli.PromptSecret("New password: ")
+			}
+			if err != nil {
+				return err
+			}
+
+			data, err := r.Marshal()
+			if err != nil {
+				return err
+			}
+			_, err = s.UpdateEntry(ctx, e.ID, data)
+			return err
+		},
+	}
+}
+
+func rmCommand() *command {
+	fs := newFlagSet("rm")
+	force := fs.Bool("f", false, "don't ask for confirmation")
+
+	return &command{
+		name:    "rm",
+		args:    "<entry>",
+		summary: "Remove an entry",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			ref, err := oneArg(args)
+			if err != nil {
+				return err
+			}
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			e, r, err := findEntry(ctx, s, ref)
+			if err != nil {
+				return err
+			}
+
+			if !*force {
+				answer, err := cli.Prompt(fmt.Sprintf("Remove %s (%s)? [y/N] ", r[cli.FieldName], e.ID))
+				if err != nil {
+					return err
+				}
+				if !strings.EqualFold(answer, "y") {
+					return nil
+				}
+			}
+			return s.DeleteEntry(ctx, e.ID)
+		},
+	}
+}
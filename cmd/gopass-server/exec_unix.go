@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// execve replaces this process with the command, so the resolved secrets only
+// ever live in the command's memory and environment
+func execve(path string, args []string, env []string) error {
+	return syscall.Exec(path, args, env)
+}
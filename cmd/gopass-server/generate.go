@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const defaultPasswordLength = 24
+
+const (
+	alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	symbols      = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+)
+
+// generatePassword picks length characters uniformly at random using crypto/rand
+func generatePassword(length int, withSymbols bool) (string, error) {
+	if length < 1 {
+		return "", errors.New("password length must be positive")
+	}
+
+	charset := alphanumeric
+	if withSymbols {
+		charset += symbols
+	}
+
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+func generateCommand() *command {
+	fs := newFlagSet("generate")
+	length := fs.Int("length", defaultPasswordLength, "number of characters")
+	noSymbols := fs.Bool("no-symbols", false, "only use letters and digits")
+
+	return &command{
+		name:    "generate",
+		summary: "Print a random password",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			p, err := generatePassword(*length, !*noSymbols)
+			if err != nil {
+				return err
+			}
+			fmt.Println(p)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GeneratePassword(t *testing.T) {
+	p, err := generatePassword(64, false)
+	require.NoError(t, err)
+	require.Len(t, p, 64)
+	for _, c := range p {
+		require.True(t, strings.ContainsRune(alphanumeric, c), "%q is not alphanumeric", c)
+	}
+
+	p, err = generatePassword(64, true)
+	require.NoError(t, err)
+	require.Len(t, p, 64)
+
+	_, err = generatePassword(0, true)
+	require.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rokusei/gopass-server/client/importer"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+const defaultImportBatch = 100
+
+// readExport reads the export at path in the given format
+func readExport(format, path, keyFile string) ([]importer.Entry, error) {
+	if format == "pass" {
+		return importer.Pass(path, importer.GPG)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "bitwarden":
+		return importer.Bitwarden(f)
+	case "lastpass":
+		return importer.LastPass(f)
+	case "1password":
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return importer.OnePassword(f, info.Size())
+	case "keepass":
+		var key []byte
+		if keyFile != "" {
+			key, err = ioutil.ReadFile(keyFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		password, err := cli.PromptSecret("KeePass password: ")
+		if err != nil {
+			return nil, err
+		}
+		return importer.KeePass(f, password, key)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(importer.Formats, ", "))
+}
+
+// importEntries uploads entries in batches, each batch is created all at once
+// or not at all. it returns how many entries were created
+func importEntries(ctx context.Context, v cli.Vault, entries []importer.Entry, batch int) (int, error) {
+	if batch < 1 {
+		return 0, errors.New("batch size must be at least 1")
+	}
+
+	created := 0
+	for len(entries) > 0 {
+		n := batch
+		if n > len(entries) {
+			n = len(entries)
+		}
+
+		data := make([][]byte, 0, n)
+		for _, e := range entries[:n] {
+			b, err := cli.Record(e).Marshal()
+			if err != nil {
+				return created, err
+			}
+			data = append(data, b)
+		}
+		_, err := v.CreateEntries(ctx, data)
+		if err != nil {
+			return created, err
+		}
+
+		created += n
+		entries = entries[n:]
+	}
+	return created, nil
+}
+
+func importCommand() *command {
+	fs := newFlagSet("import")
+	format := fs.String("format", "", "format of the export, one of "+strings.Join(importer.Formats, ", "))
+	keyFile := fs.String("key-file", "", "key file unlocking a KeePass database")
+	batch := fs.Int("batch", defaultImportBatch, "entries uploaded per request")
+	dryRun := fs.Bool("dry-run", false, "list the entries that would be imported without uploading them")
+
+	return &command{
+		name:    "import",
+		args:    "<export file or pass store directory>",
+		summary: "Import the entries of another password manager",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected the path of a single export")
+			}
+			if *format == "" {
+				return errors.New("-format is required")
+			}
+
+			entries, err := readExport(*format, args[0], *keyFile)
+			if err != nil {
+				return err
+			}
+			if *dryRun {
+				for _, e := range entries {
+					name := e[importer.FieldName]
+					if e[importer.FieldFolder] != "" {
+						name = e[importer.FieldFolder] + "/" + name
+					}
+					fmt.Println(name)
+				}
+				return nil
+			}
+
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			n, err := importEntries(ctx, s, entries, *batch)
+			fmt.Fprintf(os.Stderr, "imported %d of %d entries\n", n, len(entries))
+			return err
+		},
+	}
+}
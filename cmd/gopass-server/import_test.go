@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/rokusei/gopass-server/client/importer"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+	"github.com/rokusei/gopass-server/cmd/internal/cli/clitest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportEntries(t *testing.T) {
+	ctx := context.Background()
+	v := &clitest.Vault{}
+
+	var entries []importer.Entry
+	for i := 0; i < 25; i++ {
+		entries = append(entries, importer.Entry{
+			importer.FieldName:     fmt.Sprintf("entry %d", i),
+			importer.FieldPassword: "hunter2",
+		})
+	}
+
+	n, err := importEntries(ctx, v, entries, 10)
+	require.NoError(t, err)
+	require.Equal(t, 25, n)
+
+	vault, err := v.Vault(ctx)
+	require.NoError(t, err)
+	require.Len(t, vault.Entries, 25)
+	r := cli.ParseRecord(vault.Entries[24].Data)
+	require.Equal(t, "entry 24", r[cli.FieldName])
+	require.Equal(t, "hunter2", r[cli.FieldPassword])
+
+	_, err = importEntries(ctx, v, entries, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/client/keyring"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+func registerCommand() *command {
+	fs := newFlagSet("register")
+	server := fs.String("server", cli.Server(), "URL of the gopass-server")
+	email := fs.String("email", "", "email address to register")
+
+	return &command{
+		name:    "register",
+		summary: "Create an account",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			var err error
+			if *email == "" {
+				*email, err = cli.Prompt("Email: ")
+				if err != nil {
+					return err
+				}
+			}
+			password, err := cli.PromptSecret("Master password: ")
+			if err != nil {
+				return err
+			}
+			confirm, err := cli.PromptSecret("Repeat master password: ")
+			if err != nil {
+				return err
+			}
+			if password != confirm {
+				return errors.New("master passwords do not match")
+			}
+
+			_, _, err = client.New(*server).Register(ctx, *email, password)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(os.Stderr, "Account created, log in with your email and master password")
+			return nil
+		},
+	}
+}
+
+func loginCommand() *command {
+	fs := newFlagSet("login")
+	server := fs.String("server", cli.Server(), "URL of the gopass-server")
+	email := fs.String("email", "", "email address of the account")
+	salt := fs.String("salt", os.Getenv("GOPASS_SERVER_SALT"), "salt printed at registration by older versions, prompted for if the account needs it")
+
+	return &command{
+		name:    "login",
+		summary: "Start a session and cache it in the keyring",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			var err error
+			if *email == "" {
+				*email, err = cli.Prompt("Email: ")
+				if err != nil {
+					return err
+				}
+			}
+			password, err := cli.PromptSecret("Master password: ")
+			if err != nil {
+				return err
+			}
+
+			c := client.New(*server)
+			s, err := c.Login(ctx, *email, password, []byte(*salt))
+			if errors.Is(err, client.ErrSaltRequired) {
+				*salt, err = cli.PromptSecret("Salt: ")
+				if err != nil {
+					return err
+				}
+				s, err = c.Login(ctx, *email, password, []byte(*salt))
+				if err == nil {
+					fmt.Fprintln(os.Stderr, "Run `gopass-server upgrade-kdf` to stop needing the salt")
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			kdf, err := c.Prelogin(ctx, *email)
+			if err != nil {
+				return err
+			}
+			kr, err := keyring.Default()
+			if err != nil {
+				return err
+			}
+			err = cli.SaveSession(kr, *server, *email, kdf, s)
+			if err != nil {
+				return err
+			}
+
+			_, expiresAt := s.Token()
+			fmt.Fprintf(os.Stderr, "Logged in until %s\n", expiresAt.Local().Format("2006-01-02 15:04"))
+			return nil
+		},
+	}
+}
+
+func upgradeKDFCommand() *command {
+	fs := newFlagSet("upgrade-kdf")
+	email := fs.String("email", "", "email address of the account, the one logged in with by default")
+	salt := fs.String("salt", os.Getenv("GOPASS_SERVER_SALT"), "salt printed at registration by older versions, prompted for if the account needs it")
+
+	return &command{
+		name:    "upgrade-kdf",
+		summary: "Derive the account's keys with Argon2id, re-encrypting the vault",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			kr, err := keyring.Default()
+			if err != nil {
+				return err
+			}
+			stored, err := cli.LoadSession(kr)
+			if err != nil {
+				return err
+			}
+			if *email == "" {
+				*email = stored.Email
+			}
+			if *email == "" {
+				*email, err = cli.Prompt("Email: ")
+				if err != nil {
+					return err
+				}
+			}
+
+			password, err := cli.PromptSecret("Master password: ")
+			if err != nil {
+				return err
+			}
+			s, err := stored.Resume(password, []byte(*salt))
+			if errors.Is(err, client.ErrSaltRequired) {
+				*salt, err = cli.PromptSecret("Salt: ")
+				if err != nil {
+					return err
+				}
+				s, err = stored.Resume(password, []byte(*salt))
+			}
+			if err != nil {
+				return err
+			}
+			err = s.UpgradeKDF(ctx, *email, password, []byte(*salt))
+			if err != nil {
+				return err
+			}
+
+			// the cached KDF no longer derives the key
+			u, err := s.User(ctx)
+			if err != nil {
+				return err
+			}
+			err = cli.SaveSession(kr, stored.Server, *email, u.KDF, s)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "Upgraded, the salt is no longer needed to log in")
+			return nil
+		},
+	}
+}
+
+func logoutCommand() *command {
+	fs := newFlagSet("logout")
+
+	return &command{
+		name:    "logout",
+		summary: "End the session and remove it from the keyring",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			kr, err := keyring.Default()
+			if err != nil {
+				return err
+			}
+
+			s, err := cli.OpenSession()
+			if err == nil {
+				// the session may already have expired, forget it regardless
+				s.Logout(ctx)
+			}
+			return cli.DeleteSession(kr)
+		},
+	}
+}
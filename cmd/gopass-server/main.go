@@ -0,0 +1,92 @@
+// Command gopass-server is a command line client for gopass-server vaults
+//
+// login caches a session in the keyring file under the user's config dir,
+// every other command uses that session.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// a command is a gopass-server subcommand
+type command struct {
+	name    string
+	args    string
+	summary string
+	// hidden commands are not listed in the usage
+	hidden bool
+	fs     *flag.FlagSet
+	run    func(ctx context.Context, args []string) error
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
+
+func commands() []*command {
+	return []*command{
+		registerCommand(),
+		loginCommand(),
+		upgradeKDFCommand(),
+		logoutCommand(),
+		lsCommand(),
+		showCommand(),
+		addCommand(),
+		editCommand(),
+		rmCommand(),
+		importCommand(),
+		generateCommand(),
+		copyCommand(),
+		runCommand(),
+		sshAgentCommand(),
+		clearClipboardCommand(),
+	}
+}
+
+func usage(cmds []*command) {
+	fmt.Fprintf(os.Stderr, "Usage: gopass-server <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range cmds {
+		if !c.hidden {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nRun `gopass-server <command> -h` for the flags of a command.\n")
+}
+
+func main() {
+	cmds := commands()
+	if len(os.Args) < 2 {
+		usage(cmds)
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for _, c := range cmds {
+		if c.name == os.Args[1] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		usage(cmds)
+		os.Exit(2)
+	}
+
+	cmd.fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gopass-server %s [flags] %s\n\n%s\n\n", cmd.name, cmd.args, cmd.summary)
+		cmd.fs.PrintDefaults()
+	}
+	cmd.fs.Parse(os.Args[2:])
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err := cmd.run(ctx, cmd.fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gopass-server %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
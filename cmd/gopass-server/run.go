@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// referenceScheme prefixes environment values that refer to a vault entry field,
+// e.g. gps://vault/<entry ID or name>/password
+const referenceScheme = "gps"
+
+// parseEnvSpec reads a YAML mapping of environment variable names to either
+// literal values or gps:// references
+func parseEnvSpec(b []byte) (map[string]string, error) {
+	spec := make(map[string]string)
+	err := yaml.Unmarshal(b, &spec)
+	if err != nil {
+		return nil, err
+	}
+	for name := range spec {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return spec, nil
+}
+
+// resolveReference returns the value of the entry field a gps:// reference points to
+func resolveReference(v *client.Vault, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host != "vault" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid reference %q, expected gps://vault/<entry>/<field>", ref)
+	}
+	entryRef, field := parts[0], parts[1]
+
+	_, record, err := lookupEntry(v, entryRef)
+	if err == nil && record == nil {
+		err = ErrEntryUnreadable
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+
+	value, ok := record[field]
+	if !ok {
+		return "", fmt.Errorf("%s: entry has no field %q", ref, field)
+	}
+	return value, nil
+}
+
+// resolveEnv resolves every reference in the spec, returning NAME=value pairs
+func resolveEnv(v *client.Vault, spec map[string]string) ([]string, error) {
+	var env []string
+	for name, value := range spec {
+		if strings.HasPrefix(value, referenceScheme+"://") {
+			var err error
+			value, err = resolveReference(v, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// childEnv returns the inherited environment without the variables of the
+// spec, followed by the resolved ones. a variable set twice is read as its
+// first value by getenv, so the inherited one must go
+func childEnv(inherited []string, spec map[string]string, resolved []string) []string {
+	env := make([]string, 0, len(inherited)+len(resolved))
+	for _, kv := range inherited {
+		name := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			name = kv[:i]
+		}
+		if _, ok := spec[name]; !ok {
+			env = append(env, kv)
+		}
+	}
+	return append(env, resolved...)
+}
+
+func runCommand() *command {
+	fs := newFlagSet("run")
+	envFile := fs.String("env-file", "", "YAML file mapping environment variables to values or gps://vault/<entry>/<field> references")
+
+	return &command{
+		name:    "run",
+		args:    "-- <command> [args]",
+		summary: "Run a command with secrets from the vault in its environment",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no command to run")
+			}
+			if *envFile == "" {
+				return errors.New("-env-file is required")
+			}
+
+			b, err := ioutil.ReadFile(*envFile)
+			if err != nil {
+				return err
+			}
+			spec, err := parseEnvSpec(b)
+			if err != nil {
+				return fmt.Errorf("%s: %w", *envFile, err)
+			}
+
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			v, err := s.Vault(ctx)
+			if err != nil {
+				return err
+			}
+			env, err := resolveEnv(v, spec)
+			if err != nil {
+				return err
+			}
+
+			path, err := exec.LookPath(args[0])
+			if err != nil {
+				return err
+			}
+			return execve(path, args, childEnv(os.Environ(), spec, env))
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ResolveEnv(t *testing.T) {
+	v := &client.Vault{
+		Entries: []*client.Entry{
+			{ID: "0acfec7e", Data: []byte(`{"name":"db","username":"app","password":"s3cret"}`)},
+			{ID: "71f5ab04", Data: []byte(`{"name":"stripe","password":"sk_test"}`)},
+			{ID: "c2d9e1f7", Data: []byte(`{"version":99,"name":"db","password":"from the future"}`)},
+		},
+	}
+
+	spec, err := parseEnvSpec([]byte(`
+DB_USER: gps://vault/0acfec7e/username
+DB_PASSWORD: gps://vault/db/password
+STRIPE_KEY: gps://vault/71f5ab04/password
+LOG_LEVEL: debug
+`))
+	require.NoError(t, err)
+
+	env, err := resolveEnv(v, spec)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"DB_PASSWORD=s3cret",
+		"DB_USER=app",
+		"LOG_LEVEL=debug",
+		"STRIPE_KEY=sk_test",
+	}, env)
+
+	for _, ref := range []string{
+		"gps://vault/missing/password",
+		"gps://vault/db/missing",
+		"gps://vault/db",
+		"gps://other/db/password",
+	} {
+		_, err = resolveEnv(v, map[string]string{"X": ref})
+		require.Error(t, err, ref)
+	}
+
+	// entries of a newer schema are neither found by name nor read by ID
+	_, err = resolveEnv(v, map[string]string{"X": "gps://vault/c2d9e1f7/password"})
+	require.ErrorIs(t, err, ErrEntryUnreadable)
+
+	_, err = parseEnvSpec([]byte(`"A=B": value`))
+	require.Error(t, err)
+}
+
+func Test_ChildEnv(t *testing.T) {
+	spec := map[string]string{"DB_PASSWORD": "gps://vault/db/password"}
+	inherited := []string{"HOME=/home/me", "DB_PASSWORD=from the shell", "DB_PASSWORD_FILE=/run/secret"}
+
+	// the inherited value would shadow the secret
+	env := childEnv(inherited, spec, []string{"DB_PASSWORD=s3cret"})
+	require.Equal(t, []string{"HOME=/home/me", "DB_PASSWORD_FILE=/run/secret", "DB_PASSWORD=s3cret"}, env)
+}
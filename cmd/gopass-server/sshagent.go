@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/client/sshagent"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+)
+
+// sshKeyType marks the vault entries holding ssh private keys
+const sshKeyType = "ssh-key"
+
+// ssh-key record fields
+const (
+	fieldPrivateKey = "private-key"
+	fieldPassphrase = "passphrase"
+	fieldConfirm    = "confirm"
+)
+
+// loadSSHKeys parses every ssh-key entry of the vault
+func loadSSHKeys(ctx context.Context, s cli.Vault) ([]*sshagent.Key, error) {
+	items, err := cli.Items(ctx, s, sshKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*sshagent.Key
+	for _, item := range items {
+		comment := item.Record[cli.FieldName]
+		if comment == "" {
+			comment = item.ID
+		}
+		k, err := sshagent.ParseKey([]byte(item.Record[fieldPrivateKey]), []byte(item.Record[fieldPassphrase]), comment)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %w", item.ID, err)
+		}
+		k.Confirm = item.Record[fieldConfirm] == "true"
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// askpassConfirm asks the user to allow the use of a key with $SSH_ASKPASS,
+// the same way ssh-agent does for keys added with ssh-add -c
+func askpassConfirm(k *sshagent.Key) bool {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		fmt.Fprintf(os.Stderr, "refusing to use %s: confirmation requires SSH_ASKPASS\n", k.Comment)
+		return false
+	}
+
+	cmd := exec.Command(askpass, fmt.Sprintf("Allow use of key %s?", k.Comment))
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
+
+func defaultAgentSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("gopass-server-agent-%d.sock", os.Getuid()))
+}
+
+// listenPrivate listens on a unix socket at path that only the user can
+// connect to. the socket is created in a private directory and only moved to
+// path once restricted, so that there is no moment others can connect to it
+func listenPrivate(path string) (*net.UnixListener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".gopass-server-agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "agent.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket moves, removing it is up to the caller
+	l.SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		os.Remove(path)
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func sshAgentCommand() *command {
+	fs := newFlagSet("ssh-agent")
+	socket := fs.String("socket", defaultAgentSocket(), "path of the agent's unix socket")
+	lockAfter := fs.Duration("lock-after", 15*time.Minute, "forget the keys after they weren't used for this long, 0 to never forget them")
+	confirm := fs.Bool("confirm", false, "confirm every use of every key with $SSH_ASKPASS")
+	salt := fs.String("salt", os.Getenv("GOPASS_SERVER_SALT"), "salt printed at registration by older versions, used to check the master password when unlocking accounts that need it")
+
+	return &command{
+		name:    "ssh-agent",
+		summary: "Run an ssh-agent serving the ssh-key entries of the vault",
+		fs:      fs,
+		run: func(ctx context.Context, args []string) error {
+			s, err := cli.OpenSession()
+			if err != nil {
+				return err
+			}
+			keys, err := loadSSHKeys(ctx, s)
+			if err != nil {
+				return err
+			}
+
+			u, err := s.User(ctx)
+			if err != nil {
+				return err
+			}
+			if len(u.KDF.Salt) == 0 && *salt == "" {
+				*salt, err = cli.PromptSecret("Salt (to unlock the agent with your master password): ")
+				if err != nil {
+					return err
+				}
+			}
+
+			a := sshagent.New(keys, sshagent.Options{
+				Load:       unlockLoader(ctx, s, u.KDF, []byte(*salt)),
+				Confirm:    askpassConfirm,
+				ConfirmAll: *confirm,
+				LockAfter:  *lockAfter,
+			})
+			// the agent holds the only reference to the keys, locking it drops them
+			n := len(keys)
+			keys = nil
+
+			l, err := listenPrivate(*socket)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(*socket)
+
+			go func() {
+				<-ctx.Done()
+				l.Close()
+			}()
+
+			fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", *socket)
+			fmt.Fprintf(os.Stderr, "Serving %d keys, unlock with `ssh-add -X` and your master password after locking\n", n)
+			err = a.Serve(l)
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		},
+	}
+}
+
+// unlockLoader reloads the keys once the master password passed to `ssh-add -X`
+// is shown to derive the session's EncryptionKey
+func unlockLoader(ctx context.Context, s *client.Session, kdf *client.KDF, salt []byte) func([]byte) ([]*sshagent.Key, error) {
+	return func(passphrase []byte) ([]*sshagent.Key, error) {
+		cr, err := kdf.Credentials("", string(passphrase), salt)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(cr.Key, s.Key()) != 1 {
+			return nil, client.ErrWrongMasterPassword
+		}
+		return loadSSHKeys(ctx, s)
+	}
+}
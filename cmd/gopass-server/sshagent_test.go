@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ListenPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.sock")
+	// a stale socket of an earlier agent is replaced
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	l, err := listenPrivate(path)
+	require.NoError(t, err)
+	defer l.Close()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, fi.Mode()&os.ModeType)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// nothing else is left behind
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+}
@@ -0,0 +1,82 @@
+// Package clitest provides an in-memory cli.Vault for tests
+package clitest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rokusei/gopass-server/client"
+)
+
+var ErrEntryNotFound = errors.New("entry not found")
+
+// a Vault keeps entries in memory, in creation order
+type Vault struct {
+	mu      sync.Mutex
+	next    int
+	entries []*client.Entry
+}
+
+func (v *Vault) Vault(ctx context.Context) (*client.Vault, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vault := &client.Vault{ID: "vault"}
+	for _, e := range v.entries {
+		c := *e
+		vault.Entries = append(vault.Entries, &c)
+	}
+	return vault, nil
+}
+
+func (v *Vault) CreateEntry(ctx context.Context, data []byte) (*client.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.next++
+	e := &client.Entry{ID: fmt.Sprintf("entry-%d", v.next), Data: data}
+	v.entries = append(v.entries, e)
+	c := *e
+	return &c, nil
+}
+
+func (v *Vault) CreateEntries(ctx context.Context, data [][]byte) ([]*client.Entry, error) {
+	var entries []*client.Entry
+	for _, d := range data {
+		e, err := v.CreateEntry(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (v *Vault) UpdateEntry(ctx context.Context, id string, data []byte) (*client.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, e := range v.entries {
+		if e.ID == id {
+			e.Data = data
+			c := *e
+			return &c, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+func (v *Vault) DeleteEntry(ctx context.Context, id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i, e := range v.entries {
+		if e.ID == id {
+			v.entries = append(v.entries[:i], v.entries[i+1:]...)
+			return nil
+		}
+	}
+	return ErrEntryNotFound
+}
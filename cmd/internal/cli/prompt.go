@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var ErrNoTerminal = errors.New("no terminal to ask on")
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Prompt reads a line from stdin after writing prompt to stderr
+func Prompt(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// PromptSecret is Prompt without echoing the input when stdin is a terminal
+func PromptSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return Prompt(prompt)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// PromptTerminal is PromptSecret on the controlling terminal, so that commands
+// reading other input from stdin, like the credential helpers, can still ask
+// for secrets. it never reads stdin, and fails with ErrNoTerminal without a terminal
+func PromptTerminal(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", ErrNoTerminal
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	b, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
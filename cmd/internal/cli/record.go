@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"sort"
+
+	"github.com/rokusei/gopass-server/client/schema"
+)
+
+// well known Record fields
+const (
+	FieldType     = schema.FieldType
+	FieldName     = schema.FieldName
+	FieldUsername = schema.FieldUsername
+	FieldPassword = schema.FieldPassword
+	FieldURL      = schema.FieldURL
+	FieldNotes    = schema.FieldNotes
+)
+
+// a Record is the plaintext of a vault entry as the command line tools see it,
+// the flat set of named fields of a schema.Record
+type Record map[string]string
+
+// ParseRecord decodes the plaintext of an entry of any schema version. the
+// server doesn't hand out entries newer than this client understands, should
+// one turn up anyway it decodes as nil, unlike an empty entry, so that it isn't
+// edited and written back without its fields
+func ParseRecord(data []byte) Record {
+	r, err := schema.Parse(data)
+	if err != nil {
+		return nil
+	}
+	return r.Flat()
+}
+
+// Marshal writes the record in the current schema version
+func (r Record) Marshal() ([]byte, error) {
+	return schema.FromFlat(r).Marshal()
+}
+
+// Fields returns the record's field names, well known fields first
+func (r Record) Fields() []string {
+	order := map[string]int{
+		FieldType: 1, FieldName: 2, FieldUsername: 3, FieldPassword: 4, FieldURL: 5, FieldNotes: 6,
+	}
+	var fields []string
+	for f := range r {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		oi, oj := order[fields[i]], order[fields[j]]
+		if oi == 0 && oj == 0 {
+			return fields[i] < fields[j]
+		}
+		if oi == 0 || oj == 0 {
+			return oj == 0
+		}
+		return oi < oj
+	})
+	return fields
+}
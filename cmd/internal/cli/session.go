@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/client/keyring"
+)
+
+// sessionName is the name the session is stored under in the keyring
+const sessionName = "session"
+
+// DefaultServer is used when neither a flag nor GOPASS_SERVER_URL names a server
+const DefaultServer = "http://localhost:8080"
+
+var ErrNotLoggedIn = errors.New("not logged in, run `gopass-server login` first")
+
+// a StoredSession is what `gopass-server login` caches in the keyring so that
+// later commands, and the credential helpers, don't need to log in again.
+// the EncryptionKey is never stored, it is derived from the master password
+// whenever the session is resumed
+type StoredSession struct {
+	Server string
+	// Email is the account's email address, changing its KDF authenticates with it
+	Email     string
+	Token     string
+	ExpiresAt time.Time
+	// KDF derives the EncryptionKey, its salt is empty for accounts whose salt
+	// the server doesn't hold
+	KDF *client.KDF
+	// KeyCheck tells a mistyped master password apart before anything is
+	// encrypted with the wrong key, guessing against it costs a derivation
+	// like guessing against any entry of the vault
+	KeyCheck []byte
+}
+
+// keyCheck returns the KeyCheck of an EncryptionKey
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("gopass-server session key check"))
+	return mac.Sum(nil)
+}
+
+// Resume derives the EncryptionKey from masterPassword and resumes the
+// session with it, salt is only used when the KDF holds none
+func (st *StoredSession) Resume(masterPassword string, salt []byte) (*client.Session, error) {
+	cr, err := st.KDF.Credentials("", masterPassword, salt)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keyCheck(cr.Key), st.KeyCheck) {
+		return nil, client.ErrWrongMasterPassword
+	}
+	return client.New(st.Server).Resume(st.Token, st.ExpiresAt, cr.Key), nil
+}
+
+// Server returns the server URL from GOPASS_SERVER_URL, or DefaultServer
+func Server() string {
+	if s := os.Getenv("GOPASS_SERVER_URL"); s != "" {
+		return s
+	}
+	return DefaultServer
+}
+
+// SaveSession caches a session of the account email in the keyring, along
+// with the KDF its key is derived with
+func SaveSession(kr keyring.Keyring, server, email string, kdf *client.KDF, s *client.Session) error {
+	token, expiresAt := s.Token()
+	b, err := json.Marshal(StoredSession{
+		Server:    server,
+		Email:     email,
+		Token:     token,
+		ExpiresAt: expiresAt,
+		KDF:       kdf,
+		KeyCheck:  keyCheck(s.Key()),
+	})
+	if err != nil {
+		return err
+	}
+	return kr.Set(sessionName, b)
+}
+
+// LoadSession reads the cached session from the keyring
+func LoadSession(kr keyring.Keyring) (*StoredSession, error) {
+	b, err := kr.Get(sessionName)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrNotLoggedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored StoredSession
+	err = json.Unmarshal(b, &stored)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrNotLoggedIn
+	}
+	return &stored, nil
+}
+
+// DeleteSession removes the cached session from the keyring
+func DeleteSession(kr keyring.Keyring) error {
+	err := kr.Delete(sessionName)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// OpenSession resumes the session cached in the default keyring with the
+// master password from $GOPASS_SERVER_MASTER_PASSWORD, or asked for on the
+// terminal. accounts whose salt the server doesn't hold take it from
+// $GOPASS_SERVER_SALT or ask for it too. stdin is never read, it carries the
+// requests of the credential helpers
+func OpenSession() (*client.Session, error) {
+	kr, err := keyring.Default()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := LoadSession(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := secret("GOPASS_SERVER_MASTER_PASSWORD", "Master password: ")
+	if err != nil {
+		return nil, err
+	}
+	var salt string
+	if len(stored.KDF.Salt) == 0 {
+		salt, err = secret("GOPASS_SERVER_SALT", "Salt: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored.Resume(password, []byte(salt))
+}
+
+// secret reads a secret from the environment variable env, or asks for it on the terminal
+func secret(env, prompt string) (string, error) {
+	if s := os.Getenv(env); s != "" {
+		return s, nil
+	}
+	s, err := PromptTerminal(prompt)
+	if errors.Is(err, ErrNoTerminal) {
+		return "", fmt.Errorf("%w for the session's %s, set $%s instead", err, strings.ToLower(strings.TrimSuffix(prompt, ": ")), env)
+	}
+	return s, err
+}
@@ -0,0 +1,81 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/client"
+	"github.com/rokusei/gopass-server/client/keyring"
+	"github.com/rokusei/gopass-server/cmd/internal/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_StoredSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	kr := keyring.NewFile(path)
+
+	kdf, err := client.NewKDF()
+	require.NoError(t, err)
+	cr, err := kdf.Credentials("", "hunter2", nil)
+	require.NoError(t, err)
+	s := client.New("http://localhost").Resume("token", time.Now().Add(time.Hour), cr.Key)
+	require.NoError(t, cli.SaveSession(kr, "http://localhost", "alice@example.com", kdf, s))
+
+	// only the token is cached, never the key
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(b, []byte(base64.StdEncoding.EncodeToString(cr.Key))))
+
+	stored, err := cli.LoadSession(kr)
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", stored.Email)
+	_, err = stored.Resume("hunter3", nil)
+	require.ErrorIs(t, err, client.ErrWrongMasterPassword)
+
+	resumed, err := stored.Resume("hunter2", nil)
+	require.NoError(t, err)
+	require.Equal(t, cr.Key, resumed.Key())
+	token, _ := resumed.Token()
+	require.Equal(t, "token", token)
+}
+
+// setenv sets an environment variable for the rest of the test
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func Test_OpenSessionFromEnvironment(t *testing.T) {
+	setenv(t, "XDG_CONFIG_HOME", t.TempDir())
+	kr, err := keyring.Default()
+	require.NoError(t, err)
+
+	kdf, err := client.NewKDF()
+	require.NoError(t, err)
+	cr, err := kdf.Credentials("", "hunter2", nil)
+	require.NoError(t, err)
+	s := client.New("http://localhost").Resume("token", time.Now().Add(time.Hour), cr.Key)
+	require.NoError(t, cli.SaveSession(kr, "http://localhost", "alice@example.com", kdf, s))
+
+	// the credential helpers unlock without a terminal, and never read stdin
+	setenv(t, "GOPASS_SERVER_MASTER_PASSWORD", "hunter2")
+	opened, err := cli.OpenSession()
+	require.NoError(t, err)
+	require.Equal(t, cr.Key, opened.Key())
+
+	setenv(t, "GOPASS_SERVER_MASTER_PASSWORD", "hunter3")
+	_, err = cli.OpenSession()
+	require.ErrorIs(t, err, client.ErrWrongMasterPassword)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/rokusei/gopass-server/client"
+)
+
+// a Vault is the part of a client.Session the credential helpers use,
+// so they can be tested without a server
+type Vault interface {
+	Vault(ctx context.Context) (*client.Vault, error)
+	CreateEntry(ctx context.Context, data []byte) (*client.Entry, error)
+	CreateEntries(ctx context.Context, data [][]byte) ([]*client.Entry, error)
+	UpdateEntry(ctx context.Context, id string, data []byte) (*client.Entry, error)
+	DeleteEntry(ctx context.Context, id string) error
+}
+
+// an Item is a vault entry decoded as a Record
+type Item struct {
+	ID     string
+	Record Record
+}
+
+// Items fetches the vault and decodes the entries with the given type field
+func Items(ctx context.Context, v Vault, typ string) ([]Item, error) {
+	vault, err := v.Vault(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	for _, e := range vault.Entries {
+		r := ParseRecord(e.Data)
+		if r[FieldType] == typ {
+			items = append(items, Item{ID: e.ID, Record: r})
+		}
+	}
+	return items, nil
+}
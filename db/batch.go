@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrBatchFailed = errors.New("batch failed, no operation was applied")
+var ErrBatchRolledBack = errors.New("rolled back, another operation in the batch failed")
+var ErrBatchTooLarge = errors.New("too many operations in one batch")
+var ErrUnknownOperation = errors.New("unknown batch operation")
+
+// MaxBatchOperations limits how many operations a single batch may hold
+const MaxBatchOperations = 1000
+
+// batch operation kinds
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// a BatchOperation creates, updates or deletes a single VaultEntry
+type BatchOperation struct {
+	Op string
+	// ID of the entry to update or delete
+	ID             string `json:",omitempty"`
+	EncryptedEntry []byte `json:",omitempty"`
+}
+
+// a BatchResult is the outcome of the BatchOperation at the same index
+type BatchResult struct {
+	// Entry is the created or updated entry, unset for deletes and failures
+	Entry *VaultEntry `json:",omitempty"`
+	Error string      `json:",omitempty"`
+}
+
+// ApplyVaultEntryBatch applies every operation in one transaction. when an
+// operation fails the whole batch is rolled back, the result of the failing
+// operation holds its error and ErrBatchFailed is returned along with the results
+func ApplyVaultEntryBatch(ctx context.Context, db *gorm.DB, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(ops) > MaxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(ops))
+	failed := -1
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkVaultQuota(tx, user, growth(ops)); err != nil {
+			return err
+		}
+		for i, op := range ops {
+			entry, err := applyBatchOperation(tx, user, op, schemaVersion)
+			if err != nil {
+				failed = i
+				results[i].Error = err.Error()
+				return err
+			}
+			results[i].Entry = entry
+		}
+		return nil
+	})
+	if err != nil && failed < 0 {
+		return nil, err
+	}
+	return batchResults(results, failed)
+}
+
+// batchResults marks every result but the failed one as rolled back, if an
+// operation failed
+func batchResults(results []BatchResult, failed int) ([]BatchResult, error) {
+	if failed < 0 {
+		return results, nil
+	}
+	for i := range results {
+		if i != failed {
+			results[i] = BatchResult{Error: ErrBatchRolledBack.Error()}
+		}
+	}
+	return results, ErrBatchFailed
+}
+
+func applyBatchOperation(tx *gorm.DB, user *User, op BatchOperation, schemaVersion uint) (*VaultEntry, error) {
+	switch op.Op {
+	case BatchCreate:
+		uuid, err := GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		entry := VaultEntry{
+			UUID:           uuid,
+			VaultID:        user.Vault.ID,
+			EncryptedEntry: op.EncryptedEntry,
+			SchemaVersion:  schemaVersion,
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			return nil, err
+		}
+		return &entry, nil
+
+	case BatchUpdate:
+		entry, err := findVaultEntry(tx, user, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry.EncryptedEntry = op.EncryptedEntry
+		entry.SchemaVersion = schemaVersion
+		if err := tx.Save(entry).Error; err != nil {
+			return nil, err
+		}
+		return entry, nil
+
+	case BatchDelete:
+		result := tx.Where("vault_id = ? AND uuid = ?", user.Vault.ID, op.ID).Delete(&VaultEntry{})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrEntryNotFound
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownOperation, op.Op)
+}
@@ -0,0 +1,855 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDriver is the driver name of the embedded BoltStore, whose DSN is the
+// path of its file
+const BoltDriver = "bolt"
+
+// buckets of a BoltStore, every record is gob encoded and keyed by its
+// big endian ID unless noted otherwise
+var (
+	usersBucket  = []byte("users")
+	vaultsBucket = []byte("vaults")
+	// the ID of the user of each EmailHash
+	emailsBucket = []byte("emails")
+	// one nested bucket per vault ID holding the entries of that vault, so
+	// they are iterated in creation order
+	entriesBucket = []byte("entries")
+	// the vault ID and entry ID of each entry UUID
+	entryUUIDsBucket = []byte("entry-uuids")
+	// sessions by TokenHash
+	sessionsBucket = []byte("sessions")
+	// data keys by vault ID
+	dataKeysBucket = []byte("data-keys")
+)
+
+// BoltStore is a Store on an embedded bbolt file, every method runs in a
+// single bolt transaction
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the bolt file at path
+// only one process can have it open at a time
+func OpenBoltStore(path string) (*BoltStore, error) {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, vaultsBucket, emailsBucket, entriesBucket, entryUUIDsBucket, sessionsBucket, dataKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return &BoltStore{bdb}, nil
+}
+
+// Close closes the bolt file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Backup writes a consistent copy of the bolt file to w while the store
+// keeps serving reads and writes
+func (s *BoltStore) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// BackupFile writes a Backup to path, through a temporary file next to it
+// so that a failed backup never replaces a good one
+func (s *BoltStore) BackupFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := s.Backup(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func itob(id uint) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func putRecord(b *bolt.Bucket, key []byte, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return b.Put(key, buf.Bytes())
+}
+
+// getRecord decodes the record under key into v, found is false if there is none
+func getRecord(b *bolt.Bucket, key []byte, v interface{}) (found bool, err error) {
+	data := b.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// putUser stores a user, their vault is stored separately
+func putUser(tx *bolt.Tx, user *User) error {
+	u := *user
+	u.Vault = Vault{}
+	return putRecord(tx.Bucket(usersBucket), itob(u.ID), &u)
+}
+
+// loadUser fetches a user along with their vault, without its entries
+func loadUser(tx *bolt.Tx, id uint) (*User, error) {
+	user := &User{}
+	found, err := getRecord(tx.Bucket(usersBucket), itob(id), user)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrUserDoesNotExist
+	}
+	found, err = getRecord(tx.Bucket(vaultsBucket), itob(user.VaultID), &user.Vault)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrVaultNotFound
+	}
+	return user, nil
+}
+
+// loadUserByEmail fetches the user of email like loadUser, legacy reports
+// whether they are still indexed under their legacy hash
+func loadUserByEmail(tx *bolt.Tx, email string) (user *User, legacy bool, err error) {
+	emails := tx.Bucket(emailsBucket)
+	id := emails.Get([]byte(EmailHash(email)))
+	if id == nil {
+		id, legacy = emails.Get([]byte(legacyEmailHash(email))), true
+	}
+	if id == nil {
+		return nil, false, ErrUserDoesNotExist
+	}
+	user, err = loadUser(tx, uint(binary.BigEndian.Uint64(id)))
+	return user, legacy, err
+}
+
+// reindexBoltUser moves a user from their legacy hash to the keyed EmailHash
+// like reindexUser, the user is stored by the caller
+func reindexBoltUser(tx *bolt.Tx, user *User, email string) error {
+	emails := tx.Bucket(emailsBucket)
+	emailHash := EmailHash(email)
+	if emails.Get([]byte(emailHash)) != nil {
+		return ErrEmailIndexConflict
+	}
+	if err := emails.Delete([]byte(user.EmailHash)); err != nil {
+		return err
+	}
+	if err := emails.Put([]byte(emailHash), itob(user.ID)); err != nil {
+		return err
+	}
+	user.EmailHash = emailHash
+	return nil
+}
+
+// loadEntries fetches every entry of a vault, in creation order
+func loadEntries(tx *bolt.Tx, vaultID uint) ([]VaultEntry, error) {
+	entries := make([]VaultEntry, 0)
+	b := tx.Bucket(entriesBucket).Bucket(itob(vaultID))
+	if b == nil {
+		return entries, nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		entry := VaultEntry{}
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// findEntry fetches an entry by UUID, which must belong to the vault
+func findEntry(tx *bolt.Tx, vaultID uint, entryUUID string) (*VaultEntry, error) {
+	ids := tx.Bucket(entryUUIDsBucket).Get([]byte(entryUUID))
+	if ids == nil || uint(binary.BigEndian.Uint64(ids[:8])) != vaultID {
+		return nil, ErrEntryNotFound
+	}
+	b := tx.Bucket(entriesBucket).Bucket(ids[:8])
+	if b == nil {
+		return nil, ErrEntryNotFound
+	}
+	entry := &VaultEntry{}
+	found, err := getRecord(b, ids[8:], entry)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrEntryNotFound
+	}
+	return entry, nil
+}
+
+// putEntry stores an entry and indexes it by its UUID
+func putEntry(tx *bolt.Tx, entry *VaultEntry) error {
+	b, err := tx.Bucket(entriesBucket).CreateBucketIfNotExists(itob(entry.VaultID))
+	if err != nil {
+		return err
+	}
+	if err := putRecord(b, itob(entry.ID), entry); err != nil {
+		return err
+	}
+	return tx.Bucket(entryUUIDsBucket).Put([]byte(entry.UUID), append(itob(entry.VaultID), itob(entry.ID)...))
+}
+
+// checkBoltQuota refuses growing a vault by n entries past Quotas within tx
+func checkBoltQuota(tx *bolt.Tx, vaultID uint, n int) error {
+	if Quotas.MaxEntries == 0 || n <= 0 {
+		return nil
+	}
+	count := 0
+	if b := tx.Bucket(entriesBucket).Bucket(itob(vaultID)); b != nil {
+		count = b.Stats().KeyN
+	}
+	return Quotas.checkGrowth(count, n)
+}
+
+// applyBoltOperation applies op to the vault within tx
+func applyBoltOperation(tx *bolt.Tx, vaultID uint, op BatchOperation, schemaVersion uint) (*VaultEntry, error) {
+	switch op.Op {
+	case BatchCreate:
+		uuid, err := GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		id, err := tx.Bucket(entriesBucket).NextSequence()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		entry := &VaultEntry{
+			ID:             uint(id),
+			UUID:           uuid,
+			VaultID:        vaultID,
+			EncryptedEntry: op.EncryptedEntry,
+			SchemaVersion:  schemaVersion,
+		}
+		entry.CreatedAt, entry.UpdatedAt = now, now
+		if err := putEntry(tx, entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+
+	case BatchUpdate:
+		entry, err := findEntry(tx, vaultID, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry.EncryptedEntry = op.EncryptedEntry
+		entry.SchemaVersion = schemaVersion
+		entry.UpdatedAt = time.Now()
+		if err := putEntry(tx, entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+
+	case BatchDelete:
+		entry, err := findEntry(tx, vaultID, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Bucket(entriesBucket).Bucket(itob(vaultID)).Delete(itob(entry.ID)); err != nil {
+			return nil, err
+		}
+		return nil, tx.Bucket(entryUUIDsBucket).Delete([]byte(entry.UUID))
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownOperation, op.Op)
+}
+
+func (s *BoltStore) CreateUser(ctx context.Context, email string, authenticationHash []byte, kdf KDF) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := newUser(email, authenticationHash, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		// check if user with this email exists, under either hash
+		emails := tx.Bucket(emailsBucket)
+		if emails.Get([]byte(user.EmailHash)) != nil || emails.Get([]byte(legacyEmailHash(email))) != nil {
+			return ErrUserAlreadyExists
+		}
+
+		now := time.Now()
+		vaults := tx.Bucket(vaultsBucket)
+		vaultID, err := vaults.NextSequence()
+		if err != nil {
+			return err
+		}
+		user.Vault.ID = uint(vaultID)
+		user.Vault.CreatedAt, user.Vault.UpdatedAt = now, now
+		vault := user.Vault
+		vault.VaultEntries = nil
+		if err := putRecord(vaults, itob(vault.ID), &vault); err != nil {
+			return err
+		}
+
+		id, err := tx.Bucket(usersBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		user.ID = uint(id)
+		user.VaultID = user.Vault.ID
+		user.CreatedAt, user.UpdatedAt = now, now
+		if err := putUser(tx, user); err != nil {
+			return err
+		}
+		return emails.Put([]byte(user.EmailHash), itob(user.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// authenticate fetches the user of email after checking their AuthenticationHash
+func (s *BoltStore) authenticate(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var user *User
+	var legacy bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, legacy, err = loadUserByEmail(tx, email)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rehashed, err := checkAuthHash(user, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	// the email is only known to be theirs now, move them to the keyed hash
+	// unless another user is indexed under it, or there is no EmailKey yet
+	reindex := legacy && EmailKey != nil
+	if rehashed || reindex {
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			// only replace the hash and index, the user may have changed since
+			stored, err := loadUser(tx, user.ID)
+			if err != nil {
+				return err
+			}
+			if rehashed {
+				stored.AuthHashHash, stored.AuthHashPepper = user.AuthHashHash, user.AuthHashPepper
+			}
+			if reindex && stored.EmailHash == legacyEmailHash(email) {
+				err := reindexBoltUser(tx, stored, email)
+				if err != nil && !errors.Is(err, ErrEmailIndexConflict) {
+					return err
+				}
+				user.EmailHash = stored.EmailHash
+			}
+			return putUser(tx, stored)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *BoltStore) GetUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.authenticate(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.GetVault(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *BoltStore) GetVerifiedUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.authenticate(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVerified(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ReindexUsers re-indexes the users of emails still indexed under their
+// legacy hash like the function of the same name does in SQL databases
+func (s *BoltStore) ReindexUsers(ctx context.Context, emails []string) (reindexed int, conflicts []string, err error) {
+	if EmailKey == nil {
+		return 0, nil, ErrNoEmailKey
+	}
+	for _, email := range emails {
+		if err := ctx.Err(); err != nil {
+			return reindexed, conflicts, err
+		}
+
+		moved := false
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			id := tx.Bucket(emailsBucket).Get([]byte(legacyEmailHash(email)))
+			if id == nil {
+				return nil
+			}
+			user, err := loadUser(tx, uint(binary.BigEndian.Uint64(id)))
+			if err != nil {
+				return err
+			}
+			err = reindexBoltUser(tx, user, email)
+			if err != nil {
+				return err
+			}
+			moved = true
+			return putUser(tx, user)
+		})
+		if errors.Is(err, ErrEmailIndexConflict) {
+			conflicts = append(conflicts, email)
+			continue
+		}
+		if err != nil {
+			return reindexed, conflicts, err
+		}
+		if moved {
+			reindexed++
+		}
+	}
+	return reindexed, conflicts, nil
+}
+
+func (s *BoltStore) GetKDF(ctx context.Context, key []byte, email string) (*KDF, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var kdf KDF
+	err := s.db.View(func(tx *bolt.Tx) error {
+		user, _, err := loadUserByEmail(tx, email)
+		if err == ErrUserDoesNotExist {
+			kdf = fakeKDF(key, email)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		kdf = user.KDF
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kdf, nil
+}
+
+func (s *BoltStore) UpdateUserKDF(ctx context.Context, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return err
+	}
+	authHashHash, pepper, err := hashAuthHash(authenticationHash)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := loadUser(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		entries, err := loadEntries(tx, stored.VaultID)
+		if err != nil {
+			return err
+		}
+		uuids := make([]string, 0, len(entries))
+		for _, e := range entries {
+			uuids = append(uuids, e.UUID)
+		}
+		err = checkRekey(uuids, ops)
+		if err != nil {
+			return err
+		}
+
+		for _, op := range ops {
+			_, err := applyBoltOperation(tx, stored.VaultID, op, schemaVersion)
+			if err != nil {
+				return err
+			}
+		}
+
+		stored.KDF = kdf
+		stored.AuthHashHash, stored.AuthHashPepper = authHashHash, pepper
+		stored.UpdatedAt = time.Now()
+		return putUser(tx, stored)
+	})
+}
+
+func (s *BoltStore) CreateSession(ctx context.Context, user *User) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := newSession(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		id, err := sessions.NextSequence()
+		if err != nil {
+			return err
+		}
+		session.ID = uint(id)
+		session.CreatedAt, session.UpdatedAt = time.Now(), time.Now()
+		stored := *session
+		stored.Token = ""
+		return putRecord(sessions, []byte(stored.TokenHash), &stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *BoltStore) GetSessionUser(ctx context.Context, token string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var user *User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		session := Session{}
+		found, err := getRecord(tx.Bucket(sessionsBucket), []byte(StringToEncodedHash(token)), &session)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrSessionNotFound
+		}
+		if time.Now().After(session.ExpiresAt) {
+			return ErrSessionExpired
+		}
+		user, err = loadUser(tx, session.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *BoltStore) DeleteSession(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		tokenHash := []byte(StringToEncodedHash(token))
+		if sessions.Get(tokenHash) == nil {
+			return ErrSessionNotFound
+		}
+		return sessions.Delete(tokenHash)
+	})
+}
+
+func (s *BoltStore) DeleteUserSessions(ctx context.Context, user *User, keep string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keepHash := StringToEncodedHash(keep)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		// keys can't be deleted while iterating, collect them first
+		var ended [][]byte
+		err := sessions.ForEach(func(k, v []byte) error {
+			session := Session{}
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&session); err != nil {
+				return err
+			}
+			if session.UserID == user.ID && (keep == "" || string(k) != keepHash) {
+				ended = append(ended, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range ended {
+			if err := sessions.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetVault(ctx context.Context, user *User) (*Vault, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user.Vault.VaultEntries, err = loadEntries(tx, user.Vault.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user.Vault, nil
+}
+
+func (s *BoltStore) CheckSchemaVersion(ctx context.Context, user *User, supported uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var entries []VaultEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entries, err = loadEntries(tx, user.Vault.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.SchemaVersion > supported {
+			return ErrSchemaVersionTooNew
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) GetVaultEntry(ctx context.Context, user *User, entryUUID string) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var entry *VaultEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = findEntry(tx, user.Vault.ID, entryUUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltStore) CreateVaultEntry(ctx context.Context, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var entry *VaultEntry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := checkBoltQuota(tx, user.Vault.ID, 1); err != nil {
+			return err
+		}
+		var err error
+		entry, err = applyBoltOperation(tx, user.Vault.ID, BatchOperation{Op: BatchCreate, EncryptedEntry: encryptedEntry}, schemaVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltStore) UpdateVaultEntry(ctx context.Context, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var entry *VaultEntry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = applyBoltOperation(tx, user.Vault.ID, BatchOperation{Op: BatchUpdate, ID: entryUUID, EncryptedEntry: encryptedEntry}, schemaVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltStore) DeleteVaultEntry(ctx context.Context, user *User, entryUUID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := applyBoltOperation(tx, user.Vault.ID, BatchOperation{Op: BatchDelete, ID: entryUUID}, 0)
+		return err
+	})
+}
+
+func (s *BoltStore) ApplyVaultEntryBatch(ctx context.Context, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(ops) > MaxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(ops))
+	failed := -1
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := checkBoltQuota(tx, user.Vault.ID, growth(ops)); err != nil {
+			return err
+		}
+		for i, op := range ops {
+			entry, err := applyBoltOperation(tx, user.Vault.ID, op, schemaVersion)
+			if err != nil {
+				failed = i
+				results[i].Error = err.Error()
+				return err
+			}
+			results[i].Entry = entry
+		}
+		return nil
+	})
+	if err != nil && failed < 0 {
+		return nil, err
+	}
+	return batchResults(results, failed)
+}
+
+func (s *BoltStore) GetDataKey(ctx context.Context, vaultID uint) (*DataKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := &DataKey{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getRecord(tx.Bucket(dataKeysBucket), itob(vaultID), key)
+		if err == nil && !found {
+			err = ErrDataKeyNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *BoltStore) CreateDataKey(ctx context.Context, key *DataKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dataKeysBucket)
+		if b.Get(itob(key.VaultID)) != nil {
+			return fmt.Errorf("vault %d already has a data key", key.VaultID)
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key.ID = uint(id)
+		key.CreatedAt, key.UpdatedAt = time.Now(), time.Now()
+		return putRecord(b, itob(key.VaultID), key)
+	})
+}
+
+func (s *BoltStore) RewrapDataKeys(ctx context.Context, rewrap func(key *DataKey) (bool, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dataKeysBucket)
+		// collect the changes first, a bucket can't be written while iterated
+		changed := make(map[uint]*DataKey)
+		err := b.ForEach(func(k, v []byte) error {
+			key := &DataKey{}
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(key); err != nil {
+				return err
+			}
+			ok, err := rewrap(key)
+			if ok {
+				changed[key.VaultID] = key
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for vaultID, key := range changed {
+			key.UpdatedAt = time.Now()
+			if err := putRecord(b, itob(vaultID), key); err != nil {
+				return err
+			}
+		}
+		rewrapped = len(changed)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rewrapped, nil
+}
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"gopkg.in/yaml.v3"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var ErrUnsupportedOption = errors.New("option not supported by this driver")
+
+// mysqlTLSConfig is the name the TLS configuration of mysql connections is
+// registered under
+const mysqlTLSConfig = "gopass-server"
+
+// a Config selects the database a Store is opened on and tunes its connections
+type Config struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+
+	// connection pool limits, zero leaves the database/sql default
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// StatementTimeout aborts statements running longer, on mysql it only
+	// applies to SELECTs
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+
+	TLS TLSConfig `yaml:"tls"`
+
+	// Replicas are the DSNs of read replicas of a postgres primary, opened
+	// with the same options. vault reads go to healthy replicas
+	Replicas []string `yaml:"replicas"`
+	// ReplicaMaxLag and ReplicaCheckInterval tune the routing, see ReplicaOptions
+	ReplicaMaxLag        time.Duration `yaml:"replica_max_lag"`
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval"`
+}
+
+// a TLSConfig secures the connections to postgres and mysql
+type TLSConfig struct {
+	// Enabled requires TLS, overriding any sslmode or tls option of the DSN
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the server against the given PEM certificates
+	// instead of the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile authenticate the client with a certificate
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+}
+
+// DefaultConfig is the sqlite database in the working directory
+func DefaultConfig() Config {
+	return Config{Driver: "sqlite", DSN: "test.db"}
+}
+
+// LoadConfigFile reads the YAML config file at path over c
+func (c *Config) LoadConfigFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(b, c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// check rejects options the driver can't honour
+func (c Config) check() error {
+	switch c.Driver {
+	case "postgres", "mysql":
+		return nil
+	case "sqlite", BoltDriver:
+		if c.StatementTimeout != 0 {
+			return fmt.Errorf("%w: %s statement timeout", ErrUnsupportedOption, c.Driver)
+		}
+		if c.TLS.Enabled {
+			return fmt.Errorf("%w: %s TLS", ErrUnsupportedOption, c.Driver)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported database driver %q, expected one of %v", c.Driver, StoreDrivers)
+}
+
+// checkReplicas rejects replicas on databases they aren't routed on
+func (c Config) checkReplicas() error {
+	if len(c.Replicas) != 0 && c.Driver != "postgres" {
+		return fmt.Errorf("%w: %s replicas", ErrUnsupportedOption, c.Driver)
+	}
+	return nil
+}
+
+// tlsConfig loads the certificates of the TLS configuration
+func (t TLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: t.ServerName, MinVersion: tls.VersionTLS12}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s holds no PEM certificates", t.CAFile)
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dialector builds the GORM dialector of the configured SQL database
+func (c Config) dialector() (gorm.Dialector, error) {
+	switch c.Driver {
+	case "sqlite":
+		return sqlite.Open(c.DSN), nil
+
+	case "postgres":
+		if c.StatementTimeout == 0 && !c.TLS.Enabled {
+			return postgres.Open(c.DSN), nil
+		}
+		pgConfig, err := pgx.ParseConfig(c.DSN)
+		if err != nil {
+			return nil, err
+		}
+		if c.StatementTimeout != 0 {
+			pgConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+		}
+		if c.TLS.Enabled {
+			pgConfig.TLSConfig, err = c.TLS.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			if pgConfig.TLSConfig.ServerName == "" {
+				pgConfig.TLSConfig.ServerName = pgConfig.Host
+			}
+			// never fall back to an unencrypted connection
+			pgConfig.Fallbacks = nil
+		}
+		return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*pgConfig)}), nil
+
+	case "mysql":
+		myConfig, err := gomysql.ParseDSN(c.DSN)
+		if err != nil {
+			return nil, err
+		}
+		if c.StatementTimeout != 0 {
+			if myConfig.Params == nil {
+				myConfig.Params = make(map[string]string)
+			}
+			myConfig.Params["max_execution_time"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+		}
+		if c.TLS.Enabled {
+			tlsConfig, err := c.TLS.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = myConfig.Addr
+				if host, _, err := net.SplitHostPort(myConfig.Addr); err == nil {
+					tlsConfig.ServerName = host
+				}
+			}
+			if err := gomysql.RegisterTLSConfig(mysqlTLSConfig, tlsConfig); err != nil {
+				return nil, err
+			}
+			myConfig.TLSConfig = mysqlTLSConfig
+		}
+		// strings need a size to be indexed on mysql
+		return mysql.New(mysql.Config{DSN: myConfig.FormatDSN(), DefaultStringSize: 191}), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q, expected one of %v", c.Driver, Drivers)
+}
+
+// Open connects to the configured SQL database
+func (c Config) Open(config *gorm.Config) (*gorm.DB, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	dialector, err := c.dialector()
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxOpenConns != 0 {
+		sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns != 0 {
+		sqlDB.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime != 0 {
+		sqlDB.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime != 0 {
+		sqlDB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+	return db, nil
+}
+
+// OpenStore opens the Store of the configured database, SQL databases are
+// migrated first. replicas are not migrated, they follow the primary
+func (c Config) OpenStore(config *gorm.Config) (Store, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	if err := c.checkReplicas(); err != nil {
+		return nil, err
+	}
+	if c.Driver == BoltDriver {
+		return OpenBoltStore(c.DSN)
+	}
+	db, err := c.Open(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+	if len(c.Replicas) == 0 {
+		return NewGormStore(db), nil
+	}
+
+	replicas := make([]*gorm.DB, len(c.Replicas))
+	for i, dsn := range c.Replicas {
+		replicaConfig := c
+		replicaConfig.DSN = dsn
+		replicas[i], err = replicaConfig.Open(config)
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+	}
+	return NewReplicatedGormStore(db, replicas, ReplicaOptions{
+		MaxLag:        c.ReplicaMaxLag,
+		CheckInterval: c.ReplicaCheckInterval,
+	}), nil
+}
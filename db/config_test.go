@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_LoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+driver: postgres
+dsn: host=db.example.com dbname=gopass
+max_open_conns: 20
+conn_max_lifetime: 30m
+statement_timeout: 5s
+tls:
+  enabled: true
+  ca_file: /etc/gopass-server/db-ca.pem
+replicas:
+  - host=replica1.example.com dbname=gopass
+replica_max_lag: 2s
+`), 0600))
+
+	c := db.DefaultConfig()
+	require.NoError(t, c.LoadConfigFile(path))
+	require.Equal(t, db.Config{
+		Driver:           "postgres",
+		DSN:              "host=db.example.com dbname=gopass",
+		MaxOpenConns:     20,
+		ConnMaxLifetime:  30 * time.Minute,
+		StatementTimeout: 5 * time.Second,
+		TLS:              db.TLSConfig{Enabled: true, CAFile: "/etc/gopass-server/db-ca.pem"},
+		Replicas:         []string{"host=replica1.example.com dbname=gopass"},
+		ReplicaMaxLag:    2 * time.Second,
+	}, c)
+}
+
+func Test_ConfigOpen(t *testing.T) {
+	c := db.Config{
+		Driver:       "sqlite",
+		DSN:          "file:" + t.Name() + "?mode=memory&cache=shared",
+		MaxOpenConns: 3,
+	}
+	gdb, err := c.Open(&gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	require.Equal(t, 3, sqlDB.Stats().MaxOpenConnections)
+
+	c.StatementTimeout = time.Second
+	_, err = c.Open(&gorm.Config{})
+	require.ErrorIs(t, err, db.ErrUnsupportedOption)
+
+	_, err = db.Config{Driver: "sqlite", DSN: c.DSN, Replicas: []string{c.DSN}}.OpenStore(&gorm.Config{})
+	require.ErrorIs(t, err, db.ErrUnsupportedOption)
+
+	_, err = db.Config{Driver: "oracle"}.OpenStore(&gorm.Config{})
+	require.Error(t, err)
+}
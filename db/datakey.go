@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrDataKeyNotFound = errors.New("data key not found")
+
+// a DataKey encrypts the entries of a vault at rest, it is only ever stored
+// wrapped by the master key MasterKeyID of an EnvelopeStore's KeyProvider
+type DataKey struct {
+	gorm.Model
+	VaultID     uint `gorm:"uniqueIndex"`
+	MasterKeyID string
+	WrappedKey  []byte
+}
+
+// a DataKeyStore is a Store that also keeps the data keys of an EnvelopeStore
+type DataKeyStore interface {
+	Store
+	// GetDataKey fails with ErrDataKeyNotFound when the vault has no data key yet
+	GetDataKey(ctx context.Context, vaultID uint) (*DataKey, error)
+	// CreateDataKey fails when the vault already has a data key
+	CreateDataKey(ctx context.Context, key *DataKey) error
+	// RewrapDataKeys calls rewrap on every data key and stores those it
+	// changed, all at once or not at all, returning how many changed
+	RewrapDataKeys(ctx context.Context, rewrap func(key *DataKey) (bool, error)) (int, error)
+}
+
+// GetDataKey fetches the data key of a vault
+func GetDataKey(ctx context.Context, db *gorm.DB, vaultID uint) (*DataKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := DataKey{}
+	result := db.WithContext(ctx).Where("vault_id = ?", vaultID).Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrDataKeyNotFound
+	}
+	return &key, nil
+}
+
+// CreateDataKey stores the first data key of a vault
+func CreateDataKey(ctx context.Context, db *gorm.DB, key *DataKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(key).Error
+}
+
+// RewrapDataKeys rewraps every data key in one transaction, in batches
+func RewrapDataKeys(ctx context.Context, db *gorm.DB, rewrap func(key *DataKey) (bool, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var keys []DataKey
+		result := tx.Order("id").FindInBatches(&keys, 500, func(batch *gorm.DB, _ int) error {
+			for i := range keys {
+				changed, err := rewrap(&keys[i])
+				if err != nil {
+					return err
+				}
+				if !changed {
+					continue
+				}
+				err = tx.Model(&keys[i]).Updates(map[string]interface{}{
+					"master_key_id": keys[i].MasterKeyID,
+					"wrapped_key":   keys[i].WrappedKey,
+				}).Error
+				if err != nil {
+					return err
+				}
+				rewrapped++
+			}
+			return nil
+		})
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rewrapped, nil
+}
+
+func (s *GormStore) GetDataKey(ctx context.Context, vaultID uint) (*DataKey, error) {
+	return GetDataKey(ctx, s.db, vaultID)
+}
+
+func (s *GormStore) CreateDataKey(ctx context.Context, key *DataKey) error {
+	return CreateDataKey(ctx, s.db, key)
+}
+
+func (s *GormStore) RewrapDataKeys(ctx context.Context, rewrap func(key *DataKey) (bool, error)) (int, error) {
+	return RewrapDataKeys(ctx, s.db, rewrap)
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrEmailIndexConflict = errors.New("another user is already indexed under this email")
+var ErrNoEmailKey = errors.New("no email key set, users can't be re-indexed without one")
+
+// EmailKey is the server secret users are indexed by their email with, so
+// that the database alone can't tell whether an email has an account. the
+// server refuses to run without one, while it is nil users indexed under
+// their legacy hash stay there
+var EmailKey []byte
+
+// a ReindexStore is a Store whose users may still be indexed under their
+// legacy hash, e.g. because they were created before EmailKey was set
+type ReindexStore interface {
+	Store
+	// ReindexUsers moves the users of emails still indexed under their legacy
+	// hash to EmailHash, emails another user is indexed under are conflicts
+	ReindexUsers(ctx context.Context, emails []string) (reindexed int, conflicts []string, err error)
+}
+
+// NormalizeEmail trims and case folds an email, so that it is indexed the same
+// however it is typed
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailHash returns the hash users with the given email are indexed under
+func EmailHash(email string) string {
+	mac := hmac.New(sha256.New, EmailKey)
+	mac.Write([]byte(NormalizeEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// legacyEmailHash returns the unkeyed hash of the email exactly as typed,
+// which users created before EmailHash are indexed under until re-indexed
+func legacyEmailHash(email string) string {
+	return StringToEncodedHash(email)
+}
+
+// findUserByEmail fetches the user indexed under email, legacy reports whether
+// they are still indexed under their legacy hash
+// the returned user has no ID when there is none
+func findUserByEmail(db *gorm.DB, email string) (user *User, legacy bool, err error) {
+	emailHash := EmailHash(email)
+	var users []User
+	result := db.Where("email_hash IN ?", []string{emailHash, legacyEmailHash(email)}).Find(&users)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	user = &User{}
+	for i := range users {
+		if users[i].EmailHash == emailHash {
+			return &users[i], false, nil
+		}
+		user = &users[i]
+	}
+	return user, user.ID != 0, nil
+}
+
+// reindexUser moves a user from their legacy hash to the keyed EmailHash
+func reindexUser(db *gorm.DB, user *User, email string) error {
+	emailHash := EmailHash(email)
+
+	// accounts registered as A@example.com and a@example.com normalize to the same email
+	var count int64
+	result := db.Model(&User{}).Where("email_hash = ?", emailHash).Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return ErrEmailIndexConflict
+	}
+
+	result = db.Model(user).Update("email_hash", emailHash)
+	if result.Error != nil {
+		return result.Error
+	}
+	user.EmailHash = emailHash
+	return nil
+}
+
+// ReindexUsers re-indexes the users of the given emails that are still indexed
+// under their legacy hash, users are otherwise only re-indexed when they log in
+// emails already indexed under another user are returned as conflicts
+func ReindexUsers(ctx context.Context, db *gorm.DB, emails []string) (reindexed int, conflicts []string, err error) {
+	if EmailKey == nil {
+		return 0, nil, ErrNoEmailKey
+	}
+	for _, email := range emails {
+		if err := ctx.Err(); err != nil {
+			return reindexed, conflicts, err
+		}
+
+		user := User{}
+		result := db.WithContext(ctx).Where("email_hash = ?", legacyEmailHash(email)).Limit(1).Find(&user)
+		if result.Error != nil {
+			return reindexed, conflicts, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		err := reindexUser(db.WithContext(ctx), &user, email)
+		if errors.Is(err, ErrEmailIndexConflict) {
+			conflicts = append(conflicts, email)
+			continue
+		}
+		if err != nil {
+			return reindexed, conflicts, err
+		}
+		reindexed++
+	}
+	return reindexed, conflicts, nil
+}
+
+func (s *GormStore) ReindexUsers(ctx context.Context, emails []string) (int, []string, error) {
+	return ReindexUsers(ctx, s.db, emails)
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rokusei/gopass-server/db/envelope"
+)
+
+// EnvelopeStore encrypts the EncryptedEntry of vault entries once more at
+// rest, under a data key per vault which is stored wrapped by the master key
+// of a KeyProvider. entries stored before it was enabled are read as they are
+// and encrypted when next written
+type EnvelopeStore struct {
+	store    DataKeyStore
+	provider envelope.KeyProvider
+
+	mu sync.Mutex
+	// unwrapped data keys by vault ID, rewrapping doesn't change them
+	dataKeys map[uint][]byte
+}
+
+// NewEnvelopeStore encrypts the entries of store with data keys wrapped by provider
+func NewEnvelopeStore(store DataKeyStore, provider envelope.KeyProvider) *EnvelopeStore {
+	return &EnvelopeStore{store: store, provider: provider, dataKeys: make(map[uint][]byte)}
+}
+
+// dataKey returns the data key of a vault, generating it when create is set
+// and the vault has none yet
+func (s *EnvelopeStore) dataKey(ctx context.Context, vaultID uint, create bool) ([]byte, error) {
+	s.mu.Lock()
+	key, ok := s.dataKeys[vaultID]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	stored, err := s.store.GetDataKey(ctx, vaultID)
+	if errors.Is(err, ErrDataKeyNotFound) && create {
+		stored, err = s.createDataKey(ctx, vaultID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err = s.provider.UnwrapKey(ctx, stored.MasterKeyID, stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("data key of vault %d: %w", vaultID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dataKeys[vaultID] = key
+	return key, nil
+}
+
+func (s *EnvelopeStore) createDataKey(ctx context.Context, vaultID uint) (*DataKey, error) {
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := s.provider.WrapKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	stored := &DataKey{VaultID: vaultID, MasterKeyID: s.provider.KeyID(), WrappedKey: wrapped}
+	if err := s.store.CreateDataKey(ctx, stored); err != nil {
+		// another request may have just created it
+		if existing, getErr := s.store.GetDataKey(ctx, vaultID); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return stored, nil
+}
+
+// vaultAAD binds sealed entries to their vault
+func vaultAAD(vaultID uint) []byte {
+	return []byte(fmt.Sprintf("vault %d", vaultID))
+}
+
+func (s *EnvelopeStore) seal(ctx context.Context, user *User, encryptedEntry []byte) ([]byte, error) {
+	key, err := s.dataKey(ctx, user.Vault.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.Seal(key, encryptedEntry, vaultAAD(user.Vault.ID))
+}
+
+// sealOps returns copies of ops with their entries sealed
+func (s *EnvelopeStore) sealOps(ctx context.Context, user *User, ops []BatchOperation) ([]BatchOperation, error) {
+	sealed := make([]BatchOperation, len(ops))
+	for i, op := range ops {
+		sealed[i] = op
+		if op.Op == BatchCreate || op.Op == BatchUpdate {
+			var err error
+			sealed[i].EncryptedEntry, err = s.seal(ctx, user, op.EncryptedEntry)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sealed, nil
+}
+
+// open returns a copy of entry with its EncryptedEntry as the client wrote it
+func (s *EnvelopeStore) open(ctx context.Context, entry VaultEntry) (VaultEntry, error) {
+	if !envelope.IsSealed(entry.EncryptedEntry) {
+		return entry, nil
+	}
+	key, err := s.dataKey(ctx, entry.VaultID, false)
+	if err != nil {
+		return entry, err
+	}
+	entry.EncryptedEntry, err = envelope.Open(key, entry.EncryptedEntry, vaultAAD(entry.VaultID))
+	if err != nil {
+		return entry, fmt.Errorf("entry %s: %w", entry.UUID, err)
+	}
+	return entry, nil
+}
+
+// openVault opens the entries of a vault in place
+func (s *EnvelopeStore) openVault(ctx context.Context, vault *Vault) error {
+	if len(vault.VaultEntries) == 0 {
+		return nil
+	}
+	entries := make([]VaultEntry, len(vault.VaultEntries))
+	for i, e := range vault.VaultEntries {
+		var err error
+		entries[i], err = s.open(ctx, e)
+		if err != nil {
+			return err
+		}
+	}
+	vault.VaultEntries = entries
+	return nil
+}
+
+// openUser opens the entries loaded along with a user
+func (s *EnvelopeStore) openUser(ctx context.Context, user *User) (*User, error) {
+	if err := s.openVault(ctx, &user.Vault); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// unseal replaces the sealed EncryptedEntry of a stored entry with the one
+// the client sent, without decrypting it again
+func unseal(entry *VaultEntry, encryptedEntry []byte) *VaultEntry {
+	e := *entry
+	e.EncryptedEntry = encryptedEntry
+	return &e
+}
+
+// RewrapDataKeys wraps every data key not wrapped with the current master key
+// of the provider with it, the provider must still hold the older master keys
+func (s *EnvelopeStore) RewrapDataKeys(ctx context.Context) (int, error) {
+	current := s.provider.KeyID()
+	return s.store.RewrapDataKeys(ctx, func(key *DataKey) (bool, error) {
+		if key.MasterKeyID == current {
+			return false, nil
+		}
+		dataKey, err := s.provider.UnwrapKey(ctx, key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return false, fmt.Errorf("data key of vault %d: %w", key.VaultID, err)
+		}
+		key.WrappedKey, err = s.provider.WrapKey(ctx, dataKey)
+		if err != nil {
+			return false, err
+		}
+		key.MasterKeyID = current
+		return true, nil
+	})
+}
+
+func (s *EnvelopeStore) CreateUser(ctx context.Context, email string, authenticationHash []byte, kdf KDF) (*User, error) {
+	return s.store.CreateUser(ctx, email, authenticationHash, kdf)
+}
+
+func (s *EnvelopeStore) GetUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.store.GetUser(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.openUser(ctx, user)
+}
+
+func (s *EnvelopeStore) GetVerifiedUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.store.GetVerifiedUser(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.openUser(ctx, user)
+}
+
+func (s *EnvelopeStore) GetKDF(ctx context.Context, key []byte, email string) (*KDF, error) {
+	return s.store.GetKDF(ctx, key, email)
+}
+
+func (s *EnvelopeStore) UpdateUserKDF(ctx context.Context, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error {
+	sealed, err := s.sealOps(ctx, user, ops)
+	if err != nil {
+		return err
+	}
+	return s.store.UpdateUserKDF(ctx, user, kdf, authenticationHash, sealed, schemaVersion)
+}
+
+func (s *EnvelopeStore) CreateSession(ctx context.Context, user *User) (*Session, error) {
+	return s.store.CreateSession(ctx, user)
+}
+
+func (s *EnvelopeStore) GetSessionUser(ctx context.Context, token string) (*User, error) {
+	user, err := s.store.GetSessionUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.openUser(ctx, user)
+}
+
+func (s *EnvelopeStore) DeleteSession(ctx context.Context, token string) error {
+	return s.store.DeleteSession(ctx, token)
+}
+
+func (s *EnvelopeStore) DeleteUserSessions(ctx context.Context, user *User, keep string) error {
+	return s.store.DeleteUserSessions(ctx, user, keep)
+}
+
+func (s *EnvelopeStore) GetVault(ctx context.Context, user *User) (*Vault, error) {
+	vault, err := s.store.GetVault(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openVault(ctx, vault); err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
+
+func (s *EnvelopeStore) CheckSchemaVersion(ctx context.Context, user *User, supported uint) error {
+	return s.store.CheckSchemaVersion(ctx, user, supported)
+}
+
+func (s *EnvelopeStore) GetVaultEntry(ctx context.Context, user *User, entryUUID string) (*VaultEntry, error) {
+	entry, err := s.store.GetVaultEntry(ctx, user, entryUUID)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := s.open(ctx, *entry)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+func (s *EnvelopeStore) CreateVaultEntry(ctx context.Context, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	sealed, err := s.seal(ctx, user, encryptedEntry)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.store.CreateVaultEntry(ctx, user, sealed, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(entry, encryptedEntry), nil
+}
+
+func (s *EnvelopeStore) UpdateVaultEntry(ctx context.Context, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	sealed, err := s.seal(ctx, user, encryptedEntry)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.store.UpdateVaultEntry(ctx, user, entryUUID, sealed, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(entry, encryptedEntry), nil
+}
+
+func (s *EnvelopeStore) DeleteVaultEntry(ctx context.Context, user *User, entryUUID string) error {
+	return s.store.DeleteVaultEntry(ctx, user, entryUUID)
+}
+
+func (s *EnvelopeStore) ApplyVaultEntryBatch(ctx context.Context, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error) {
+	sealed, err := s.sealOps(ctx, user, ops)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.store.ApplyVaultEntryBatch(ctx, user, sealed, schemaVersion)
+	for i := range results {
+		if results[i].Entry != nil {
+			results[i].Entry = unseal(results[i].Entry, ops[i].EncryptedEntry)
+		}
+	}
+	return results, err
+}
@@ -0,0 +1,100 @@
+// Package envelope encrypts blobs at rest with data keys, which are stored
+// wrapped by a master key held by a KeyProvider
+//
+// Sealed blobs are laid out as
+//
+//	"gse" <version byte> <12 byte nonce> <AES-256-GCM ciphertext>
+//
+// so that they can be told apart from blobs stored before encryption was
+// enabled. rotating the master key only rewraps the data keys, sealed blobs
+// stay as they are
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownKey = errors.New("unknown master key")
+var ErrInvalidBlob = errors.New("invalid sealed blob")
+
+// DataKeySize is the size of data keys and of the master keys of a KeyFile
+const DataKeySize = 32
+
+// the prefix of sealed blobs, the last byte is the format version
+var magic = []byte("gse\x01")
+
+// a KeyProvider wraps data keys with a master key it never reveals
+type KeyProvider interface {
+	// KeyID names the master key new data keys are wrapped with
+	KeyID() string
+	// WrapKey wraps a data key with the KeyID master key
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey unwraps a data key wrapped with the master key keyID, which
+	// may be older than the current one
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// NewDataKey generates a random data key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// IsSealed reports whether blob was sealed, rather than stored as is
+func IsSealed(blob []byte) bool {
+	return bytes.HasPrefix(blob, magic)
+}
+
+// Seal encrypts plaintext with a data key, binding it to aad which must be
+// given again to Open it
+func Seal(dataKey, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, len(magic)+aead.NonceSize(), len(magic)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(blob, magic)
+	nonce := blob[len(magic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(blob, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a blob sealed with the data key and aad
+func Open(dataKey, blob, aad []byte) ([]byte, error) {
+	if !IsSealed(blob) {
+		return nil, ErrInvalidBlob
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	blob = blob[len(magic):]
+	if len(blob) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidBlob
+	}
+	plaintext, err := aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBlob, err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("key is %d bytes, expected %d", len(key), DataKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package envelope_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db/envelope"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SealOpen(t *testing.T) {
+	key, err := envelope.NewDataKey()
+	require.NoError(t, err)
+	blob, err := envelope.Seal(key, []byte("secret"), []byte("aad"))
+	require.NoError(t, err)
+	require.True(t, envelope.IsSealed(blob))
+
+	plaintext, err := envelope.Open(key, blob, []byte("aad"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), plaintext)
+
+	_, err = envelope.Open(key, blob, []byte("other aad"))
+	require.ErrorIs(t, err, envelope.ErrInvalidBlob)
+	_, err = envelope.Open(key, []byte("secret"), []byte("aad"))
+	require.ErrorIs(t, err, envelope.ErrInvalidBlob)
+}
+
+func Test_ParseKeyFile(t *testing.T) {
+	k, err := envelope.ParseKeyFile(`
+# newest first
+two AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+one AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=
+`)
+	require.NoError(t, err)
+	require.Equal(t, "two", k.KeyID())
+	require.Len(t, k.Keys, 2)
+
+	for _, s := range []string{"", "one", "one AAAA", "one !", "one AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=,one AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="} {
+		_, err := envelope.ParseKeyFile(s)
+		require.Error(t, err, s)
+	}
+}
+
+// token is a PKCS#11 token keeping its keys in a KeyFile
+type token struct {
+	keys *envelope.KeyFile
+}
+
+func (t token) WrapKey(label string, key []byte) ([]byte, error) {
+	return envelope.Seal(t.keys.Keys[label], key, nil)
+}
+
+func (t token) UnwrapKey(label string, wrapped []byte) ([]byte, error) {
+	key, ok := t.keys.Keys[label]
+	if !ok {
+		return nil, fmt.Errorf("no key labelled %s", label)
+	}
+	return envelope.Open(key, wrapped, nil)
+}
+
+func Test_Providers(t *testing.T) {
+	ctx := context.Background()
+	keys, err := envelope.ParseKeyFile("one AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+	require.NoError(t, err)
+	kms := envelope.NewLocalKMS()
+
+	for name, p := range map[string]envelope.KeyProvider{
+		"KeyFile": keys,
+		"PKCS11":  &envelope.PKCS11{Token: token{keys}, Label: "one"},
+		"KMS":     &envelope.KMS{Client: kms, Key: "one"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dataKey, err := envelope.NewDataKey()
+			require.NoError(t, err)
+			wrapped, err := p.WrapKey(ctx, dataKey)
+			require.NoError(t, err)
+			require.NotEqual(t, dataKey, wrapped)
+
+			unwrapped, err := p.UnwrapKey(ctx, p.KeyID(), wrapped)
+			require.NoError(t, err)
+			require.Equal(t, dataKey, unwrapped)
+			_, err = p.UnwrapKey(ctx, "unknown", wrapped)
+			require.Error(t, err)
+		})
+	}
+
+	kms.Disable("one")
+	_, err = (&envelope.KMS{Client: kms, Key: "one"}).WrapKey(ctx, make([]byte, envelope.DataKeySize))
+	require.Error(t, err)
+}
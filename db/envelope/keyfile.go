@@ -0,0 +1,68 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// a KeyFile holds the master keys locally, data keys are wrapped with
+// AES-256-GCM under them
+type KeyFile struct {
+	// Current is the ID of the key new data keys are wrapped with
+	Current string
+	Keys    map[string][]byte
+}
+
+// ParseKeyFile reads master keys, one "<id> <base64 key>" pair per line or
+// comma separated. the first key is Current, to rotate the master key add a
+// new key at the top, rewrap the data keys and then drop the old ones
+func ParseKeyFile(s string) (*KeyFile, error) {
+	k := &KeyFile{Keys: make(map[string][]byte)}
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid master key %q, expected <id> <base64 key>", line)
+		}
+		id := fields[0]
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+		if len(key) != DataKeySize {
+			return nil, fmt.Errorf("master key %s is %d bytes, expected %d", id, len(key), DataKeySize)
+		}
+		if _, ok := k.Keys[id]; ok {
+			return nil, fmt.Errorf("master key %s is listed twice", id)
+		}
+		if k.Current == "" {
+			k.Current = id
+		}
+		k.Keys[id] = key
+	}
+	if k.Current == "" {
+		return nil, fmt.Errorf("no master key")
+	}
+	return k, nil
+}
+
+func (k *KeyFile) KeyID() string {
+	return k.Current
+}
+
+func (k *KeyFile) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return Seal(k.Keys[k.Current], dataKey, []byte(k.Current))
+}
+
+func (k *KeyFile) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return Open(key, wrapped, []byte(keyID))
+}
@@ -0,0 +1,91 @@
+package envelope
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// a KMSClient encrypts small secrets under keys held by a key management
+// service, as the Encrypt and Decrypt calls of cloud KMSes do
+type KMSClient interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// KMS keeps the master keys in a key management service
+type KMS struct {
+	Client KMSClient
+	// Key is the KMS key new data keys are wrapped with
+	Key string
+}
+
+func (k *KMS) KeyID() string {
+	return k.Key
+}
+
+func (k *KMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return k.Client.Encrypt(ctx, k.Key, dataKey)
+}
+
+func (k *KMS) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	return k.Client.Decrypt(ctx, keyID, wrapped)
+}
+
+// LocalKMS is an in-process stand-in for a KMS, for tests and development
+// its keys are generated on first use and lost with it
+type LocalKMS struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+	// disabled keys refuse every request, like a key pending deletion
+	disabled map[string]bool
+}
+
+// NewLocalKMS returns a LocalKMS without any keys
+func NewLocalKMS() *LocalKMS {
+	return &LocalKMS{keys: make(map[string][]byte), disabled: make(map[string]bool)}
+}
+
+// Disable makes every later request for keyID fail
+func (k *LocalKMS) Disable(keyID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.disabled[keyID] = true
+}
+
+func (k *LocalKMS) key(keyID string, create bool) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.disabled[keyID] {
+		return nil, fmt.Errorf("kms key %q is disabled", keyID)
+	}
+	key, ok := k.keys[keyID]
+	if !ok && !create {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	if !ok {
+		var err error
+		key, err = NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		k.keys[keyID] = key
+	}
+	return key, nil
+}
+
+func (k *LocalKMS) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	key, err := k.key(keyID, true)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(key, plaintext, []byte(keyID))
+}
+
+func (k *LocalKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	key, err := k.key(keyID, false)
+	if err != nil {
+		return nil, err
+	}
+	return Open(key, ciphertext, []byte(keyID))
+}
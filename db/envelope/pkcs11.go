@@ -0,0 +1,31 @@
+package envelope
+
+import "context"
+
+// a Token is the part of a PKCS#11 token, usually an HSM, the PKCS11
+// provider needs. it mirrors C_WrapKey and C_UnwrapKey with a secret key
+// object found by its CKA_LABEL, typically using CKM_AES_KEY_WRAP_PAD, so
+// that an adapter over a PKCS#11 library is a thin layer
+type Token interface {
+	WrapKey(label string, key []byte) ([]byte, error)
+	UnwrapKey(label string, wrapped []byte) ([]byte, error)
+}
+
+// PKCS11 keeps the master keys on a PKCS#11 token, named by their labels
+type PKCS11 struct {
+	Token Token
+	// Label is the label of the key new data keys are wrapped with
+	Label string
+}
+
+func (p *PKCS11) KeyID() string {
+	return p.Label
+}
+
+func (p *PKCS11) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return p.Token.WrapKey(p.Label, dataKey)
+}
+
+func (p *PKCS11) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	return p.Token.UnwrapKey(keyID, wrapped)
+}
@@ -0,0 +1,87 @@
+package db_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/rokusei/gopass-server/db/envelope"
+	"github.com/rokusei/gopass-server/db/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+// keyFile returns a KeyFile of the given key IDs, the first one current
+func keyFile(t testing.TB, ids ...string) *envelope.KeyFile {
+	s := ""
+	for _, id := range ids {
+		key := make([]byte, envelope.DataKeySize)
+		copy(key, id)
+		s += fmt.Sprintf("%s %s\n", id, base64.StdEncoding.EncodeToString(key))
+	}
+	k, err := envelope.ParseKeyFile(s)
+	require.NoError(t, err)
+	return k
+}
+
+func Test_EnvelopeStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return db.NewEnvelopeStore(db.NewMemoryStore(), keyFile(t, "one"))
+	})
+}
+
+func Test_EnvelopeStoreAtRest(t *testing.T) {
+	ctx := context.Background()
+	gdb := openMemoryDB(t, t.Name())
+	inner := db.NewGormStore(gdb)
+	s := db.NewEnvelopeStore(inner, keyFile(t, "one"))
+
+	user, err := s.CreateUser(ctx, "a@example.com", make([]byte, db.AuthHashSize), db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(t, err)
+	// entries written before encryption was enabled are read as they are
+	legacy, err := inner.CreateVaultEntry(ctx, user, []byte("legacy"), 1)
+	require.NoError(t, err)
+	entry, err := s.CreateVaultEntry(ctx, user, []byte("client ciphertext"), 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("client ciphertext"), entry.EncryptedEntry)
+
+	stored := db.VaultEntry{}
+	require.NoError(t, gdb.Where("uuid = ?", entry.UUID).First(&stored).Error)
+	require.True(t, envelope.IsSealed(stored.EncryptedEntry))
+	require.NotContains(t, string(stored.EncryptedEntry), "client ciphertext")
+
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, vault.VaultEntries, 2)
+	require.Equal(t, []byte("legacy"), vault.VaultEntries[0].EncryptedEntry)
+	require.Equal(t, []byte("client ciphertext"), vault.VaultEntries[1].EncryptedEntry)
+	got, err := s.GetVaultEntry(ctx, user, legacy.UUID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy"), got.EncryptedEntry)
+
+	// sealed entries can't be moved to another vault
+	other, err := s.CreateUser(ctx, "b@example.com", make([]byte, db.AuthHashSize), db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(t, err)
+	_, err = s.CreateVaultEntry(ctx, other, []byte("other"), 1)
+	require.NoError(t, err)
+	require.NoError(t, gdb.Model(&db.VaultEntry{}).Where("uuid = ?", entry.UUID).Update("vault_id", other.Vault.ID).Error)
+	_, err = s.GetVaultEntry(ctx, other, entry.UUID)
+	require.ErrorIs(t, err, envelope.ErrInvalidBlob)
+	require.NoError(t, gdb.Model(&db.VaultEntry{}).Where("uuid = ?", entry.UUID).Update("vault_id", user.Vault.ID).Error)
+
+	// rotation rewraps the data keys and leaves the entries alone
+	rotated := db.NewEnvelopeStore(inner, keyFile(t, "two", "one"))
+	n, err := rotated.RewrapDataKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	rotatedEntry := db.VaultEntry{}
+	require.NoError(t, gdb.Where("uuid = ?", entry.UUID).First(&rotatedEntry).Error)
+	require.Equal(t, stored.EncryptedEntry, rotatedEntry.EncryptedEntry)
+
+	got, err = db.NewEnvelopeStore(inner, keyFile(t, "two")).GetVaultEntry(ctx, user, entry.UUID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("client ciphertext"), got.EncryptedEntry)
+	_, err = db.NewEnvelopeStore(inner, keyFile(t, "one")).GetVaultEntry(ctx, user, entry.UUID)
+	require.ErrorIs(t, err, envelope.ErrUnknownKey)
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidKDF = errors.New("invalid KDF parameters")
+var ErrIncompleteRekey = errors.New("every vault entry must be re-encrypted when the key changes")
+
+// key derivation functions clients derive their EncryptionKey with
+const (
+	KDFPBKDF2   = "pbkdf2-sha512"
+	KDFArgon2id = "argon2id"
+)
+
+// parameters of the PBKDF2 derivation hard-coded in gopass, which every user
+// created before KDF parameters were stored with the user derives their key with
+const LegacyKDFIterations = 101101
+
+// LegacyFakeKDFShare is the share of unknown emails given made up LegacyKDF
+// parameters, it should be close to the share of users still on LegacyKDF so
+// that salt-less parameters don't tell that an account exists
+var LegacyFakeKDFShare = 0.2
+
+// limits of the Argon2id parameters a user may choose, memory is in KiB
+const (
+	KDFSaltSize          = 16
+	minArgon2Iterations  = 2
+	maxArgon2Iterations  = 10
+	minArgon2Memory      = 19 * 1024
+	maxArgon2Memory      = 1024 * 1024
+	maxArgon2Parallelism = 16
+)
+
+// a KDF describes how a user derives their EncryptionKey from their master password
+type KDF struct {
+	Algorithm  string `gorm:"default:pbkdf2-sha512"`
+	Iterations uint   `gorm:"default:101101"`
+	// Memory is the Argon2id memory cost in KiB
+	Memory      uint `gorm:"default:0"`
+	Parallelism uint `gorm:"default:0"`
+	// Salt is empty for users whose salt is only known to their clients
+	Salt []byte
+}
+
+// LegacyKDF returns the parameters of users that predate stored KDF parameters
+func LegacyKDF() KDF {
+	return KDF{Algorithm: KDFPBKDF2, Iterations: LegacyKDFIterations}
+}
+
+// DefaultKDF returns the Argon2id parameters offered for new users, with the given salt
+func DefaultKDF(salt []byte) KDF {
+	return KDF{
+		Algorithm:   KDFArgon2id,
+		Iterations:  3,
+		Memory:      64 * 1024,
+		Parallelism: 4,
+		Salt:        salt,
+	}
+}
+
+// a KDFPolicy describes the KDF parameters ValidateKDF accepts, memory is in KiB
+type KDFPolicy struct {
+	Algorithms []string
+	// Default are the parameters offered for new users, without a salt
+	Default              KDF
+	PBKDF2MinIterations  uint
+	Argon2MinIterations  uint
+	Argon2MaxIterations  uint
+	Argon2MinMemory      uint
+	Argon2MaxMemory      uint
+	Argon2MaxParallelism uint
+	SaltSize             uint
+}
+
+// GetKDFPolicy returns the limits ValidateKDF enforces
+func GetKDFPolicy() KDFPolicy {
+	return KDFPolicy{
+		Algorithms:           []string{KDFArgon2id, KDFPBKDF2},
+		Default:              DefaultKDF(nil),
+		PBKDF2MinIterations:  LegacyKDFIterations,
+		Argon2MinIterations:  minArgon2Iterations,
+		Argon2MaxIterations:  maxArgon2Iterations,
+		Argon2MinMemory:      minArgon2Memory,
+		Argon2MaxMemory:      maxArgon2Memory,
+		Argon2MaxParallelism: maxArgon2Parallelism,
+		SaltSize:             KDFSaltSize,
+	}
+}
+
+// ValidateKDF refuses parameters weaker than the server allows
+func ValidateKDF(kdf KDF) error {
+	switch kdf.Algorithm {
+	case KDFPBKDF2:
+		if kdf.Iterations < LegacyKDFIterations || kdf.Memory != 0 || kdf.Parallelism != 0 {
+			return fmt.Errorf("%w: %s needs at least %d iterations", ErrInvalidKDF, KDFPBKDF2, LegacyKDFIterations)
+		}
+		return nil
+	case KDFArgon2id:
+		if kdf.Iterations < minArgon2Iterations || kdf.Iterations > maxArgon2Iterations ||
+			kdf.Memory < minArgon2Memory || kdf.Memory > maxArgon2Memory ||
+			kdf.Parallelism < 1 || kdf.Parallelism > maxArgon2Parallelism ||
+			len(kdf.Salt) < KDFSaltSize {
+			return fmt.Errorf("%w: %s needs %d-%d iterations, %d-%d KiB of memory, 1-%d threads and a %d byte salt", ErrInvalidKDF, KDFArgon2id,
+				minArgon2Iterations, maxArgon2Iterations, minArgon2Memory, maxArgon2Memory, maxArgon2Parallelism, KDFSaltSize)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown algorithm %q", ErrInvalidKDF, kdf.Algorithm)
+}
+
+// GetKDF returns the KDF parameters of the user with the given email
+// unknown emails get parameters derived from key, so that they are stable and
+// look like those of a real user, and can't be told apart from one.
+// a share of them are the salt-less LegacyKDF, like those of users who
+// haven't upgraded their KDF yet
+func GetKDF(ctx context.Context, db *gorm.DB, key []byte, email string) (*KDF, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user, _, err := findUserByEmail(db.WithContext(ctx), email)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID != 0 {
+		return &user.KDF, nil
+	}
+
+	kdf := fakeKDF(key, email)
+	return &kdf, nil
+}
+
+// fakeKDF makes up the KDF parameters of an unknown email, LegacyKDF for
+// LegacyFakeKDFShare of them
+func fakeKDF(key []byte, email string) KDF {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(NormalizeEmail(email)))
+	sum := mac.Sum(nil)
+	if float64(binary.BigEndian.Uint32(sum[KDFSaltSize:])) < LegacyFakeKDFShare*(1<<32) {
+		return LegacyKDF()
+	}
+	return DefaultKDF(sum[:KDFSaltSize])
+}
+
+// UpdateUserKDF changes the KDF parameters and AuthenticationHash of a user
+// the new key changes every entry's ciphertext, so ops must update every
+// entry of the vault, they are applied in the same transaction
+func UpdateUserKDF(ctx context.Context, db *gorm.DB, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return err
+	}
+	authHashHash, pepper, err := hashAuthHash(authenticationHash)
+	if err != nil {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var uuids []string
+		result := tx.Model(&VaultEntry{}).Where("vault_id = ?", user.Vault.ID).Pluck("uuid", &uuids)
+		if result.Error != nil {
+			return result.Error
+		}
+		err := checkRekey(uuids, ops)
+		if err != nil {
+			return err
+		}
+
+		// vaults may hold more entries than fit in a single batch
+		for start := 0; start < len(ops); start += MaxBatchOperations {
+			end := start + MaxBatchOperations
+			if end > len(ops) {
+				end = len(ops)
+			}
+			_, err := ApplyVaultEntryBatch(ctx, tx, user, ops[start:end], schemaVersion)
+			if err != nil {
+				return err
+			}
+		}
+
+		result = tx.Model(user).Updates(map[string]interface{}{
+			"kdf_algorithm":    kdf.Algorithm,
+			"kdf_iterations":   kdf.Iterations,
+			"kdf_memory":       kdf.Memory,
+			"kdf_parallelism":  kdf.Parallelism,
+			"kdf_salt":         kdf.Salt,
+			"auth_hash_hash":   authHashHash,
+			"auth_hash_pepper": pepper,
+		})
+		return result.Error
+	})
+}
+
+// checkRekey ensures ops update each of the entries uuids exactly once
+func checkRekey(uuids []string, ops []BatchOperation) error {
+	pending := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		pending[uuid] = true
+	}
+	for _, op := range ops {
+		if op.Op != BatchUpdate || !pending[op.ID] {
+			return ErrIncompleteRekey
+		}
+		delete(pending, op.ID)
+	}
+	if len(pending) > 0 {
+		return ErrIncompleteRekey
+	}
+	return nil
+}
@@ -0,0 +1,512 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping everything in memory, for tests
+type MemoryStore struct {
+	mu     sync.Mutex
+	lastID uint
+	// users by ID, and their IDs by EmailHash
+	users  map[uint]*User
+	emails map[string]uint
+	// the entries of every vault, by vault ID, in the order they were created
+	entries map[uint][]*VaultEntry
+	// sessions by TokenHash
+	sessions map[string]*Session
+	// data keys by vault ID
+	dataKeys map[uint]*DataKey
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[uint]*User),
+		emails:   make(map[string]uint),
+		entries:  make(map[uint][]*VaultEntry),
+		sessions: make(map[string]*Session),
+		dataKeys: make(map[uint]*DataKey),
+	}
+}
+
+// nextID returns the next unused ID, shared by every kind of record
+func (s *MemoryStore) nextID() uint {
+	s.lastID++
+	return s.lastID
+}
+
+// user returns a copy of a stored user, with their vault but not its entries
+func (s *MemoryStore) user(id uint) *User {
+	u := *s.users[id]
+	u.Vault.VaultEntries = nil
+	return &u
+}
+
+// vaultEntries returns copies of the entries of a vault
+func (s *MemoryStore) vaultEntries(vaultID uint) []VaultEntry {
+	entries := make([]VaultEntry, 0, len(s.entries[vaultID]))
+	for _, e := range s.entries[vaultID] {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// findEntry returns the index of an entry in the entries of a vault, or -1
+func (s *MemoryStore) findEntry(vaultID uint, entryUUID string) int {
+	for i, e := range s.entries[vaultID] {
+		if e.UUID == entryUUID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, email string, authenticationHash []byte, kdf KDF) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := newUser(email, authenticationHash, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.emails[user.EmailHash]; ok {
+		return nil, ErrUserAlreadyExists
+	}
+	now := time.Now()
+	user.ID = s.nextID()
+	user.CreatedAt, user.UpdatedAt = now, now
+	user.Vault.ID = s.nextID()
+	user.Vault.CreatedAt, user.Vault.UpdatedAt = now, now
+	user.VaultID = user.Vault.ID
+
+	s.users[user.ID] = user
+	s.emails[user.EmailHash] = user.ID
+	return s.user(user.ID), nil
+}
+
+// authenticate returns a copy of the user of email after checking their AuthenticationHash
+func (s *MemoryStore) authenticate(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.emails[EmailHash(email)]
+	if !ok {
+		return nil, ErrUserDoesNotExist
+	}
+	user := s.users[id]
+	_, err := checkAuthHash(user, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.user(id), nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.authenticate(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.GetVault(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) GetVerifiedUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	user, err := s.authenticate(ctx, email, authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVerified(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) GetKDF(ctx context.Context, key []byte, email string) (*KDF, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.emails[EmailHash(email)]; ok {
+		kdf := s.users[id].KDF
+		return &kdf, nil
+	}
+	kdf := fakeKDF(key, email)
+	return &kdf, nil
+}
+
+func (s *MemoryStore) UpdateUserKDF(ctx context.Context, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return err
+	}
+	authHashHash, pepper, err := hashAuthHash(authenticationHash)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return ErrUserDoesNotExist
+	}
+	var uuids []string
+	for _, e := range s.entries[stored.VaultID] {
+		uuids = append(uuids, e.UUID)
+	}
+	err = checkRekey(uuids, ops)
+	if err != nil {
+		return err
+	}
+
+	entries, _, err := s.applyBatch(stored.VaultID, ops, schemaVersion)
+	if err != nil {
+		return err
+	}
+	s.entries[stored.VaultID] = entries
+	stored.KDF = kdf
+	stored.AuthHashHash, stored.AuthHashPepper = authHashHash, pepper
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(ctx context.Context, user *User) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := newSession(user)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.ID = s.nextID()
+	session.CreatedAt, session.UpdatedAt = time.Now(), time.Now()
+	stored := *session
+	stored.Token = ""
+	s.sessions[session.TokenHash] = &stored
+	return session, nil
+}
+
+func (s *MemoryStore) GetSessionUser(ctx context.Context, token string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[StringToEncodedHash(token)]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	if _, ok := s.users[session.UserID]; !ok {
+		return nil, ErrUserDoesNotExist
+	}
+	return s.user(session.UserID), nil
+}
+
+func (s *MemoryStore) DeleteSession(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokenHash := StringToEncodedHash(token)
+	if _, ok := s.sessions[tokenHash]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(ctx context.Context, user *User, keep string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keepHash := StringToEncodedHash(keep)
+	for tokenHash, session := range s.sessions {
+		if session.UserID == user.ID && (keep == "" || tokenHash != keepHash) {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetVault(ctx context.Context, user *User) (*Vault, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.Vault.VaultEntries = s.vaultEntries(user.Vault.ID)
+	return &user.Vault, nil
+}
+
+func (s *MemoryStore) CheckSchemaVersion(ctx context.Context, user *User, supported uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries[user.Vault.ID] {
+		if e.SchemaVersion > supported {
+			return ErrSchemaVersionTooNew
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetVaultEntry(ctx context.Context, user *User, entryUUID string) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findEntry(user.Vault.ID, entryUUID)
+	if i < 0 {
+		return nil, ErrEntryNotFound
+	}
+	entry := *s.entries[user.Vault.ID][i]
+	return &entry, nil
+}
+
+func (s *MemoryStore) CreateVaultEntry(ctx context.Context, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := Quotas.checkGrowth(len(s.entries[user.Vault.ID]), 1); err != nil {
+		return nil, err
+	}
+	entries, results, err := s.applyBatch(user.Vault.ID, []BatchOperation{{Op: BatchCreate, EncryptedEntry: encryptedEntry}}, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	s.entries[user.Vault.ID] = entries
+	return results[0].Entry, nil
+}
+
+func (s *MemoryStore) UpdateVaultEntry(ctx context.Context, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, results, err := s.applyBatch(user.Vault.ID, []BatchOperation{{Op: BatchUpdate, ID: entryUUID, EncryptedEntry: encryptedEntry}}, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	s.entries[user.Vault.ID] = entries
+	return results[0].Entry, nil
+}
+
+func (s *MemoryStore) DeleteVaultEntry(ctx context.Context, user *User, entryUUID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, _, err := s.applyBatch(user.Vault.ID, []BatchOperation{{Op: BatchDelete, ID: entryUUID}}, 0)
+	if err != nil {
+		return err
+	}
+	s.entries[user.Vault.ID] = entries
+	return nil
+}
+
+func (s *MemoryStore) ApplyVaultEntryBatch(ctx context.Context, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(ops) > MaxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := Quotas.checkGrowth(len(s.entries[user.Vault.ID]), growth(ops)); err != nil {
+		return nil, err
+	}
+	entries, results, err := s.applyBatch(user.Vault.ID, ops, schemaVersion)
+	if err != nil {
+		failed := -1
+		for i, r := range results {
+			if r.Error != "" {
+				failed = i
+			}
+		}
+		return batchResults(results, failed)
+	}
+	s.entries[user.Vault.ID] = entries
+	return results, nil
+}
+
+// applyBatch applies ops to a copy of the entries of a vault, which replaces
+// them once every operation succeeded. on failure the result of the failing
+// operation holds its error
+func (s *MemoryStore) applyBatch(vaultID uint, ops []BatchOperation, schemaVersion uint) ([]*VaultEntry, []BatchResult, error) {
+	entries := append([]*VaultEntry(nil), s.entries[vaultID]...)
+	results := make([]BatchResult, len(ops))
+	lastID := s.lastID
+
+	for i, op := range ops {
+		entry, err := s.applyOperation(vaultID, &entries, op, schemaVersion)
+		if err != nil {
+			s.lastID = lastID
+			results[i].Error = err.Error()
+			return nil, results, err
+		}
+		results[i].Entry = entry
+	}
+	return entries, results, nil
+}
+
+// applyOperation applies op to entries, returning a copy of the created or updated entry
+func (s *MemoryStore) applyOperation(vaultID uint, entries *[]*VaultEntry, op BatchOperation, schemaVersion uint) (*VaultEntry, error) {
+	find := func() int {
+		for i, e := range *entries {
+			if e.UUID == op.ID {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch op.Op {
+	case BatchCreate:
+		uuid, err := GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		entry := &VaultEntry{
+			ID:             s.nextID(),
+			UUID:           uuid,
+			VaultID:        vaultID,
+			EncryptedEntry: op.EncryptedEntry,
+			SchemaVersion:  schemaVersion,
+		}
+		entry.CreatedAt, entry.UpdatedAt = now, now
+		*entries = append(*entries, entry)
+		created := *entry
+		return &created, nil
+
+	case BatchUpdate:
+		i := find()
+		if i < 0 {
+			return nil, ErrEntryNotFound
+		}
+		// entries are shared with the committed vault, replace rather than modify them
+		entry := *(*entries)[i]
+		entry.EncryptedEntry = op.EncryptedEntry
+		entry.SchemaVersion = schemaVersion
+		entry.UpdatedAt = time.Now()
+		(*entries)[i] = &entry
+		updated := entry
+		return &updated, nil
+
+	case BatchDelete:
+		i := find()
+		if i < 0 {
+			return nil, ErrEntryNotFound
+		}
+		*entries = append((*entries)[:i:i], (*entries)[i+1:]...)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownOperation, op.Op)
+}
+
+func (s *MemoryStore) GetDataKey(ctx context.Context, vaultID uint) (*DataKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.dataKeys[vaultID]
+	if !ok {
+		return nil, ErrDataKeyNotFound
+	}
+	k := *key
+	return &k, nil
+}
+
+func (s *MemoryStore) CreateDataKey(ctx context.Context, key *DataKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dataKeys[key.VaultID]; ok {
+		return fmt.Errorf("vault %d already has a data key", key.VaultID)
+	}
+	key.ID = s.nextID()
+	key.CreatedAt, key.UpdatedAt = time.Now(), time.Now()
+	k := *key
+	s.dataKeys[key.VaultID] = &k
+	return nil
+}
+
+func (s *MemoryStore) RewrapDataKeys(ctx context.Context, rewrap func(key *DataKey) (bool, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// rewrap copies so that nothing changes if any of them fails
+	rewrapped := make(map[uint]*DataKey)
+	for vaultID, key := range s.dataKeys {
+		k := *key
+		changed, err := rewrap(&k)
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			k.UpdatedAt = time.Now()
+			rewrapped[vaultID] = &k
+		}
+	}
+	for vaultID, key := range rewrapped {
+		s.dataKeys[vaultID] = key
+	}
+	return len(rewrapped), nil
+}
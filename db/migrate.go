@@ -0,0 +1,297 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this server, upgrade the server or migrate down with a newer one")
+var ErrUnknownMigration = errors.New("unknown migration version")
+
+// a Migration moves the schema one version up, or back down
+// migrations only ever use the models of their own version, never the current
+// ones, so that they keep doing the same thing as the models change
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// a SchemaMigration records a migration applied to the database
+type SchemaMigration struct {
+	Version   uint `gorm:"primarykey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrations lists every migration in version order, new migrations are only
+// ever appended
+var Migrations = []Migration{
+	{1, "initial schema", migrateInitialUp, migrateInitialDown},
+	{2, "prefix verification columns", migrateVerificationUp, migrateVerificationDown},
+	{3, "index uuids and email hashes", migrateIndexesUp, migrateIndexesDown},
+	{4, "data keys", migrateDataKeysUp, migrateDataKeysDown},
+}
+
+// LatestVersion is the schema version the models of this package describe
+func LatestVersion() uint {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// AppliedMigrations returns the migrations applied to the database, oldest first
+func AppliedMigrations(ctx context.Context, db *gorm.DB) ([]SchemaMigration, error) {
+	db = db.WithContext(ctx)
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	var applied []SchemaMigration
+	result := db.Order("version").Find(&applied)
+	return applied, result.Error
+}
+
+// SchemaVersion returns the version of the last migration applied to the database
+func SchemaVersion(ctx context.Context, db *gorm.DB) (uint, error) {
+	applied, err := AppliedMigrations(ctx, db)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// Migrate applies every pending migration
+func Migrate(db *gorm.DB) error {
+	return MigrateTo(context.Background(), db, LatestVersion())
+}
+
+// MigrateTo applies or reverts migrations until the schema is at version,
+// version 0 drops every table. each migration runs in its own transaction
+// along with its schema_migrations row, though mysql commits DDL statements
+// immediately so a migration failing there may need cleaning up by hand
+func MigrateTo(ctx context.Context, db *gorm.DB, version uint) error {
+	if version > LatestVersion() {
+		return fmt.Errorf("%w %d", ErrUnknownMigration, version)
+	}
+	db = db.WithContext(ctx)
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := db.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+			return err
+		}
+	}
+
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("%w: at version %d, this server knows up to %d", ErrSchemaTooNew, current, LatestVersion())
+	}
+
+	for _, m := range Migrations {
+		if m.Version <= current || m.Version > version {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		m := Migrations[i]
+		if m.Version > current || m.Version <= version {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// the schema of version 1, as created by AutoMigrate before migrations were
+// versioned. Verification embedded a second gorm.Model, whose columns
+// collided with the user's own
+type (
+	v1Vault struct {
+		gorm.Model
+		UUID         string
+		VaultEntries []v1VaultEntry `gorm:"foreignKey:VaultID"`
+	}
+	v1User struct {
+		gorm.Model
+		UUID           string
+		EmailHash      string
+		AuthHashHash   []byte
+		AuthHashPepper string
+		KDF            v1KDF          `gorm:"embedded;embeddedPrefix:kdf_"`
+		Verification   v1Verification `gorm:"embedded"`
+		VaultID        uint
+		Vault          v1Vault
+	}
+	v1KDF struct {
+		Algorithm   string `gorm:"default:pbkdf2-sha512"`
+		Iterations  uint   `gorm:"default:101101"`
+		Memory      uint
+		Parallelism uint
+		Salt        []byte
+	}
+	v1Verification struct {
+		gorm.Model
+		Hash      string
+		Completed bool `gorm:"default:false"`
+		Attempts  uint `gorm:"default:0"`
+	}
+	v1VaultEntry struct {
+		gorm.Model
+		UUID           string
+		VaultID        uint
+		EncryptedEntry []byte
+		SchemaVersion  uint `gorm:"default:0"`
+	}
+	v1Session struct {
+		gorm.Model
+		TokenHash string `gorm:"uniqueIndex"`
+		UserID    uint
+		ExpiresAt time.Time
+	}
+)
+
+func (v1Vault) TableName() string      { return "vaults" }
+func (v1User) TableName() string       { return "users" }
+func (v1VaultEntry) TableName() string { return "vault_entries" }
+func (v1Session) TableName() string    { return "sessions" }
+
+// migrateInitialUp creates the tables, databases created before migrations were
+// versioned already have them and are left as they are
+func migrateInitialUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&v1Vault{}, &v1User{}, &v1VaultEntry{}, &v1Session{})
+}
+
+func migrateInitialDown(tx *gorm.DB) error {
+	for _, m := range []interface{}{&v1Session{}, &v1VaultEntry{}, &v1User{}, &v1Vault{}} {
+		if err := tx.Migrator().DropTable(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// v2User is v1User with its verification columns prefixed
+type v2User struct {
+	gorm.Model
+	VerificationHash      string
+	VerificationCompleted bool `gorm:"default:false"`
+	VerificationAttempts  uint `gorm:"default:0"`
+}
+
+func (v2User) TableName() string { return "users" }
+
+var verificationColumns = [][2]string{
+	{"hash", "verification_hash"},
+	{"completed", "verification_completed"},
+	{"attempts", "verification_attempts"},
+}
+
+func migrateVerificationUp(tx *gorm.DB) error {
+	for _, c := range verificationColumns {
+		if err := tx.Migrator().RenameColumn(&v2User{}, c[0], c[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateVerificationDown(tx *gorm.DB) error {
+	for _, c := range verificationColumns {
+		if err := tx.Migrator().RenameColumn(&v1User{}, c[1], c[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the indexes added in version 3, entries are looked up by vault and UUID
+type (
+	v3User struct {
+		UUID      string `gorm:"uniqueIndex"`
+		EmailHash string `gorm:"uniqueIndex"`
+	}
+	v3Vault struct {
+		UUID string `gorm:"uniqueIndex"`
+	}
+	v3VaultEntry struct {
+		UUID    string `gorm:"uniqueIndex"`
+		VaultID uint   `gorm:"index"`
+	}
+)
+
+func (v3User) TableName() string       { return "users" }
+func (v3Vault) TableName() string      { return "vaults" }
+func (v3VaultEntry) TableName() string { return "vault_entries" }
+
+var v3Indexes = []struct {
+	model interface{}
+	field string
+}{
+	{&v3User{}, "UUID"},
+	{&v3User{}, "EmailHash"},
+	{&v3Vault{}, "UUID"},
+	{&v3VaultEntry{}, "UUID"},
+	{&v3VaultEntry{}, "VaultID"},
+}
+
+func migrateIndexesUp(tx *gorm.DB) error {
+	for _, i := range v3Indexes {
+		if err := tx.Migrator().CreateIndex(i.model, i.field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateIndexesDown(tx *gorm.DB) error {
+	for _, i := range v3Indexes {
+		if err := tx.Migrator().DropIndex(i.model, i.field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// v4DataKey holds the wrapped data keys of vaults encrypted at rest
+type v4DataKey struct {
+	gorm.Model
+	VaultID     uint `gorm:"uniqueIndex"`
+	MasterKeyID string
+	WrappedKey  []byte
+}
+
+func (v4DataKey) TableName() string { return "data_keys" }
+
+func migrateDataKeysUp(tx *gorm.DB) error {
+	return tx.Migrator().CreateTable(&v4DataKey{})
+}
+
+func migrateDataKeysDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&v4DataKey{})
+}
@@ -0,0 +1,99 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testMigrations runs every migration up and down on gdb, which must be empty
+func testMigrations(t *testing.T, gdb *gorm.DB) {
+	ctx := context.Background()
+	t.Cleanup(func() {
+		db.MigrateTo(ctx, gdb, 0)
+		gdb.Migrator().DropTable(&db.SchemaMigration{})
+	})
+
+	// databases created before migrations were versioned are at version 1
+	require.NoError(t, db.MigrateTo(ctx, gdb, 1))
+	require.True(t, gdb.Migrator().HasColumn(&db.User{}, "hash"))
+	require.NoError(t, gdb.Exec("INSERT INTO users (uuid, email_hash, hash, completed, vault_id) VALUES (?, ?, ?, ?, ?)", "uuid", "email hash", "verification hash", true, 0).Error)
+
+	require.NoError(t, db.Migrate(gdb))
+	version, err := db.SchemaVersion(ctx, gdb)
+	require.NoError(t, err)
+	require.Equal(t, db.LatestVersion(), version)
+	applied, err := db.AppliedMigrations(ctx, gdb)
+	require.NoError(t, err)
+	require.Len(t, applied, len(db.Migrations))
+
+	user := db.User{}
+	require.NoError(t, gdb.Where("uuid = ?", "uuid").First(&user).Error)
+	require.Equal(t, "verification hash", user.Verification.Hash)
+	require.True(t, user.Verification.Completed)
+
+	// the migrated schema holds every column of the models
+	for _, m := range db.Models() {
+		stmt := &gorm.Statement{DB: gdb}
+		require.NoError(t, stmt.Parse(m))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				require.True(t, gdb.Migrator().HasColumn(m, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			require.True(t, gdb.Migrator().HasIndex(m, idx.Name), "%s %s", stmt.Schema.Table, idx.Name)
+		}
+	}
+	require.False(t, gdb.Migrator().HasColumn(&db.User{}, "hash"))
+
+	require.NoError(t, db.MigrateTo(ctx, gdb, 1))
+	require.True(t, gdb.Migrator().HasColumn(&db.User{}, "hash"))
+	require.False(t, gdb.Migrator().HasIndex(&db.User{}, "idx_users_uuid"))
+	require.NoError(t, db.MigrateTo(ctx, gdb, 0))
+	for _, m := range db.Models() {
+		require.False(t, gdb.Migrator().HasTable(m))
+	}
+	version, err = db.SchemaVersion(ctx, gdb)
+	require.NoError(t, err)
+	require.Zero(t, version)
+
+	require.ErrorIs(t, db.MigrateTo(ctx, gdb, db.LatestVersion()+1), db.ErrUnknownMigration)
+	require.NoError(t, gdb.Create(&db.SchemaMigration{Version: db.LatestVersion() + 1, Name: "from the future"}).Error)
+	require.ErrorIs(t, db.Migrate(gdb), db.ErrSchemaTooNew)
+}
+
+func Test_MigrateSQLite(t *testing.T) {
+	gdb, err := db.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared", &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	testMigrations(t, gdb)
+}
+
+// openTestDB connects to the empty database named by the DSN in env, skipping
+// the test when it is unset
+func openTestDB(t *testing.T, driver, env string) *gorm.DB {
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skipf("set %s to the DSN of an empty %s database to run this test", env, driver)
+	}
+	gdb, err := db.Open(driver, dsn, &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	return gdb
+}
+
+func Test_MigratePostgres(t *testing.T) {
+	testMigrations(t, openTestDB(t, "postgres", "GOPASS_SERVER_TEST_POSTGRES_DSN"))
+}
+
+func Test_MigrateMySQL(t *testing.T) {
+	testMigrations(t, openTestDB(t, "mysql", "GOPASS_SERVER_TEST_MYSQL_DSN"))
+}
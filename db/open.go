@@ -0,0 +1,23 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+// Drivers lists the SQL database drivers Open supports
+var Drivers = []string{"sqlite", "postgres", "mysql"}
+
+// Open connects to the database identified by a driver name and DSN, see
+// Config to tune the connections
+func Open(driver, dsn string, config *gorm.Config) (*gorm.DB, error) {
+	return Config{Driver: driver, DSN: dsn}.Open(config)
+}
+
+// Models lists every model stored in the database, in dependency order
+// schema_migrations is managed by MigrateTo and not listed
+func Models() []interface{} {
+	return []interface{}{&Vault{}, &User{}, &VaultEntry{}, &Session{}, &DataKey{}}
+}
+
+// StoreDrivers lists the drivers a Config can open a Store on, the SQL Drivers and BoltDriver
+var StoreDrivers = append(append([]string(nil), Drivers...), BoltDriver)
@@ -0,0 +1,287 @@
+// Package passhash hashes the AuthenticationHash of users for storage
+//
+// Hashes are stored as PHC strings, e.g.
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//
+// bcrypt hashes keep their own $2a$<cost>$ format, which is what users
+// created before the algorithm was configurable have stored
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrMismatch = errors.New("AuthenticationHash does not match")
+var ErrInvalidHash = errors.New("invalid stored hash")
+var ErrInvalidPolicy = errors.New("invalid password hash policy")
+
+// hash algorithms
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+)
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+var b64 = base64.RawStdEncoding
+
+// a Policy is the algorithm and parameters new hashes are made with
+type Policy struct {
+	Algorithm string
+	// Cost is the bcrypt cost
+	Cost int
+	// Time, Memory (in KiB) and Threads are the Argon2id parameters
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	// LogN, BlockSize and Parallelism are the scrypt parameters, N = 2^LogN
+	LogN        uint8
+	BlockSize   int
+	Parallelism int
+}
+
+// Default is Argon2id with the parameters recommended by OWASP
+func Default() Policy {
+	return Policy{Algorithm: Argon2id, Time: 2, Memory: 19 * 1024, Threads: 1}
+}
+
+// ParsePolicy parses an algorithm optionally followed by its parameters in
+// PHC notation, e.g. "bcrypt:cost=12", "argon2id:m=65536,t=3,p=4" or
+// "scrypt:ln=16,r=8,p=1", parameters that aren't given keep their defaults
+func ParsePolicy(s string) (Policy, error) {
+	alg, params := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		alg, params = s[:i], s[i+1:]
+	}
+
+	var p Policy
+	switch alg {
+	case Bcrypt:
+		p = Policy{Algorithm: Bcrypt, Cost: bcrypt.DefaultCost}
+	case Argon2id:
+		p = Default()
+	case Scrypt:
+		p = Policy{Algorithm: Scrypt, LogN: 15, BlockSize: 8, Parallelism: 1}
+	default:
+		return Policy{}, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidPolicy, alg)
+	}
+	if params != "" {
+		err := p.setParams(params)
+		if err != nil {
+			return Policy{}, err
+		}
+	}
+	return p, p.validate()
+}
+
+// setParams sets the parameters of a comma separated list of name=value pairs
+func (p *Policy) setParams(params string) error {
+	for _, kv := range strings.Split(params, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("%w: %q is not name=value", ErrInvalidPolicy, kv)
+		}
+		name := kv[:i]
+		n, err := strconv.ParseUint(kv[i+1:], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, name, err)
+		}
+
+		switch {
+		case p.Algorithm == Bcrypt && name == "cost":
+			p.Cost = int(n)
+		case p.Algorithm == Argon2id && name == "t":
+			p.Time = uint32(n)
+		case p.Algorithm == Argon2id && name == "m":
+			p.Memory = uint32(n)
+		case p.Algorithm == Argon2id && name == "p" && n <= 255:
+			p.Threads = uint8(n)
+		case p.Algorithm == Scrypt && name == "ln" && n <= 255:
+			p.LogN = uint8(n)
+		case p.Algorithm == Scrypt && name == "r":
+			p.BlockSize = int(n)
+		case p.Algorithm == Scrypt && name == "p":
+			p.Parallelism = int(n)
+		default:
+			return fmt.Errorf("%w: unknown %s parameter %q", ErrInvalidPolicy, p.Algorithm, kv)
+		}
+	}
+	return nil
+}
+
+func (p Policy) validate() error {
+	var ok bool
+	switch p.Algorithm {
+	case Bcrypt:
+		ok = p.Cost >= bcrypt.MinCost && p.Cost <= bcrypt.MaxCost
+	case Argon2id:
+		ok = p.Time >= 1 && p.Threads >= 1 && p.Memory >= 8*uint32(p.Threads)
+	case Scrypt:
+		ok = p.LogN > 1 && p.LogN < 32 && p.BlockSize >= 1 && p.Parallelism >= 1
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidPolicy, p)
+	}
+	return nil
+}
+
+// String formats the policy the way ParsePolicy reads it
+func (p Policy) String() string {
+	switch p.Algorithm {
+	case Bcrypt:
+		return fmt.Sprintf("%s:cost=%d", p.Algorithm, p.Cost)
+	case Argon2id:
+		return fmt.Sprintf("%s:m=%d,t=%d,p=%d", p.Algorithm, p.Memory, p.Time, p.Threads)
+	case Scrypt:
+		return fmt.Sprintf("%s:ln=%d,r=%d,p=%d", p.Algorithm, p.LogN, p.BlockSize, p.Parallelism)
+	}
+	return p.Algorithm
+}
+
+// Hash hashes password with a fresh salt
+func (p Policy) Hash(password []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.Algorithm == Bcrypt {
+		return bcrypt.GenerateFromPassword(password, p.Cost)
+	}
+
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	var params string
+	switch p.Algorithm {
+	case Argon2id:
+		params = fmt.Sprintf("v=%d$m=%d,t=%d,p=%d", argon2.Version, p.Memory, p.Time, p.Threads)
+	case Scrypt:
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", p.LogN, p.BlockSize, p.Parallelism)
+	}
+	return []byte(fmt.Sprintf("$%s$%s$%s$%s", p.Algorithm, params, b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+}
+
+// key derives the key of the argon2id and scrypt policies
+func (p Policy) key(password, salt []byte) ([]byte, error) {
+	if p.Algorithm == Argon2id {
+		return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, keySize), nil
+	}
+	return scrypt.Key(password, salt, 1<<p.LogN, p.BlockSize, p.Parallelism, keySize)
+}
+
+// Verify checks password against a stored hash of any supported algorithm
+func Verify(hash, password []byte) error {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, password)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+
+	p, salt, key, err := parse(hash)
+	if err != nil {
+		return err
+	}
+	got, err := p.key(password, salt)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether a stored hash is of another algorithm than the
+// policy, or uses weaker parameters
+func (p Policy) NeedsRehash(hash []byte) bool {
+	var stored Policy
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return true
+		}
+		stored = Policy{Algorithm: Bcrypt, Cost: cost}
+	} else {
+		var err error
+		stored, _, _, err = parse(hash)
+		if err != nil {
+			return true
+		}
+	}
+
+	if stored.Algorithm != p.Algorithm {
+		return true
+	}
+	switch p.Algorithm {
+	case Bcrypt:
+		return stored.Cost < p.Cost
+	case Argon2id:
+		return stored.Time < p.Time || stored.Memory < p.Memory
+	case Scrypt:
+		return stored.LogN < p.LogN || stored.BlockSize < p.BlockSize || stored.Parallelism < p.Parallelism
+	}
+	return false
+}
+
+func isBcrypt(hash []byte) bool {
+	return len(hash) > 3 && hash[0] == '$' && hash[1] == '2'
+}
+
+// parse splits an argon2id or scrypt PHC string into its policy, salt and key
+func parse(hash []byte) (Policy, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	var p Policy
+	var params string
+	switch {
+	case len(parts) == 6 && parts[1] == Argon2id:
+		if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return Policy{}, nil, nil, fmt.Errorf("%w: unsupported argon2 version %s", ErrInvalidHash, parts[2])
+		}
+		p.Algorithm, params = Argon2id, parts[3]
+	case len(parts) == 5 && parts[1] == Scrypt:
+		p.Algorithm, params = Scrypt, parts[2]
+	default:
+		return Policy{}, nil, nil, ErrInvalidHash
+	}
+
+	err := p.setParams(params)
+	if err == nil {
+		err = p.validate()
+	}
+	if err != nil {
+		return Policy{}, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	salt, err := b64.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return Policy{}, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	key, err := b64.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return Policy{}, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	return p, salt, key, nil
+}
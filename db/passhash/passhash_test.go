@@ -0,0 +1,93 @@
+package passhash_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db/passhash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HashVerify(t *testing.T) {
+	password := []byte("authentication hash")
+
+	for _, policy := range []string{"bcrypt:cost=4", "argon2id:m=1024,t=1,p=2", "scrypt:ln=10,r=8,p=1"} {
+		p, err := passhash.ParsePolicy(policy)
+		require.NoError(t, err, policy)
+		require.Equal(t, policy, p.String())
+
+		hash, err := p.Hash(password)
+		require.NoError(t, err, policy)
+		require.NoError(t, passhash.Verify(hash, password), policy)
+		require.Equal(t, passhash.ErrMismatch, passhash.Verify(hash, []byte("wrong")), policy)
+		require.False(t, p.NeedsRehash(hash), policy)
+	}
+
+	hash, err := passhash.Default().Hash(password)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=19456,t=2,p=1$"), string(hash))
+
+	_, err = passhash.ParsePolicy("md5")
+	require.ErrorIs(t, err, passhash.ErrInvalidPolicy)
+	_, err = passhash.ParsePolicy("bcrypt:m=1")
+	require.ErrorIs(t, err, passhash.ErrInvalidPolicy)
+	require.ErrorIs(t, passhash.Verify([]byte("$argon2id$garbage"), password), passhash.ErrInvalidHash)
+}
+
+func Test_NeedsRehash(t *testing.T) {
+	password := []byte("authentication hash")
+	weak, err := passhash.ParsePolicy("argon2id:m=1024,t=1,p=1")
+	require.NoError(t, err)
+	hash, err := weak.Hash(password)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		policy string
+		rehash bool
+	}{
+		{"argon2id:m=1024,t=1,p=1", false},
+		{"argon2id:m=512,t=1,p=1", false},
+		{"argon2id:m=2048,t=1,p=1", true},
+		{"argon2id:m=1024,t=2,p=1", true},
+		{"bcrypt:cost=4", true},
+		{"scrypt:ln=10,r=8,p=1", true},
+	}
+	for _, test := range testCases {
+		p, err := passhash.ParsePolicy(test.policy)
+		require.NoError(t, err)
+		require.Equal(t, test.rehash, p.NeedsRehash(hash), test.policy)
+	}
+
+	bcryptPolicy, err := passhash.ParsePolicy("bcrypt:cost=4")
+	require.NoError(t, err)
+	hash, err = bcryptPolicy.Hash(password)
+	require.NoError(t, err)
+	stronger, err := passhash.ParsePolicy("bcrypt:cost=5")
+	require.NoError(t, err)
+	require.True(t, stronger.NeedsRehash(hash))
+	require.False(t, bcryptPolicy.NeedsRehash(hash))
+}
+
+func Test_Peppers(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("a 32 byte long pepper secret....."))
+	p, err := passhash.ParsePeppers("# newest first\n2021b " + key + "\n2021a " + key + "\n")
+	require.NoError(t, err)
+	require.Equal(t, "2021b", p.Current)
+	require.Len(t, p.Keys, 2)
+
+	password := []byte("authentication hash")
+	unpeppered, err := p.Apply("", password)
+	require.NoError(t, err)
+	require.Equal(t, password, unpeppered)
+	peppered, err := p.Apply("2021a", password)
+	require.NoError(t, err)
+	require.NotEqual(t, password, peppered)
+	_, err = p.Apply("2020", password)
+	require.ErrorIs(t, err, passhash.ErrUnknownPepper)
+
+	for _, invalid := range []string{"2021 short", "2021", "2021 !!!", "a " + key + ",a " + key} {
+		_, err = passhash.ParsePeppers(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,73 @@
+package passhash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownPepper = errors.New("hash was peppered with an unknown key")
+
+// minPepperSize is the smallest pepper key accepted
+const minPepperSize = 32
+
+// Peppers are server secrets mixed into passwords with HMAC-SHA256 before
+// they are hashed, so that the stored hashes can't be guessed at without them
+// each hash is stored along with the ID of the key it was peppered with,
+// keys that are no longer Current are still used to verify older hashes
+type Peppers struct {
+	// Current is the ID of the key new hashes are peppered with, no pepper
+	// is used when it is empty
+	Current string
+	Keys    map[string][]byte
+}
+
+// ParsePeppers reads pepper keys, one "<id> <base64 key>" pair per line or
+// comma separated. the first key is Current, to rotate the pepper add a new
+// key at the top and keep the old ones until every hash was rehashed
+func ParsePeppers(s string) (Peppers, error) {
+	p := Peppers{Keys: make(map[string][]byte)}
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return Peppers{}, fmt.Errorf("invalid pepper %q, expected <id> <base64 key>", line)
+		}
+		id := fields[0]
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return Peppers{}, fmt.Errorf("pepper %s: %w", id, err)
+		}
+		if len(key) < minPepperSize {
+			return Peppers{}, fmt.Errorf("pepper %s is shorter than %d bytes", id, minPepperSize)
+		}
+		if _, ok := p.Keys[id]; ok {
+			return Peppers{}, fmt.Errorf("pepper %s is listed twice", id)
+		}
+		if p.Current == "" {
+			p.Current = id
+		}
+		p.Keys[id] = key
+	}
+	return p, nil
+}
+
+// Apply peppers password with the key id, an empty id leaves it as is
+func (p Peppers) Apply(id string, password []byte) ([]byte, error) {
+	if id == "" {
+		return password, nil
+	}
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPepper, id)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(password)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrEntryTooLarge = errors.New("encrypted entry is larger than the server allows")
+var ErrQuotaExceeded = errors.New("vault holds as many entries as the server allows")
+
+// a Quota limits what each user may store, zero is unlimited
+type Quota struct {
+	// MaxEntrySize is the size in bytes of the largest EncryptedEntry accepted
+	MaxEntrySize int
+	// MaxEntries is how many entries a vault may hold, vaults already holding
+	// more can still update and delete theirs
+	MaxEntries int
+}
+
+// Quotas bound the vault of every user. stores refuse writes growing a vault
+// past MaxEntries, the API refuses entries larger than MaxEntrySize before
+// they reach a store, which may encrypt them once more
+var Quotas Quota
+
+// CheckEntries refuses encrypted entries larger than MaxEntrySize
+func (q Quota) CheckEntries(encryptedEntries ...[]byte) error {
+	if q.MaxEntrySize == 0 {
+		return nil
+	}
+	for _, e := range encryptedEntries {
+		if len(e) > q.MaxEntrySize {
+			return fmt.Errorf("%w: %d bytes, at most %d", ErrEntryTooLarge, len(e), q.MaxEntrySize)
+		}
+	}
+	return nil
+}
+
+// CheckOperations refuses batches creating or updating entries larger than MaxEntrySize
+func (q Quota) CheckOperations(ops []BatchOperation) error {
+	for _, op := range ops {
+		if err := q.CheckEntries(op.EncryptedEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGrowth refuses growing a vault of count entries by n past MaxEntries
+func (q Quota) checkGrowth(count, n int) error {
+	if q.MaxEntries == 0 || n <= 0 || count+n <= q.MaxEntries {
+		return nil
+	}
+	return fmt.Errorf("%w, at most %d", ErrQuotaExceeded, q.MaxEntries)
+}
+
+// growth returns by how many entries ops grow a vault, deletes of entries that
+// don't exist fail the batch anyway
+func growth(ops []BatchOperation) int {
+	n := 0
+	for _, op := range ops {
+		switch op.Op {
+		case BatchCreate:
+			n++
+		case BatchDelete:
+			n--
+		}
+	}
+	return n
+}
+
+// checkVaultQuota refuses growing the vault of user by n entries past
+// Quotas within tx, the vault is only counted when it grows. its row is locked
+// first, so that concurrent transactions growing it count one after the other
+// instead of both seeing room for their entries. sqlite, which doesn't lock
+// rows, only runs a single writing transaction at a time anyway
+func checkVaultQuota(tx *gorm.DB, user *User, n int) error {
+	if Quotas.MaxEntries == 0 || n <= 0 {
+		return nil
+	}
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Take(&Vault{}, user.Vault.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	var count int64
+	result = tx.Model(&VaultEntry{}).Where("vault_id = ?", user.Vault.ID).Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	return Quotas.checkGrowth(int(count), n)
+}
+
+// a Usage is what a vault holds against the Quotas
+type Usage struct {
+	Entries int
+	// EntryBytes is the total size of the encrypted entries, as clients sent them
+	EntryBytes int64
+	Quota      Quota
+}
+
+// VaultUsage measures a vault loaded along with its entries
+func VaultUsage(vault *Vault) Usage {
+	usage := Usage{Entries: len(vault.VaultEntries), Quota: Quotas}
+	for _, e := range vault.VaultEntries {
+		usage.EntryBytes += int64(len(e.EncryptedEntry))
+	}
+	return usage
+}
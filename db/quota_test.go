@@ -0,0 +1,43 @@
+package db_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func Test_VaultQuotaLocksVault(t *testing.T) {
+	db.Quotas = db.Quota{MaxEntries: 2}
+	t.Cleanup(func() { db.Quotas = db.Quota{} })
+
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mdb.Close()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mdb,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	// the vault is locked before its entries are counted
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT "id" FROM "vaults" WHERE "vaults"."id" = $1 AND "vaults"."deleted_at" IS NULL LIMIT 1 FOR UPDATE`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT count(1) FROM "vault_entries" WHERE vault_id = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	user := &db.User{Vault: db.Vault{ID: 1}}
+	_, err = db.CreateVaultEntry(context.Background(), gdb, user, []byte("three"), 1)
+	require.ErrorIs(t, err, db.ErrQuotaExceeded)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
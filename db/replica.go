@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrReplicaLagging = errors.New("replica is lagging behind the primary")
+
+// postgresLagQuery returns how many seconds a postgres replica is behind, an
+// idle primary leaves the replay timestamp behind without any lag
+const postgresLagQuery = `SELECT CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// ReplicaOptions tune how a GormStore routes reads to its replicas
+type ReplicaOptions struct {
+	// MaxLag is how far behind the primary a replica may be and still serve
+	// reads, a user's reads also go to the primary for this long after they
+	// wrote so that they see their own writes. defaults to 5s
+	MaxLag time.Duration
+	// CheckInterval is how often replicas are checked, defaults to 5s
+	CheckInterval time.Duration
+	// LagQuery returns how many seconds a replica is behind, it defaults to a
+	// postgres query on postgres while replicas of other databases are only pinged
+	LagQuery string
+}
+
+// a replica is a read only copy of the primary database
+type replica struct {
+	db *gorm.DB
+	// healthy is 1 while the replica may serve reads
+	healthy int32
+}
+
+// a replicaSet routes reads between the primary and its healthy replicas
+type replicaSet struct {
+	replicas []*replica
+	opts     ReplicaOptions
+	next     uint32
+
+	mu sync.Mutex
+	// when each user last wrote, by user ID
+	writes map[uint]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReplicatedGormStore returns a Store on primary that serves vault reads
+// from healthy replicas, which are checked in the background until Close
+func NewReplicatedGormStore(primary *gorm.DB, replicas []*gorm.DB, opts ReplicaOptions) *GormStore {
+	if opts.MaxLag == 0 {
+		opts.MaxLag = 5 * time.Second
+	}
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = 5 * time.Second
+	}
+	if opts.LagQuery == "" && primary.Dialector.Name() == "postgres" {
+		opts.LagQuery = postgresLagQuery
+	}
+
+	rs := &replicaSet{
+		opts:   opts,
+		writes: make(map[uint]time.Time),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, db := range replicas {
+		rs.replicas = append(rs.replicas, &replica{db: db})
+	}
+	rs.check()
+	go rs.run()
+	return &GormStore{db: primary, replicas: rs}
+}
+
+func (rs *replicaSet) run() {
+	defer close(rs.done)
+	ticker := time.NewTicker(rs.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.check()
+		case <-rs.stop:
+			return
+		}
+	}
+}
+
+func (rs *replicaSet) close() {
+	close(rs.stop)
+	<-rs.done
+}
+
+// check updates the health of every replica, and forgets writes old enough
+// for every healthy replica to have caught up with
+func (rs *replicaSet) check() {
+	for _, r := range rs.replicas {
+		healthy := int32(0)
+		if rs.checkReplica(r) == nil {
+			healthy = 1
+		}
+		atomic.StoreInt32(&r.healthy, healthy)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for id, t := range rs.writes {
+		if time.Since(t) > rs.opts.MaxLag {
+			delete(rs.writes, id)
+		}
+	}
+}
+
+// checkReplica fails when a replica is unreachable or lags too far behind
+func (rs *replicaSet) checkReplica(r *replica) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rs.opts.CheckInterval)
+	defer cancel()
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+	if rs.opts.LagQuery == "" {
+		return nil
+	}
+	var lag float64
+	if err := r.db.WithContext(ctx).Raw(rs.opts.LagQuery).Scan(&lag).Error; err != nil {
+		return err
+	}
+	if time.Duration(lag*float64(time.Second)) > rs.opts.MaxLag {
+		return fmt.Errorf("%w by %.1fs", ErrReplicaLagging, lag)
+	}
+	return nil
+}
+
+// wrote notes that a user wrote to the primary
+func (rs *replicaSet) wrote(user *User) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.writes[user.ID] = time.Now()
+}
+
+// pick returns a healthy replica to serve a read of user from, or nil when it
+// must go to the primary
+func (rs *replicaSet) pick(user *User) *replica {
+	rs.mu.Lock()
+	last, ok := rs.writes[user.ID]
+	rs.mu.Unlock()
+	if ok && time.Since(last) <= rs.opts.MaxLag {
+		return nil
+	}
+
+	n := uint32(len(rs.replicas))
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		r := rs.replicas[(start+i)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r
+		}
+	}
+	return nil
+}
+
+// read runs fn against a replica if one can serve user, and against the
+// primary when none can or the replica fails. a replica failing for any other
+// reason than the request being cancelled is taken out until its next check
+func (s *GormStore) read(ctx context.Context, user *User, fn func(db *gorm.DB) error) error {
+	if s.replicas != nil {
+		if r := s.replicas.pick(user); r != nil {
+			err := fn(r.db)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			// an entry missing on a replica may just not have arrived yet
+			if !errors.Is(err, ErrEntryNotFound) {
+				atomic.StoreInt32(&r.healthy, 0)
+			}
+		}
+	}
+	return fn(s.db)
+}
+
+// wrote sends the reads of user to the primary until replicas caught up
+func (s *GormStore) wrote(user *User) {
+	if s.replicas != nil {
+		s.replicas.wrote(user)
+	}
+}
+
+// Close stops checking the replicas of the store
+func (s *GormStore) Close() error {
+	if s.replicas != nil {
+		s.replicas.close()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package db_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/rokusei/gopass-server/db/storetest"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openMemoryDB(t *testing.T, name string) *gorm.DB {
+	gdb, err := db.Open("sqlite", "file:"+strings.ReplaceAll(name, "/", "-")+"?mode=memory&cache=shared", &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Migrate(gdb))
+	return gdb
+}
+
+func Test_ReplicatedGormStore(t *testing.T) {
+	// a replica of the same database is never behind
+	storetest.Run(t, func(t *testing.T) db.Store {
+		gdb := openMemoryDB(t, t.Name())
+		s := db.NewReplicatedGormStore(gdb, []*gorm.DB{gdb}, db.ReplicaOptions{})
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func Test_ReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	primary := openMemoryDB(t, t.Name()+"-primary")
+	replica := openMemoryDB(t, t.Name()+"-replica")
+	s := db.NewReplicatedGormStore(primary, []*gorm.DB{replica}, db.ReplicaOptions{
+		MaxLag:        100 * time.Millisecond,
+		CheckInterval: time.Hour,
+	})
+	defer s.Close()
+
+	user, err := s.CreateUser(ctx, "a@example.com", make([]byte, db.AuthHashSize), db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(t, err)
+	require.NoError(t, replica.Create(&db.VaultEntry{UUID: "on-replica", VaultID: user.Vault.ID}).Error)
+
+	// reads go to the replica
+	_, err = s.GetVaultEntry(ctx, user, "on-replica")
+	require.NoError(t, err)
+
+	// a user reads their own writes from the primary
+	entry, err := s.CreateVaultEntry(ctx, user, []byte("one"), 1)
+	require.NoError(t, err)
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, vault.VaultEntries, 1)
+	require.Equal(t, entry.UUID, vault.VaultEntries[0].UUID)
+
+	// then from the replica again, falling back to the primary for entries
+	// the replica doesn't have yet
+	time.Sleep(150 * time.Millisecond)
+	vault, err = s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, vault.VaultEntries, 1)
+	require.Equal(t, "on-replica", vault.VaultEntries[0].UUID)
+	_, err = s.GetVaultEntry(ctx, user, entry.UUID)
+	require.NoError(t, err)
+
+	// a failing replica is taken out
+	sqlDB, err := replica.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+	for i := 0; i < 2; i++ {
+		vault, err = s.GetVault(ctx, user)
+		require.NoError(t, err)
+		require.Len(t, vault.VaultEntries, 1)
+		require.Equal(t, entry.UUID, vault.VaultEntries[0].UUID)
+	}
+}
+
+func Test_ReplicaLag(t *testing.T) {
+	ctx := context.Background()
+	primary := openMemoryDB(t, t.Name()+"-primary")
+	replica := openMemoryDB(t, t.Name()+"-replica")
+	s := db.NewReplicatedGormStore(primary, []*gorm.DB{replica}, db.ReplicaOptions{
+		MaxLag:        time.Second,
+		CheckInterval: time.Hour,
+		LagQuery:      "SELECT 30",
+	})
+	defer s.Close()
+
+	user, err := s.CreateUser(ctx, "a@example.com", make([]byte, db.AuthHashSize), db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(t, err)
+	require.NoError(t, replica.Create(&db.VaultEntry{UUID: "on-replica", VaultID: user.Vault.ID}).Error)
+
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Empty(t, vault.VaultEntries)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+var ErrSessionExpired = errors.New("session expired")
+
+const SessionTokenSize = 32
+const SessionLifetime = 12 * time.Hour
+
+// a Session lets a user authenticate requests with a random bearer token
+// instead of sending their AuthenticationHash every time
+// only a SHA256 hash of the token is stored
+type Session struct {
+	gorm.Model
+	ID        uint      `gorm:"primarykey" json:"-"`
+	TokenHash string    `gorm:"uniqueIndex" json:"-"`
+	UserID    uint      `json:"-"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+	Token     string    `gorm:"-" json:"Token,omitempty"`
+}
+
+// CreateSession starts a new session for an already authenticated user
+// the plaintext token is only available on the returned Session
+func CreateSession(ctx context.Context, db *gorm.DB, user *User) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session, err := newSession(user)
+	if err != nil {
+		return nil, err
+	}
+	result := db.Create(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return session, nil
+}
+
+// newSession generates the token of a new session
+func newSession(user *User) (*Session, error) {
+	b := make([]byte, SessionTokenSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+
+	return &Session{
+		TokenHash: StringToEncodedHash(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(SessionLifetime),
+		Token:     token,
+	}, nil
+}
+
+// GetSessionUser fetches the user a session token belongs to, along with their vault
+func GetSessionUser(ctx context.Context, db *gorm.DB, token string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session := Session{}
+	result := db.Where("token_hash = ?", StringToEncodedHash(token)).Limit(1).Find(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if session.TokenHash == "" {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	user := User{}
+	result = db.Limit(1).Find(&user, session.UserID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if user.EmailHash == "" {
+		return nil, ErrUserDoesNotExist
+	}
+
+	err := loadVault(db, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteUserSessions ends every session of user but the one of the token keep,
+// which may be empty to end them all
+func DeleteUserSessions(ctx context.Context, db *gorm.DB, user *User, keep string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	query := db.WithContext(ctx).Where("user_id = ?", user.ID)
+	if keep != "" {
+		query = query.Where("token_hash <> ?", StringToEncodedHash(keep))
+	}
+	return query.Delete(&Session{}).Error
+}
+
+// DeleteSession ends a session, the token can not be used afterwards
+func DeleteSession(ctx context.Context, db *gorm.DB, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	result := db.Where("token_hash = ?", StringToEncodedHash(token)).Delete(&Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
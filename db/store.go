@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// a Store persists users, their vaults and vault entries, and sessions
+// every implementation must pass the storetest conformance suite
+type Store interface {
+	// CreateUser registers a user along with an empty vault
+	CreateUser(ctx context.Context, email string, authenticationHash []byte, kdf KDF) (*User, error)
+	// GetUser authenticates a user, returning them with their vault and its entries
+	GetUser(ctx context.Context, email string, authenticationHash []byte) (*User, error)
+	// GetVerifiedUser authenticates a verified user, returning them with their vault
+	GetVerifiedUser(ctx context.Context, email string, authenticationHash []byte) (*User, error)
+	// GetKDF returns the KDF parameters of a user, or ones made up with key
+	GetKDF(ctx context.Context, key []byte, email string) (*KDF, error)
+	// UpdateUserKDF changes the KDF parameters and AuthenticationHash of a user
+	// along with every vault entry, which ops must re-encrypt
+	UpdateUserKDF(ctx context.Context, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error
+
+	// CreateSession starts a session for an authenticated user
+	CreateSession(ctx context.Context, user *User) (*Session, error)
+	// GetSessionUser returns the user of a session, with their vault
+	GetSessionUser(ctx context.Context, token string) (*User, error)
+	// DeleteSession ends a session
+	DeleteSession(ctx context.Context, token string) error
+	// DeleteUserSessions ends every session of user but the one of keep
+	DeleteUserSessions(ctx context.Context, user *User, keep string) error
+
+	// GetVault loads the entries of a user's vault
+	GetVault(ctx context.Context, user *User) (*Vault, error)
+	// CheckSchemaVersion fails with ErrSchemaVersionTooNew when the vault holds
+	// entries of a newer schema version than supported
+	CheckSchemaVersion(ctx context.Context, user *User, supported uint) error
+
+	GetVaultEntry(ctx context.Context, user *User, entryUUID string) (*VaultEntry, error)
+	CreateVaultEntry(ctx context.Context, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error)
+	UpdateVaultEntry(ctx context.Context, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error)
+	DeleteVaultEntry(ctx context.Context, user *User, entryUUID string) error
+	// ApplyVaultEntryBatch applies every operation or none of them
+	ApplyVaultEntryBatch(ctx context.Context, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error)
+}
+
+// GormStore is a Store on a SQL database
+type GormStore struct {
+	db *gorm.DB
+	// replicas serve vault reads when set
+	replicas *replicaSet
+}
+
+// NewGormStore returns a Store on db, whose tables must already be migrated
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// DB returns the primary database of the store
+func (s *GormStore) DB() *gorm.DB {
+	return s.db
+}
+
+func (s *GormStore) CreateUser(ctx context.Context, email string, authenticationHash []byte, kdf KDF) (*User, error) {
+	return CreateUser(ctx, s.db, email, authenticationHash, kdf)
+}
+
+func (s *GormStore) GetUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	return GetUser(ctx, s.db, email, authenticationHash)
+}
+
+func (s *GormStore) GetVerifiedUser(ctx context.Context, email string, authenticationHash []byte) (*User, error) {
+	return GetVerifiedUser(ctx, s.db, email, authenticationHash)
+}
+
+func (s *GormStore) GetKDF(ctx context.Context, key []byte, email string) (*KDF, error) {
+	return GetKDF(ctx, s.db, key, email)
+}
+
+func (s *GormStore) UpdateUserKDF(ctx context.Context, user *User, kdf KDF, authenticationHash []byte, ops []BatchOperation, schemaVersion uint) error {
+	defer s.wrote(user)
+	return UpdateUserKDF(ctx, s.db, user, kdf, authenticationHash, ops, schemaVersion)
+}
+
+func (s *GormStore) CreateSession(ctx context.Context, user *User) (*Session, error) {
+	return CreateSession(ctx, s.db, user)
+}
+
+func (s *GormStore) GetSessionUser(ctx context.Context, token string) (*User, error) {
+	return GetSessionUser(ctx, s.db, token)
+}
+
+func (s *GormStore) DeleteSession(ctx context.Context, token string) error {
+	return DeleteSession(ctx, s.db, token)
+}
+
+func (s *GormStore) DeleteUserSessions(ctx context.Context, user *User, keep string) error {
+	return DeleteUserSessions(ctx, s.db, user, keep)
+}
+
+func (s *GormStore) GetVault(ctx context.Context, user *User) (*Vault, error) {
+	var vault *Vault
+	err := s.read(ctx, user, func(db *gorm.DB) error {
+		var err error
+		vault, err = GetVault(ctx, db, user)
+		return err
+	})
+	return vault, err
+}
+
+func (s *GormStore) CheckSchemaVersion(ctx context.Context, user *User, supported uint) error {
+	return CheckSchemaVersion(ctx, s.db, user, supported)
+}
+
+func (s *GormStore) GetVaultEntry(ctx context.Context, user *User, entryUUID string) (*VaultEntry, error) {
+	var entry *VaultEntry
+	err := s.read(ctx, user, func(db *gorm.DB) error {
+		var err error
+		entry, err = GetVaultEntry(ctx, db, user, entryUUID)
+		return err
+	})
+	return entry, err
+}
+
+func (s *GormStore) CreateVaultEntry(ctx context.Context, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	defer s.wrote(user)
+	return CreateVaultEntry(ctx, s.db, user, encryptedEntry, schemaVersion)
+}
+
+func (s *GormStore) UpdateVaultEntry(ctx context.Context, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	defer s.wrote(user)
+	return UpdateVaultEntry(ctx, s.db, user, entryUUID, encryptedEntry, schemaVersion)
+}
+
+func (s *GormStore) DeleteVaultEntry(ctx context.Context, user *User, entryUUID string) error {
+	defer s.wrote(user)
+	return DeleteVaultEntry(ctx, s.db, user, entryUUID)
+}
+
+func (s *GormStore) ApplyVaultEntryBatch(ctx context.Context, user *User, ops []BatchOperation, schemaVersion uint) ([]BatchResult, error) {
+	defer s.wrote(user)
+	return ApplyVaultEntryBatch(ctx, s.db, user, ops, schemaVersion)
+}
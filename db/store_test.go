@@ -0,0 +1,141 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/rokusei/gopass-server/db/storetest"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_MemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return db.NewMemoryStore()
+	})
+}
+
+func Test_GormStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		name := strings.ReplaceAll(t.Name(), "/", "-")
+		gdb, err := db.Open("sqlite", "file:"+name+"?mode=memory&cache=shared", &gorm.Config{
+			Logger: logger.Discard,
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Migrate(gdb))
+		return db.NewGormStore(gdb)
+	})
+}
+
+func Test_BoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		s, err := db.OpenBoltStore(filepath.Join(t.TempDir(), "gopass.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func Test_BoltStoreBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := db.OpenBoltStore(filepath.Join(dir, "gopass.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	authHash := make([]byte, db.AuthHashSize)
+	user, err := s.CreateUser(ctx, "a@example.com", authHash, db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(t, err)
+	_, err = s.CreateVaultEntry(ctx, user, []byte("one"), 1)
+	require.NoError(t, err)
+
+	// backups are taken while the store stays open
+	backup := filepath.Join(dir, "backup.db")
+	require.NoError(t, s.BackupFile(backup))
+	_, err = s.CreateVaultEntry(ctx, user, []byte("two"), 1)
+	require.NoError(t, err)
+
+	restored, err := db.OpenBoltStore(backup)
+	require.NoError(t, err)
+	defer restored.Close()
+	user, err = restored.GetUser(ctx, "a@example.com", authHash)
+	require.NoError(t, err)
+	require.Len(t, user.Vault.VaultEntries, 1)
+	require.Equal(t, []byte("one"), user.Vault.VaultEntries[0].EncryptedEntry)
+}
+
+// indexLegacy moves the users of emails in the bolt file at path back to the
+// legacy hash of their email, as an older server indexed them
+func indexLegacy(t *testing.T, path string, emails ...string) {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	require.NoError(t, err)
+	defer bdb.Close()
+	require.NoError(t, bdb.Update(func(tx *bolt.Tx) error {
+		index, users := tx.Bucket([]byte("emails")), tx.Bucket([]byte("users"))
+		for _, email := range emails {
+			id := index.Get([]byte(db.EmailHash(email)))
+			require.NotNil(t, id)
+			var user db.User
+			require.NoError(t, gob.NewDecoder(bytes.NewReader(users.Get(id))).Decode(&user))
+			user.EmailHash = db.StringToEncodedHash(email)
+			var buf bytes.Buffer
+			require.NoError(t, gob.NewEncoder(&buf).Encode(&user))
+			require.NoError(t, users.Put(id, buf.Bytes()))
+			require.NoError(t, index.Delete([]byte(db.EmailHash(email))))
+			require.NoError(t, index.Put([]byte(user.EmailHash), id))
+		}
+		return nil
+	}))
+}
+
+func Test_BoltStoreReindex(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gopass.db")
+	authHash := make([]byte, db.AuthHashSize)
+	kdf := db.DefaultKDF(make([]byte, db.KDFSaltSize))
+
+	s, err := db.OpenBoltStore(path)
+	require.NoError(t, err)
+	for _, email := range []string{"A@example.com", "b@example.com"} {
+		_, err = s.CreateUser(ctx, email, authHash, kdf)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	indexLegacy(t, path, "A@example.com", "b@example.com")
+
+	db.EmailKey = []byte("email key")
+	t.Cleanup(func() { db.EmailKey = nil })
+	s, err = db.OpenBoltStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// legacy users are found by their email as typed at registration
+	_, err = s.CreateUser(ctx, "A@example.com", authHash, kdf)
+	require.Equal(t, db.ErrUserAlreadyExists, err)
+	_, err = s.GetUser(ctx, "a@example.com", authHash)
+	require.Equal(t, db.ErrUserDoesNotExist, err)
+	got, err := s.GetKDF(ctx, nil, "A@example.com")
+	require.NoError(t, err)
+	require.Equal(t, kdf, *got)
+
+	// and moved to the keyed hash when they log in
+	_, err = s.GetUser(ctx, "A@example.com", authHash)
+	require.NoError(t, err)
+	_, err = s.GetUser(ctx, "a@example.com", authHash)
+	require.NoError(t, err)
+
+	reindexed, conflicts, err := s.ReindexUsers(ctx, []string{"A@example.com", "b@example.com", "nobody@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, 1, reindexed)
+	require.Empty(t, conflicts)
+	_, err = s.GetUser(ctx, " B@example.com", authHash)
+	require.NoError(t, err)
+}
@@ -0,0 +1,406 @@
+// Package storetest provides the conformance suite every db.Store must pass
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the suite, newStore must return an empty store for every test
+func Run(t *testing.T, newStore func(t *testing.T) db.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s db.Store)
+	}{
+		{"Users", testUsers},
+		{"KDF", testKDF},
+		{"Sessions", testSessions},
+		{"Entries", testEntries},
+		{"Batch", testBatch},
+		{"SchemaVersion", testSchemaVersion},
+		{"UpdateUserKDF", testUpdateUserKDF},
+		{"Quota", testQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+
+	// stores keeping data keys must keep them too
+	t.Run("DataKeys", func(t *testing.T) {
+		s, ok := newStore(t).(db.DataKeyStore)
+		if !ok {
+			t.Skip("not a db.DataKeyStore")
+		}
+		testDataKeys(t, s)
+	})
+}
+
+// authHash returns a valid AuthenticationHash unique to s
+func authHash(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return bytes.Repeat(h[:], db.AuthHashSize/len(h))
+}
+
+// salt returns a valid KDF salt unique to s
+func salt(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return h[:db.KDFSaltSize]
+}
+
+func createUser(t *testing.T, s db.Store, email string) *db.User {
+	user, err := s.CreateUser(context.Background(), email, authHash(email), db.DefaultKDF(salt(email)))
+	require.NoError(t, err)
+	return user
+}
+
+// createEntries creates an entry for every blob in a single batch
+func createEntries(t *testing.T, s db.Store, user *db.User, blobs ...string) []db.VaultEntry {
+	ops := make([]db.BatchOperation, 0, len(blobs))
+	for _, blob := range blobs {
+		ops = append(ops, db.BatchOperation{Op: db.BatchCreate, EncryptedEntry: []byte(blob)})
+	}
+	results, err := s.ApplyVaultEntryBatch(context.Background(), user, ops, 1)
+	require.NoError(t, err)
+
+	entries := make([]db.VaultEntry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, *r.Entry)
+	}
+	return entries
+}
+
+func testUsers(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	created := createUser(t, s, "a@example.com")
+	require.NotEmpty(t, created.UUID)
+	require.NotEmpty(t, created.Vault.UUID)
+
+	_, err := s.CreateUser(ctx, "A@Example.com ", authHash("other"), db.DefaultKDF(salt("salt")))
+	require.Equal(t, db.ErrUserAlreadyExists, err)
+	_, err = s.CreateUser(ctx, "b@example.com", []byte("short"), db.DefaultKDF(salt("salt")))
+	require.Equal(t, db.ErrInvalidAuthHash, err)
+	_, err = s.CreateUser(ctx, "b@example.com", authHash("b"), db.KDF{Algorithm: "md5"})
+	require.ErrorIs(t, err, db.ErrInvalidKDF)
+
+	user, err := s.GetUser(ctx, "a@example.com", authHash("a@example.com"))
+	require.NoError(t, err)
+	require.Equal(t, created.UUID, user.UUID)
+	require.Equal(t, created.Vault.UUID, user.Vault.UUID)
+	require.Empty(t, user.Vault.VaultEntries)
+
+	_, err = s.GetUser(ctx, "a@example.com", authHash("wrong"))
+	require.Error(t, err)
+	_, err = s.GetUser(ctx, "nobody@example.com", authHash("nobody@example.com"))
+	require.Equal(t, db.ErrUserDoesNotExist, err)
+	_, err = s.GetVerifiedUser(ctx, "a@example.com", authHash("a@example.com"))
+	require.Equal(t, db.ErrUserNotVerified, err)
+}
+
+func testKDF(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	key := []byte("prelogin key")
+	createUser(t, s, "a@example.com")
+
+	kdf, err := s.GetKDF(ctx, key, "a@example.com")
+	require.NoError(t, err)
+	require.Equal(t, db.DefaultKDF(salt("a@example.com")), *kdf)
+
+	// unknown emails get made up parameters, the same every time
+	fake, err := s.GetKDF(ctx, key, "nobody@example.com")
+	require.NoError(t, err)
+	require.NoError(t, db.ValidateKDF(*fake))
+	again, err := s.GetKDF(ctx, key, "Nobody@example.com")
+	require.NoError(t, err)
+	require.Equal(t, fake, again)
+
+	// some look like those of users who haven't upgraded from LegacyKDF
+	shapes := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		fake, err := s.GetKDF(ctx, key, fmt.Sprintf("nobody%d@example.com", i))
+		require.NoError(t, err)
+		if fake.Algorithm == db.KDFPBKDF2 {
+			require.Equal(t, db.LegacyKDF(), *fake)
+		}
+		shapes[fake.Algorithm]++
+	}
+	require.NotZero(t, shapes[db.KDFPBKDF2])
+	require.Greater(t, shapes[db.KDFArgon2id], shapes[db.KDFPBKDF2])
+}
+
+func testSessions(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+
+	session, err := s.CreateSession(ctx, user)
+	require.NoError(t, err)
+	require.NotEmpty(t, session.Token)
+
+	sessionUser, err := s.GetSessionUser(ctx, session.Token)
+	require.NoError(t, err)
+	require.Equal(t, user.UUID, sessionUser.UUID)
+	require.Equal(t, user.Vault.UUID, sessionUser.Vault.UUID)
+
+	_, err = s.GetSessionUser(ctx, "unknown")
+	require.Equal(t, db.ErrSessionNotFound, err)
+
+	require.NoError(t, s.DeleteSession(ctx, session.Token))
+	_, err = s.GetSessionUser(ctx, session.Token)
+	require.Equal(t, db.ErrSessionNotFound, err)
+	require.Equal(t, db.ErrSessionNotFound, s.DeleteSession(ctx, session.Token))
+
+	// ending the other sessions of a user leaves those of other users alone
+	other := createUser(t, s, "b@example.com")
+	var tokens []string
+	for _, u := range []*db.User{user, user, user, other} {
+		session, err := s.CreateSession(ctx, u)
+		require.NoError(t, err)
+		tokens = append(tokens, session.Token)
+	}
+	require.NoError(t, s.DeleteUserSessions(ctx, user, tokens[0]))
+	_, err = s.GetSessionUser(ctx, tokens[0])
+	require.NoError(t, err)
+	for _, token := range tokens[1:3] {
+		_, err = s.GetSessionUser(ctx, token)
+		require.Equal(t, db.ErrSessionNotFound, err)
+	}
+	_, err = s.GetSessionUser(ctx, tokens[3])
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteUserSessions(ctx, user, ""))
+	_, err = s.GetSessionUser(ctx, tokens[0])
+	require.Equal(t, db.ErrSessionNotFound, err)
+}
+
+func testEntries(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+	other := createUser(t, s, "b@example.com")
+
+	entry, err := s.CreateVaultEntry(ctx, user, []byte("one"), 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, entry.UUID)
+	require.Equal(t, []byte("one"), entry.EncryptedEntry)
+	require.Equal(t, uint(1), entry.SchemaVersion)
+
+	entries := createEntries(t, s, user, "two", "three")
+	require.Len(t, entries, 2)
+
+	got, err := s.GetVaultEntry(ctx, user, entry.UUID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), got.EncryptedEntry)
+
+	// entries are scoped to the vault of their user
+	_, err = s.GetVaultEntry(ctx, other, entry.UUID)
+	require.Equal(t, db.ErrEntryNotFound, err)
+	_, err = s.UpdateVaultEntry(ctx, other, entry.UUID, []byte("stolen"), 1)
+	require.Equal(t, db.ErrEntryNotFound, err)
+	require.Equal(t, db.ErrEntryNotFound, s.DeleteVaultEntry(ctx, other, entry.UUID))
+
+	updated, err := s.UpdateVaultEntry(ctx, user, entry.UUID, []byte("uno"), 2)
+	require.NoError(t, err)
+	require.Equal(t, entry.UUID, updated.UUID)
+	require.Equal(t, []byte("uno"), updated.EncryptedEntry)
+	require.Equal(t, uint(2), updated.SchemaVersion)
+
+	require.NoError(t, s.DeleteVaultEntry(ctx, user, entries[0].UUID))
+	_, err = s.GetVaultEntry(ctx, user, entries[0].UUID)
+	require.Equal(t, db.ErrEntryNotFound, err)
+	require.Equal(t, db.ErrEntryNotFound, s.DeleteVaultEntry(ctx, user, entries[0].UUID))
+
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("uno"), []byte("three")}, encryptedEntries(vault))
+
+	vault, err = s.GetVault(ctx, other)
+	require.NoError(t, err)
+	require.Empty(t, vault.VaultEntries)
+}
+
+func testBatch(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+	entry, err := s.CreateVaultEntry(ctx, user, []byte("one"), 1)
+	require.NoError(t, err)
+
+	results, err := s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{
+		{Op: db.BatchCreate, EncryptedEntry: []byte("two")},
+		{Op: db.BatchUpdate, ID: entry.UUID, EncryptedEntry: []byte("uno")},
+		{Op: db.BatchDelete, ID: "missing"},
+	}, 1)
+	require.ErrorIs(t, err, db.ErrBatchFailed)
+	require.Len(t, results, 3)
+	require.Equal(t, db.ErrBatchRolledBack.Error(), results[0].Error)
+	require.Equal(t, db.ErrEntryNotFound.Error(), results[2].Error)
+
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("one")}, encryptedEntries(vault))
+
+	results, err = s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{
+		{Op: db.BatchCreate, EncryptedEntry: []byte("two")},
+		{Op: db.BatchUpdate, ID: entry.UUID, EncryptedEntry: []byte("uno")},
+	}, 1)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, []byte("two"), results[0].Entry.EncryptedEntry)
+
+	results, err = s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{
+		{Op: db.BatchDelete, ID: results[0].Entry.UUID},
+	}, 1)
+	require.NoError(t, err)
+	require.Nil(t, results[0].Entry)
+
+	vault, err = s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("uno")}, encryptedEntries(vault))
+
+	_, err = s.ApplyVaultEntryBatch(ctx, user, make([]db.BatchOperation, db.MaxBatchOperations+1), 1)
+	require.Equal(t, db.ErrBatchTooLarge, err)
+}
+
+func testSchemaVersion(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+	require.NoError(t, s.CheckSchemaVersion(ctx, user, 0))
+
+	_, err := s.CreateVaultEntry(ctx, user, []byte("one"), 2)
+	require.NoError(t, err)
+	require.NoError(t, s.CheckSchemaVersion(ctx, user, 2))
+	require.Equal(t, db.ErrSchemaVersionTooNew, s.CheckSchemaVersion(ctx, user, 1))
+}
+
+func testUpdateUserKDF(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+	entries := createEntries(t, s, user, "one", "two")
+
+	kdf := db.DefaultKDF(salt("new salt"))
+	newAuthHash := authHash("new password")
+
+	// every entry has to be re-encrypted
+	err := s.UpdateUserKDF(ctx, user, kdf, newAuthHash, []db.BatchOperation{
+		{Op: db.BatchUpdate, ID: entries[0].UUID, EncryptedEntry: []byte("rekeyed one")},
+	}, 1)
+	require.ErrorIs(t, err, db.ErrIncompleteRekey)
+	_, err = s.GetUser(ctx, "a@example.com", authHash("a@example.com"))
+	require.NoError(t, err)
+
+	var ops []db.BatchOperation
+	for _, e := range entries {
+		ops = append(ops, db.BatchOperation{Op: db.BatchUpdate, ID: e.UUID, EncryptedEntry: []byte(fmt.Sprintf("rekeyed %s", e.EncryptedEntry))})
+	}
+	require.NoError(t, s.UpdateUserKDF(ctx, user, kdf, newAuthHash, ops, 1))
+
+	_, err = s.GetUser(ctx, "a@example.com", authHash("a@example.com"))
+	require.Error(t, err)
+	user, err = s.GetUser(ctx, "a@example.com", newAuthHash)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("rekeyed one"), []byte("rekeyed two")}, encryptedEntries(&user.Vault))
+
+	got, err := s.GetKDF(ctx, []byte("prelogin key"), "a@example.com")
+	require.NoError(t, err)
+	require.Equal(t, kdf, *got)
+}
+
+func testQuota(t *testing.T, s db.Store) {
+	db.Quotas = db.Quota{MaxEntries: 2}
+	t.Cleanup(func() { db.Quotas = db.Quota{} })
+
+	ctx := context.Background()
+	user := createUser(t, s, "a@example.com")
+	_, err := s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{
+		{Op: db.BatchCreate, EncryptedEntry: []byte("one")},
+		{Op: db.BatchCreate, EncryptedEntry: []byte("two")},
+		{Op: db.BatchCreate, EncryptedEntry: []byte("three")},
+	}, 1)
+	require.ErrorIs(t, err, db.ErrQuotaExceeded)
+	entry, err := s.CreateVaultEntry(ctx, user, []byte("one"), 1)
+	require.NoError(t, err)
+	createEntries(t, s, user, "two")
+
+	_, err = s.CreateVaultEntry(ctx, user, []byte("three"), 1)
+	require.ErrorIs(t, err, db.ErrQuotaExceeded)
+	_, err = s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{{Op: db.BatchCreate, EncryptedEntry: []byte("three")}}, 1)
+	require.ErrorIs(t, err, db.ErrQuotaExceeded)
+
+	// full vaults can still be changed, and replace entries
+	_, err = s.UpdateVaultEntry(ctx, user, entry.UUID, []byte("uno"), 1)
+	require.NoError(t, err)
+	_, err = s.ApplyVaultEntryBatch(ctx, user, []db.BatchOperation{
+		{Op: db.BatchDelete, ID: entry.UUID},
+		{Op: db.BatchCreate, EncryptedEntry: []byte("three")},
+	}, 1)
+	require.NoError(t, err)
+
+	vault, err := s.GetVault(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("two"), []byte("three")}, encryptedEntries(vault))
+	usage := db.VaultUsage(vault)
+	require.Equal(t, 2, usage.Entries)
+	require.Equal(t, int64(8), usage.EntryBytes)
+}
+
+func encryptedEntries(vault *db.Vault) [][]byte {
+	var blobs [][]byte
+	for _, e := range vault.VaultEntries {
+		blobs = append(blobs, e.EncryptedEntry)
+	}
+	return blobs
+}
+
+func testDataKeys(t *testing.T, s db.DataKeyStore) {
+	ctx := context.Background()
+	a := createUser(t, s, "a@example.com")
+	b := createUser(t, s, "b@example.com")
+
+	_, err := s.GetDataKey(ctx, a.Vault.ID)
+	require.ErrorIs(t, err, db.ErrDataKeyNotFound)
+	for _, user := range []*db.User{a, b} {
+		require.NoError(t, s.CreateDataKey(ctx, &db.DataKey{VaultID: user.Vault.ID, MasterKeyID: "old", WrappedKey: []byte(user.UUID)}))
+	}
+	require.Error(t, s.CreateDataKey(ctx, &db.DataKey{VaultID: a.Vault.ID, MasterKeyID: "old", WrappedKey: []byte("again")}))
+
+	key, err := s.GetDataKey(ctx, a.Vault.ID)
+	require.NoError(t, err)
+	require.Equal(t, "old", key.MasterKeyID)
+	require.Equal(t, []byte(a.UUID), key.WrappedKey)
+
+	// a failing rewrap changes nothing
+	_, err = s.RewrapDataKeys(ctx, func(key *db.DataKey) (bool, error) {
+		if key.VaultID == b.Vault.ID {
+			return false, fmt.Errorf("failed")
+		}
+		key.MasterKeyID = "new"
+		return true, nil
+	})
+	require.Error(t, err)
+	key, err = s.GetDataKey(ctx, a.Vault.ID)
+	require.NoError(t, err)
+	require.Equal(t, "old", key.MasterKeyID)
+
+	n, err := s.RewrapDataKeys(ctx, func(key *db.DataKey) (bool, error) {
+		if key.VaultID == b.Vault.ID {
+			return false, nil
+		}
+		key.MasterKeyID = "new"
+		key.WrappedKey = append(key.WrappedKey, '!')
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	key, err = s.GetDataKey(ctx, a.Vault.ID)
+	require.NoError(t, err)
+	require.Equal(t, "new", key.MasterKeyID)
+	require.Equal(t, []byte(a.UUID+"!"), key.WrappedKey)
+	key, err = s.GetDataKey(ctx, b.Vault.ID)
+	require.NoError(t, err)
+	require.Equal(t, "old", key.MasterKeyID)
+}
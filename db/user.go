@@ -10,7 +10,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rokusei/gopass-server/db/passhash"
 	"gorm.io/gorm"
 )
 
@@ -22,22 +22,34 @@ var ErrInvalidAuthHash = errors.New("invalid AuthenticationHash")
 const GenerateUUIDRetries = 10
 const AuthHashSize = 64
 
+// PasswordHash is the policy AuthenticationHashes are hashed with for storage,
+// hashes made with another algorithm or weaker parameters are replaced when
+// their users next authenticate
+var PasswordHash = passhash.Default()
+
+// Peppers are the server secrets AuthenticationHashes are peppered with before
+// they are hashed, hashes of other peppers are rehashed like weaker hashes
+var Peppers passhash.Peppers
+
 // a User is identified by an ID or Email
 // their identity is verified by verifying a hash of their AuthenticationHash
 // which is originally derived from their EncryptionKeyHash and MasterPassword
 type User struct {
 	gorm.Model
-	ID           uint         `gorm:"primarykey" json:"-"`
-	UUID         string       `json:"ID"`
-	EmailHash    string       `json:"-"`
-	AuthHashHash []byte       `json:"-"`
-	Verification Verification `gorm:"embedded"`
-	VaultID      uint         `json:"-"`
-	Vault        Vault
+	ID           uint   `gorm:"primarykey" json:"-"`
+	UUID         string `gorm:"uniqueIndex" json:"ID"`
+	EmailHash    string `gorm:"uniqueIndex" json:"-"`
+	AuthHashHash []byte `json:"-"`
+	// AuthHashPepper is the ID of the pepper AuthHashHash was made with, if any
+	AuthHashPepper string       `json:"-"`
+	KDF            KDF          `gorm:"embedded;embeddedPrefix:kdf_"`
+	Verification   Verification `gorm:"embedded;embeddedPrefix:verification_"`
+	VaultID        uint         `json:"-"`
+	Vault          Vault
 }
 
+// a Verification tracks whether a user confirmed their email
 type Verification struct {
-	gorm.Model
 	Hash      string `json:"-"`
 	Completed bool   `gorm:"default:false"`
 	Attempts  uint   `gorm:"default:0"`
@@ -55,32 +67,56 @@ func GenerateUUID() (string, error) {
 	return h, nil
 }
 
-// CreateUser generates a bcrypt hash of their AuthenticationHash
+// CreateUser hashes their AuthenticationHash according to PasswordHash
 // and creates a new vault for them in the database
 // Note: This hash doesn't require a salt for two reasons:
 //      1) the AuthenticationHash has a large random salt already prepended
-//      2) we're hashing a 101,102 iteration PBKDF2 hash, so good luck creating a rainbow table of those
-func CreateUser(ctx context.Context, db *gorm.DB, email string, authenticationHash []byte) (*User, error) {
+//      2) we're hashing the output of a slow KDF, so good luck creating a rainbow table of those
+// kdf describes how the user derives their keys, see ValidateKDF
+func CreateUser(ctx context.Context, db *gorm.DB, email string, authenticationHash []byte, kdf KDF) (*User, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	// check if authHash is right size
-	if len(authenticationHash) != AuthHashSize {
-		return nil, ErrInvalidAuthHash
+	err := validateNewUser(authenticationHash, kdf)
+	if err != nil {
+		return nil, err
 	}
 
-	// check if user with this email exists
-	emailHash := StringToEncodedHash(email)
-	u := User{}
-	result := db.Debug().Where("email_hash = ?", emailHash).Limit(1).Find(&u)
-	if result.Error != nil {
-		return nil, result.Error
+	db = db.WithContext(ctx)
+	// check if user with this email exists, under either hash
+	u, _, err := findUserByEmail(db, email)
+	if err != nil {
+		return nil, err
 	}
 	if u.EmailHash != "" {
 		return nil, ErrUserAlreadyExists
 	}
 
+	user, err := newUser(email, authenticationHash, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the user along with their vault, in one transaction
+	result := db.Create(user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return user, nil
+}
+
+// validateNewUser checks the credentials a user registers with
+func validateNewUser(authenticationHash []byte, kdf KDF) error {
+	// check if authHash is right size
+	if len(authenticationHash) != AuthHashSize {
+		return ErrInvalidAuthHash
+	}
+	return ValidateKDF(kdf)
+}
+
+// newUser builds a user and their empty vault, ready to be stored
+func newUser(email string, authenticationHash []byte, kdf KDF) (*User, error) {
 	uuid, err := GenerateUUID()
 	if err != nil {
 		return nil, err
@@ -100,14 +136,18 @@ func CreateUser(ctx context.Context, db *gorm.DB, email string, authenticationHa
 	}
 	i := binary.BigEndian.Uint64(b)
 	vc := (100000) + (i % 900000)
-	fmt.Printf("%v", vc)
-
-	// generate hash of the users authenticationHash using MaxCost
-	authHashHash, _ := bcrypt.GenerateFromPassword(authenticationHash, bcrypt.DefaultCost)
-	user := User{
-		UUID:         uuid,
-		EmailHash:    emailHash,
-		AuthHashHash: authHashHash,
+
+	// generate hash of the users authenticationHash
+	authHashHash, pepper, err := hashAuthHash(authenticationHash)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		UUID:           uuid,
+		EmailHash:      EmailHash(email),
+		AuthHashHash:   authHashHash,
+		AuthHashPepper: pepper,
+		KDF:            kdf,
 		Verification: Verification{
 			Hash: StringToEncodedHash(fmt.Sprintf("%v", vc)),
 		},
@@ -115,15 +155,7 @@ func CreateUser(ctx context.Context, db *gorm.DB, email string, authenticationHa
 			UUID:         vuuid,
 			VaultEntries: make([]VaultEntry, 0),
 		},
-	}
-
-	// create user
-	result = db.Debug().Create(&user)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	db.Debug().Save(&user)
-	return &user, nil
+	}, nil
 }
 
 // Fetch a reference to a user, requires authentication of the user's AuthenticationHash
@@ -138,12 +170,12 @@ func GetUser(ctx context.Context, db *gorm.DB, email string, authenticationHash
 		return nil, err
 	}
 
-	err = db.Model(&user).Association("Vault").Find(&user.Vault)
+	err = loadVault(db.WithContext(ctx), user)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Debug().Model(&user.Vault).Association("VaultEntries").Find(&user.Vault.VaultEntries)
+	_, err = GetVault(ctx, db, user)
 	if err != nil {
 		return nil, err
 	}
@@ -161,8 +193,14 @@ func GetVerifiedUser(ctx context.Context, db *gorm.DB, email string, authenticat
 	if err != nil {
 		return nil, err
 	}
-	if user.Verification.Hash != "" && !user.Verification.Completed {
-		return nil, ErrUserNotVerified
+	err = checkVerified(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadVault(db.WithContext(ctx), user)
+	if err != nil {
+		return nil, err
 	}
 	return user, nil
 }
@@ -172,24 +210,93 @@ func AuthenticateUser(ctx context.Context, db *gorm.DB, email string, authentica
 		return nil, err
 	}
 
+	db = db.WithContext(ctx)
 	// Validate email, fetch that email's user
-	user := User{}
-	result := db.Where("email_hash = ?", StringToEncodedHash(email)).Limit(1).Find(&user)
-	if result.Error != nil {
-		return nil, result.Error
+	user, legacy, err := findUserByEmail(db, email)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(user.AuthHashHash) < 1 || user.EmailHash == "" {
 		return nil, ErrUserDoesNotExist
 	}
 
-	// compare provided authentication hash to the bcrypt hash of the fetched user
-	err := bcrypt.CompareHashAndPassword(user.AuthHashHash, authenticationHash)
+	// compare provided authentication hash to the stored hash of the fetched user
+	rehashed, err := checkAuthHash(user, authenticationHash)
 	if err != nil {
 		return nil, err
 	}
+	if rehashed {
+		result := db.Model(user).Updates(map[string]interface{}{
+			"auth_hash_hash":   user.AuthHashHash,
+			"auth_hash_pepper": user.AuthHashPepper,
+		})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	}
+
+	// the email is only known to be theirs now, move them to the keyed hash
+	// a conflicting user keeps them on the legacy hash until it is resolved,
+	// and so does a missing EmailKey, until one is set
+	if legacy && EmailKey != nil {
+		err = reindexUser(db, user, email)
+		if err != nil && !errors.Is(err, ErrEmailIndexConflict) {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// checkAuthHash verifies an AuthenticationHash against the stored hash of a
+// user. the AuthenticationHash is only known now, so when the stored hash is
+// outdated it is replaced on the user and rehashed is true
+func checkAuthHash(user *User, authenticationHash []byte) (rehashed bool, err error) {
+	peppered, err := Peppers.Apply(user.AuthHashPepper, authenticationHash)
+	if err != nil {
+		return false, err
+	}
+	err = passhash.Verify(user.AuthHashHash, peppered)
+	if err != nil {
+		return false, err
+	}
+
+	if !PasswordHash.NeedsRehash(user.AuthHashHash) && user.AuthHashPepper == Peppers.Current {
+		return false, nil
+	}
+	user.AuthHashHash, user.AuthHashPepper, err = hashAuthHash(authenticationHash)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkVerified fails for users that haven't completed their verification
+func checkVerified(user *User) error {
+	if user.Verification.Hash != "" && !user.Verification.Completed {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// hashAuthHash hashes an AuthenticationHash for storage, peppered with the
+// current pepper whose ID is returned
+func hashAuthHash(authenticationHash []byte) ([]byte, string, error) {
+	peppered, err := Peppers.Apply(Peppers.Current, authenticationHash)
+	if err != nil {
+		return nil, "", err
+	}
+	authHashHash, err := PasswordHash.Hash(peppered)
+	if err != nil {
+		return nil, "", err
+	}
+	return authHashHash, Peppers.Current, nil
+}
 
-	return &user, nil
+// loadVault fetches the vault belonging to a user, without its entries
+func loadVault(db *gorm.DB, user *User) error {
+	return db.Model(user).Association("Vault").Find(&user.Vault)
 }
 
 func StringToEncodedHash(str string) string {
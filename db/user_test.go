@@ -2,6 +2,7 @@ package db_test
 
 import (
 	"context"
+	"encoding/base64"
 	"regexp"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/rokusei/gopass"
 	"github.com/rokusei/gopass-server/db"
+	"github.com/rokusei/gopass-server/db/passhash"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
@@ -39,43 +41,49 @@ func Test_CreateUser(t *testing.T) {
 		authHash, _, _, err := gopass.GenerateAuthEncHashes(test.masterPass)
 		require.NoError(t, err)
 
-		emailHash := db.StringToEncodedHash(test.email)
+		emailHash := db.EmailHash(test.email)
 
-		//mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`SELECT * FROM "users"
-		WHERE EmailHash = $1 AND "users"."deleted_at" IS NULL LIMIT 1`)).
-			WithArgs(emailHash).
-			WillReturnRows(sqlmock.NewRows([]string{}))
-
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`INSERT INTO "vaults" ("created_at","updated_at","deleted_at") VALUES ($1,$2,$3) RETURNING "id"`)).
-			WillReturnRows(sqlmock.NewRows([]string{"123"}))
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`INSERT INTO "users" ("created_at","updated_at","deleted_at","uuid","email_hash","auth_hash_hash","vault_id") 
-			VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).
-			WillReturnRows(sqlmock.NewRows([]string{"a", "b", "c"}))
-		mock.ExpectCommit()
+		expectCreateUser(mock, emailHash)
 
-		u, err := db.CreateUser(context.Background(), gdb, test.email, authHash)
+		u, err := db.CreateUser(context.Background(), gdb, test.email, authHash, db.LegacyKDF())
 		require.NoError(t, err)
 		require.Equal(t, emailHash, u.EmailHash)
-		err = bcrypt.CompareHashAndPassword(u.AuthHashHash, authHash)
+		err = passhash.Verify(u.AuthHashHash, authHash)
 		require.NoError(t, err)
 	}
 }
 
+// expectCreateUser sets up the queries made by CreateUser for a new user
+func expectCreateUser(mock sqlmock.Sqlmock, emailHash string) {
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "users"
+		WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+		WithArgs(emailHash, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{}))
+
+	// Create
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`INSERT INTO "vaults" ("created_at","updated_at","deleted_at","uuid") VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING RETURNING "id"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`INSERT INTO "users" ("created_at","updated_at","deleted_at","uuid","email_hash","auth_hash_hash","auth_hash_pepper","kdf_algorithm","kdf_iterations","kdf_memory","kdf_parallelism","kdf_salt","verification_hash","verification_completed","verification_attempts","vault_id")
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "id"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+}
+
 func Test_CreateUserDeadlineExceeded(t *testing.T) {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Second*-1))
 	defer cancel()
-	_, err := db.CreateUser(ctx, &gorm.DB{}, "", []byte{})
+	_, err := db.CreateUser(ctx, &gorm.DB{}, "", []byte{}, db.LegacyKDF())
 	require.ErrorIs(t, err, context.DeadlineExceeded)
 }
 
 func Test_CreateUserDeadlineCancelled(t *testing.T) {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Second*10))
 	cancel()
-	_, err := db.CreateUser(ctx, &gorm.DB{}, "", []byte{})
+	_, err := db.CreateUser(ctx, &gorm.DB{}, "", []byte{}, db.LegacyKDF())
 	require.ErrorIs(t, err, context.Canceled)
 }
 
@@ -102,39 +110,70 @@ func Test_GetUser(t *testing.T) {
 		authHash, _, _, err := gopass.GenerateAuthEncHashes(test.masterPass)
 		require.NoError(t, err)
 
-		emailHash := db.StringToEncodedHash(test.email)
+		emailHash := db.EmailHash(test.email)
 
 		// Create User Queries
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`SELECT * FROM "users"
-		WHERE EmailHash = $1 AND "users"."deleted_at" IS NULL LIMIT 1`)).
-			WithArgs(emailHash).
-			WillReturnRows(sqlmock.NewRows([]string{}))
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`INSERT INTO "vaults" ("created_at","updated_at","deleted_at") VALUES ($1,$2,$3) RETURNING "id"`)).
-			WillReturnRows(sqlmock.NewRows([]string{"123"}))
-		mock.ExpectQuery(regexp.QuoteMeta(
-			`INSERT INTO "users" ("created_at","updated_at","deleted_at","uuid","email_hash","auth_hash_hash","vault_id") 
-			VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).
-			WillReturnRows(sqlmock.NewRows([]string{"1"}))
+		expectCreateUser(mock, emailHash)
 
-		_, err = db.CreateUser(context.Background(), gdb, test.email, authHash)
+		_, err = db.CreateUser(context.Background(), gdb, test.email, authHash, db.LegacyKDF())
 		require.NoError(t, err)
 
-		authHashHash, _ := bcrypt.GenerateFromPassword(authHash, bcrypt.DefaultCost)
+		authHashHash, err := db.PasswordHash.Hash(authHash)
+		require.NoError(t, err)
 		mock.ExpectQuery(regexp.QuoteMeta(
 			`SELECT * FROM "users"
-			WHERE EmailHash = $1 AND "users"."deleted_at" IS NULL LIMIT 1`)).
-			WithArgs(emailHash).
-			WillReturnRows(sqlmock.NewRows([]string{"uuid", "email_hash", "auth_hash_hash"}).AddRow("123", emailHash, authHashHash))
+			WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+			WithArgs(emailHash, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "email_hash", "auth_hash_hash", "vault_id"}).AddRow(1, "123", emailHash, authHashHash, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			`SELECT * FROM "vaults" WHERE "vaults"."id" = $1 AND "vaults"."deleted_at" IS NULL`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid"}).AddRow(1, "456"))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			`SELECT * FROM "vault_entries" WHERE vault_id = $1 AND "vault_entries"."deleted_at" IS NULL ORDER BY id`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "vault_id"}))
 		u, err := db.GetUser(context.Background(), gdb, test.email, authHash)
 		require.NoError(t, err)
 		require.Equal(t, emailHash, u.EmailHash)
-		err = bcrypt.CompareHashAndPassword(u.AuthHashHash, authHash)
+		require.Equal(t, "456", u.Vault.UUID)
+		err = passhash.Verify(u.AuthHashHash, authHash)
 		require.NoError(t, err)
 	}
 }
 
+func Test_AuthenticateUserRehash(t *testing.T) {
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mdb.Close()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mdb,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	authHash, _, _, err := gopass.GenerateAuthEncHashes("abc123")
+	require.NoError(t, err)
+	emailHash := db.EmailHash("abc@123.com")
+
+	// users created before the hash was configurable have bcrypt hashes
+	authHashHash, err := bcrypt.GenerateFromPassword(authHash, bcrypt.MinCost)
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "users"
+		WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+		WithArgs(emailHash, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "email_hash", "auth_hash_hash", "vault_id"}).AddRow(1, "123", emailHash, authHashHash, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "auth_hash_hash"=$1`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	u, err := db.AuthenticateUser(context.Background(), gdb, "abc@123.com", authHash)
+	require.NoError(t, err)
+	require.Equal(t, "123", u.UUID)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func Test_GetUserDeadlineExceeded(t *testing.T) {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Second*-1))
 	defer cancel()
@@ -148,3 +187,127 @@ func Test_GetUserDeadlineCancelled(t *testing.T) {
 	_, err := db.GetUser(ctx, &gorm.DB{}, "", []byte{})
 	require.ErrorIs(t, err, context.Canceled)
 }
+
+func Test_AuthenticateUserPepperRotation(t *testing.T) {
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mdb.Close()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mdb,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	old, err := passhash.ParsePeppers("old " + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+	rotated, err := passhash.ParsePeppers("new " + base64.StdEncoding.EncodeToString([]byte("a new 32 byte long pepper secret")) + "\n" +
+		"old " + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+	defer func(p passhash.Peppers) { db.Peppers = p }(db.Peppers)
+
+	authHash, _, _, err := gopass.GenerateAuthEncHashes("abc123")
+	require.NoError(t, err)
+	emailHash := db.EmailHash("abc@123.com")
+	peppered, err := old.Apply("old", authHash)
+	require.NoError(t, err)
+	authHashHash, err := db.PasswordHash.Hash(peppered)
+	require.NoError(t, err)
+
+	// the hash can't be verified without the pepper it was made with
+	db.Peppers = passhash.Peppers{}
+	expectSelectUser(mock, emailHash, authHashHash, "old")
+	_, err = db.AuthenticateUser(context.Background(), gdb, "abc@123.com", authHash)
+	require.ErrorIs(t, err, passhash.ErrUnknownPepper)
+
+	// after a rotation it is rehashed with the current pepper
+	db.Peppers = rotated
+	expectSelectUser(mock, emailHash, authHashHash, "old")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "auth_hash_hash"=$1,"auth_hash_pepper"=$2`)).
+		WithArgs(sqlmock.AnyArg(), "new", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	_, err = db.AuthenticateUser(context.Background(), gdb, "abc@123.com", authHash)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectSelectUser sets up the query fetching a user by their email hash
+func expectSelectUser(mock sqlmock.Sqlmock, emailHash string, authHashHash []byte, pepper string) {
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "users"
+		WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+		WithArgs(emailHash, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "email_hash", "auth_hash_hash", "auth_hash_pepper", "vault_id"}).
+			AddRow(1, "123", emailHash, authHashHash, pepper, 1))
+}
+
+func Test_AuthenticateUserReindex(t *testing.T) {
+	db.EmailKey = []byte("email key")
+	t.Cleanup(func() { db.EmailKey = nil })
+
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mdb.Close()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mdb,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	authHash, _, _, err := gopass.GenerateAuthEncHashes("abc123")
+	require.NoError(t, err)
+	authHashHash, err := db.PasswordHash.Hash(authHash)
+	require.NoError(t, err)
+
+	// users created before the keyed hash are indexed under the SHA256 of their email
+	legacyHash := db.StringToEncodedHash("Abc@123.com")
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "users"
+		WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+		WithArgs(db.EmailHash("abc@123.com"), legacyHash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "email_hash", "auth_hash_hash", "vault_id"}).AddRow(1, "123", legacyHash, authHashHash, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT count(1) FROM "users" WHERE email_hash = $1`)).
+		WithArgs(db.EmailHash("abc@123.com")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "email_hash"=$1`)).
+		WithArgs(db.EmailHash("abc@123.com"), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	u, err := db.AuthenticateUser(context.Background(), gdb, "Abc@123.com", authHash)
+	require.NoError(t, err)
+	require.Equal(t, db.EmailHash(" ABC@123.com"), u.EmailHash)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_AuthenticateUserNoEmailKey(t *testing.T) {
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mdb.Close()
+	gdb, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mdb,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	authHash, _, _, err := gopass.GenerateAuthEncHashes("abc123")
+	require.NoError(t, err)
+	authHashHash, err := db.PasswordHash.Hash(authHash)
+	require.NoError(t, err)
+
+	// without an email key users stay under their legacy hash
+	legacyHash := db.StringToEncodedHash("Abc@123.com")
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "users"
+		WHERE email_hash IN ($1,$2) AND "users"."deleted_at" IS NULL`)).
+		WithArgs(db.EmailHash("abc@123.com"), legacyHash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "email_hash", "auth_hash_hash", "vault_id"}).AddRow(1, "123", legacyHash, authHashHash, 1))
+
+	u, err := db.AuthenticateUser(context.Background(), gdb, "Abc@123.com", authHash)
+	require.NoError(t, err)
+	require.Equal(t, legacyHash, u.EmailHash)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	_, _, err = db.ReindexUsers(context.Background(), gdb, []string{"Abc@123.com"})
+	require.Equal(t, db.ErrNoEmailKey, err)
+}
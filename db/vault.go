@@ -3,49 +3,46 @@ package db
 import (
 	"context"
 	"errors"
-	"fmt"
 
 	"gorm.io/gorm"
 )
 
 var ErrVaultNotFound = errors.New("vault not found")
 var ErrEntryNotFound = errors.New("entry not found")
+var ErrSchemaVersionTooNew = errors.New("vault holds entries of a newer schema version than the client supports")
 
 // a Vault contains a list of vault entries
 type Vault struct {
 	gorm.Model
 	ID           uint   `gorm:"primarykey" json:"-"`
-	UUID         string `json:"ID"`
+	UUID         string `gorm:"uniqueIndex" json:"ID"`
 	VaultEntries []VaultEntry
 }
 
 // a VaultEntry contains a UUID and an encrypted blob
+// SchemaVersion is the plaintext schema version of the client that wrote the
+// entry, it is the only thing the server knows about the blob's format
 type VaultEntry struct {
 	gorm.Model
 	ID             uint   `gorm:"primarykey" json:"-"`
-	UUID           string `json:"ID"`
-	VaultID        uint   `json:"-"`
+	UUID           string `gorm:"uniqueIndex" json:"ID"`
+	VaultID        uint   `gorm:"index" json:"-"`
 	EncryptedEntry []byte
+	SchemaVersion  uint `gorm:"default:0"`
 }
 
-// GetVault fetches a User's Vault
+// GetVault fetches the entries of a User's Vault, in creation order
 func GetVault(ctx context.Context, db *gorm.DB, user *User) (*Vault, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	/*var vault Vault
-	err := db.Debug().Model(user).Association("Vault").Find(&vault)
-	if err != nil {
-		return nil, err
-	}*/
-
-	err := db.Debug().Model(&user).Association("VaultEntries").Find(&user.Vault.VaultEntries)
-	if err != nil {
-		return nil, err
+	entries := make([]VaultEntry, 0)
+	result := db.WithContext(ctx).Where("vault_id = ?", user.Vault.ID).Order("id").Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
 	}
-	fmt.Printf("Vault: %#v\n", user.Vault)
-	fmt.Printf("VaultEntries: %#v\n", user.Vault.VaultEntries)
+	user.Vault.VaultEntries = entries
 	return &user.Vault, nil
 }
 
@@ -54,75 +51,78 @@ func GetVaultEntry(ctx context.Context, db *gorm.DB, user *User, entryUUID strin
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	return findVaultEntry(db.WithContext(ctx), user, entryUUID)
+}
 
-	var entries []VaultEntry
-	err := db.Debug().Find(user).Model(&user.Vault).Association("VaultEntries").Find(&entries)
-	if err != nil {
-		return nil, err
+// findVaultEntry fetches an entry by UUID, which must belong to the user's vault
+func findVaultEntry(db *gorm.DB, user *User, entryUUID string) (*VaultEntry, error) {
+	entry := VaultEntry{}
+	result := db.Where("vault_id = ? AND uuid = ?", user.Vault.ID, entryUUID).Limit(1).Find(&entry)
+	if result.Error != nil {
+		return nil, result.Error
 	}
-
-	fmt.Printf("Vault: %#v\n", user.Vault)
-	for _, entry := range entries {
-		fmt.Printf("Vault Entry: %#v\n", entry)
-		if entry.UUID == entryUUID && entry.VaultID == user.Vault.ID {
-			return &entry, nil
-		}
+	if result.RowsAffected == 0 {
+		return nil, ErrEntryNotFound
 	}
-
-	return nil, ErrEntryNotFound
+	return &entry, nil
 }
 
-// GetVaultEntry fetches a VaultEntry from a Vault
-func CreateVaultEntry(ctx context.Context, db *gorm.DB, user *User, encryptedEntry []byte) (*VaultEntry, error) {
+// CreateVaultEntry adds a VaultEntry to a Vault
+func CreateVaultEntry(ctx context.Context, db *gorm.DB, user *User, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	return applyVaultEntryOperation(ctx, db, user, BatchOperation{Op: BatchCreate, EncryptedEntry: encryptedEntry}, schemaVersion)
+}
 
-	uuid, err := GenerateUUID()
+// applyVaultEntryOperation applies a single operation in its own transaction
+func applyVaultEntryOperation(ctx context.Context, db *gorm.DB, user *User, op BatchOperation, schemaVersion uint) (*VaultEntry, error) {
+	var entry *VaultEntry
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkVaultQuota(tx, user, growth([]BatchOperation{op})); err != nil {
+			return err
+		}
+		var err error
+		entry, err = applyBatchOperation(tx, user, op, schemaVersion)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return entry, nil
+}
 
-	entry := VaultEntry{
-		UUID:           uuid,
-		VaultID:        user.Vault.ID,
-		EncryptedEntry: encryptedEntry,
-	}
-	result := db.Debug().Create(&entry)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-
-	fmt.Printf("CreateVaultEntry: Vault: %#v", user.Vault)
-
-	err = db.Debug().Find(user).Model(&user.Vault).Association("VaultEntries").Append(&entry)
-	if err != nil {
+// UpdateVaultEntry replaces the encrypted blob of a VaultEntry
+func UpdateVaultEntry(ctx context.Context, db *gorm.DB, user *User, entryUUID string, encryptedEntry []byte, schemaVersion uint) (*VaultEntry, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	db.Debug().Save(&entry)
-	return &entry, nil
+	return applyVaultEntryOperation(ctx, db, user, BatchOperation{Op: BatchUpdate, ID: entryUUID, EncryptedEntry: encryptedEntry}, schemaVersion)
 }
 
-// GetVaultEntry fetches a VaultEntry from a Vault
-func UpdateVaultEntry(ctx context.Context, db *gorm.DB, user *User, entryUUID string, encryptedEntry []byte) (*VaultEntry, error) {
+// DeleteVaultEntry removes a VaultEntry from a Vault
+func DeleteVaultEntry(ctx context.Context, db *gorm.DB, user *User, entryUUID string) error {
 	if err := ctx.Err(); err != nil {
-		return nil, err
+		return err
 	}
+	_, err := applyVaultEntryOperation(ctx, db, user, BatchOperation{Op: BatchDelete, ID: entryUUID}, 0)
+	return err
+}
 
-	var entries []VaultEntry
-	err := db.Debug().Find(user).Model(&user.Vault).Association("VaultEntries").Find(&entries)
-	if err != nil {
-		return nil, err
+// CheckSchemaVersion fails with ErrSchemaVersionTooNew when the vault holds
+// entries written in a schema version newer than supported
+func CheckSchemaVersion(ctx context.Context, db *gorm.DB, user *User, supported uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	fmt.Printf("Vault: %#v\n", user.Vault)
-	for i, entry := range entries {
-		if entry.UUID == entryUUID {
-			entries[i].EncryptedEntry = encryptedEntry
-			db.Debug().Updates(entries)
-			db.Debug().Save(&entry)
-			return &entries[i], nil
-		}
+	var newest uint
+	result := db.WithContext(ctx).Model(&VaultEntry{}).Where("vault_id = ?", user.Vault.ID).Select("COALESCE(MAX(schema_version), 0)").Scan(&newest)
+	if result.Error != nil {
+		return result.Error
+	}
+	if newest > supported {
+		return ErrSchemaVersionTooNew
 	}
-	return nil, ErrEntryNotFound
+	return nil
 }
@@ -0,0 +1,99 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/rokusei/gopass-server/db"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// seedVault creates a user whose vault holds n entries, returning the UUID of
+// one in the middle
+func seedVault(b *testing.B, s db.Store, n int) (*db.User, string) {
+	ctx := context.Background()
+	user, err := s.CreateUser(ctx, "a@example.com", make([]byte, db.AuthHashSize), db.DefaultKDF(make([]byte, db.KDFSaltSize)))
+	require.NoError(b, err)
+
+	var middle string
+	for created := 0; created < n; {
+		ops := make([]db.BatchOperation, 0, db.MaxBatchOperations)
+		for i := 0; i < db.MaxBatchOperations && created+i < n; i++ {
+			ops = append(ops, db.BatchOperation{Op: db.BatchCreate, EncryptedEntry: make([]byte, 256)})
+		}
+		results, err := s.ApplyVaultEntryBatch(ctx, user, ops, 1)
+		require.NoError(b, err)
+		for i, r := range results {
+			if created+i == n/2 {
+				middle = r.Entry.UUID
+			}
+		}
+		created += len(results)
+	}
+	return user, middle
+}
+
+// benchmarkStores runs bench against every persistent store, on vaults of
+// 100 and 10k entries, lookups must take about as long on either
+func benchmarkStores(b *testing.B, bench func(b *testing.B, s db.Store, user *db.User, entryUUID string)) {
+	stores := []struct {
+		name string
+		open func(b *testing.B) db.Store
+	}{
+		{"gorm-sqlite", func(b *testing.B) db.Store {
+			gdb, err := db.Open("sqlite", filepath.Join(b.TempDir(), "gopass.sqlite"), &gorm.Config{
+				Logger: logger.Discard,
+			})
+			require.NoError(b, err)
+			require.NoError(b, db.Migrate(gdb))
+			return db.NewGormStore(gdb)
+		}},
+		{"bolt", func(b *testing.B) db.Store {
+			s, err := db.OpenBoltStore(filepath.Join(b.TempDir(), "gopass.db"))
+			require.NoError(b, err)
+			b.Cleanup(func() { s.Close() })
+			return s
+		}},
+	}
+	for _, store := range stores {
+		for _, n := range []int{100, 10000} {
+			b.Run(fmt.Sprintf("%s/%d", store.name, n), func(b *testing.B) {
+				s := store.open(b)
+				user, entryUUID := seedVault(b, s, n)
+				b.ResetTimer()
+				bench(b, s, user, entryUUID)
+			})
+		}
+	}
+}
+
+func BenchmarkGetVaultEntry(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s db.Store, user *db.User, entryUUID string) {
+		for i := 0; i < b.N; i++ {
+			_, err := s.GetVaultEntry(context.Background(), user, entryUUID)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkUpdateVaultEntry(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s db.Store, user *db.User, entryUUID string) {
+		for i := 0; i < b.N; i++ {
+			_, err := s.UpdateVaultEntry(context.Background(), user, entryUUID, make([]byte, 256), 1)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkCreateVaultEntry(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s db.Store, user *db.User, entryUUID string) {
+		for i := 0; i < b.N; i++ {
+			_, err := s.CreateVaultEntry(context.Background(), user, make([]byte, 256), 1)
+			require.NoError(b, err)
+		}
+	})
+}
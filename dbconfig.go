@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rokusei/gopass-server/db"
+)
+
+// a dbSetting is one option of db.Config, settable from a flag or an
+// environment variable
+type dbSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *db.Config, v string) error
+}
+
+func intSetting(field func(c *db.Config) *int) func(c *db.Config, v string) error {
+	return func(c *db.Config, v string) error {
+		n, err := strconv.Atoi(v)
+		*field(c) = n
+		return err
+	}
+}
+
+func durationSetting(field func(c *db.Config) *time.Duration) func(c *db.Config, v string) error {
+	return func(c *db.Config, v string) error {
+		d, err := time.ParseDuration(v)
+		*field(c) = d
+		return err
+	}
+}
+
+func stringSetting(field func(c *db.Config) *string) func(c *db.Config, v string) error {
+	return func(c *db.Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func dbSettings(drivers []string) []dbSetting {
+	return []dbSetting{
+		{"driver", "GOPASS_SERVER_DB_DRIVER", fmt.Sprintf("database driver, one of %v", drivers),
+			stringSetting(func(c *db.Config) *string { return &c.Driver })},
+		{"dsn", "GOPASS_SERVER_DB_DSN", "database connection string",
+			stringSetting(func(c *db.Config) *string { return &c.DSN })},
+		{"db-max-open-conns", "GOPASS_SERVER_DB_MAX_OPEN_CONNS", "maximum number of open database connections",
+			intSetting(func(c *db.Config) *int { return &c.MaxOpenConns })},
+		{"db-max-idle-conns", "GOPASS_SERVER_DB_MAX_IDLE_CONNS", "maximum number of idle database connections",
+			intSetting(func(c *db.Config) *int { return &c.MaxIdleConns })},
+		{"db-conn-max-lifetime", "GOPASS_SERVER_DB_CONN_MAX_LIFETIME", "close database connections older than this",
+			durationSetting(func(c *db.Config) *time.Duration { return &c.ConnMaxLifetime })},
+		{"db-conn-max-idle-time", "GOPASS_SERVER_DB_CONN_MAX_IDLE_TIME", "close database connections idle for longer than this",
+			durationSetting(func(c *db.Config) *time.Duration { return &c.ConnMaxIdleTime })},
+		{"db-statement-timeout", "GOPASS_SERVER_DB_STATEMENT_TIMEOUT", "abort statements running longer than this, postgres and mysql only",
+			durationSetting(func(c *db.Config) *time.Duration { return &c.StatementTimeout })},
+		{"db-tls", "GOPASS_SERVER_DB_TLS", "require TLS to the database, postgres and mysql only",
+			func(c *db.Config, v string) error {
+				var err error
+				c.TLS.Enabled, err = strconv.ParseBool(v)
+				return err
+			}},
+		{"db-tls-ca", "GOPASS_SERVER_DB_TLS_CA", "PEM certificates to verify the database with instead of the system roots",
+			stringSetting(func(c *db.Config) *string { return &c.TLS.CAFile })},
+		{"db-tls-cert", "GOPASS_SERVER_DB_TLS_CERT", "PEM client certificate to authenticate to the database with",
+			stringSetting(func(c *db.Config) *string { return &c.TLS.CertFile })},
+		{"db-tls-key", "GOPASS_SERVER_DB_TLS_KEY", "PEM key of -db-tls-cert",
+			stringSetting(func(c *db.Config) *string { return &c.TLS.KeyFile })},
+		{"db-tls-server-name", "GOPASS_SERVER_DB_TLS_SERVER_NAME", "name to verify the database certificate against, defaults to its host",
+			stringSetting(func(c *db.Config) *string { return &c.TLS.ServerName })},
+	}
+}
+
+// dbFlagSet holds the flags configuring the database
+type dbFlagSet struct {
+	fs       *flag.FlagSet
+	file     *string
+	settings []dbSetting
+	values   map[string]*string
+}
+
+// dbFlags registers the flags configuring the database on fs, out of drivers
+func dbFlags(fs *flag.FlagSet, drivers []string) *dbFlagSet {
+	defaults := db.DefaultConfig()
+	f := &dbFlagSet{
+		fs:       fs,
+		file:     fs.String("db-config", "", "YAML file configuring the database, overrides $GOPASS_SERVER_DB_CONFIG"),
+		settings: dbSettings(drivers),
+		values:   make(map[string]*string),
+	}
+	for _, s := range f.settings {
+		usage := fmt.Sprintf("%s, overrides $%s", s.usage, s.env)
+		switch s.flag {
+		case "driver":
+			usage += fmt.Sprintf(" (default %q)", defaults.Driver)
+		case "dsn":
+			usage += fmt.Sprintf(" (default %q)", defaults.DSN)
+		}
+		f.values[s.flag] = fs.String(s.flag, "", usage)
+	}
+	return f
+}
+
+// config returns the database configuration once the flags are parsed, the
+// config file is read over the defaults, then the environment and the flags
+// set on the command line take precedence in turn
+func (f *dbFlagSet) config() (db.Config, error) {
+	c := db.DefaultConfig()
+	file := os.Getenv("GOPASS_SERVER_DB_CONFIG")
+	if *f.file != "" {
+		file = *f.file
+	}
+	if file != "" {
+		if err := c.LoadConfigFile(file); err != nil {
+			return c, err
+		}
+	}
+
+	for _, s := range f.settings {
+		if v, ok := os.LookupEnv(s.env); ok && strings.TrimSpace(v) != "" {
+			if err := s.set(&c, strings.TrimSpace(v)); err != nil {
+				return c, fmt.Errorf("$%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		v, ok := f.values[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		for _, s := range f.settings {
+			if s.flag == fl.Name {
+				if setErr := s.set(&c, *v); setErr != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, setErr)
+				}
+			}
+		}
+	})
+	return c, err
+}
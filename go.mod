@@ -3,10 +3,17 @@ module github.com/rokusei/gopass-server
 go 1.16
 
 require (
+	filippo.io/age v1.0.0-rc.1
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/jackc/pgx/v4 v4.10.1
 	github.com/rokusei/gopass v0.0.0-20210319104248-83558b17f20b
 	github.com/stretchr/testify v1.7.0
+	github.com/tobischo/gokeepasslib/v3 v3.1.0
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+	gorm.io/driver/mysql v1.0.5
 	gorm.io/driver/postgres v1.0.8
 	gorm.io/driver/sqlite v1.1.4 // indirect
 	gorm.io/gorm v1.21.6
@@ -1,19 +1,544 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/rokusei/gopass-server/api"
+	"github.com/rokusei/gopass-server/api/padding"
+	"github.com/rokusei/gopass-server/backup"
 	"github.com/rokusei/gopass-server/db"
+	"github.com/rokusei/gopass-server/db/envelope"
+	"github.com/rokusei/gopass-server/db/passhash"
 	"github.com/rokusei/gopass-server/server"
 	"gorm.io/gorm"
-
-	"gorm.io/driver/sqlite"
 )
 
+const usage = `usage: gopass-server [command] [flags]
+
+commands:
+  serve             run the API server (default)
+  backup            write a signed backup archive of the database
+  restore           restore a backup archive into an empty database
+  backup-keygen     generate a key pair for signing backups
+  reindex-emails    index the users of a list of emails under the keyed email hash
+  migrate           show or change the version of the database schema
+  rewrap-data-keys  wrap the data keys of entries encrypted at rest with the current master key
+  version           print the version of the server
+`
+
 func main() {
-	gdb, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
-	gdb.AutoMigrate(&db.User{}, &db.Vault{}, &db.VaultEntry{})
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	var err error
+	switch name {
+	case "serve":
+		err = serve(args)
+	case "backup":
+		err = runBackup(args)
+	case "restore":
+		err = runRestore(args)
+	case "backup-keygen":
+		err = backupKeygen(args)
+	case "reindex-emails":
+		err = reindexEmails(args)
+	case "migrate":
+		err = migrate(args)
+	case "rewrap-data-keys":
+		err = rewrapDataKeys(args)
+	case "version":
+		fmt.Printf("gopass-server %s\n", api.Version)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gopass-server %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	dbf := dbFlags(fs, db.StoreDrivers)
+	hotBackupFile := fs.String("hot-backup-file", "", "file the bolt driver copies its database to every -hot-backup-interval while serving")
+	hotBackupInterval := fs.Duration("hot-backup-interval", time.Hour, "how often to write -hot-backup-file")
+	preloginKeyFile := fs.String("prelogin-key-file", "", "file holding the secret /prelogin derives the KDF parameters of unknown emails from, overrides $GOPASS_SERVER_PRELOGIN_KEY")
+	legacyKDFShare := fs.Float64("prelogin-legacy-share", db.LegacyFakeKDFShare, "share of unknown emails /prelogin answers with the salt-less legacy PBKDF2 parameters, set it close to the share of accounts that haven't run upgrade-kdf")
+	passwordHash := fs.String("password-hash", passhash.Default().String(), "algorithm and parameters AuthenticationHashes are stored with, bcrypt:cost=N, argon2id:m=KiB,t=N,p=N or scrypt:ln=N,r=N,p=N; weaker stored hashes are upgraded on login")
+	emailKeyFile := emailKeyFlag(fs)
+	pepperFile := fs.String("pepper-file", "", "file of \"<id> <base64 key>\" lines peppering stored AuthenticationHashes, newest first, overrides $GOPASS_SERVER_PEPPER")
+	masterKeyFile := masterKeyFlag(fs)
+	entryPadding := fs.String("entry-padding", "", "comma separated sizes in bytes encrypted entries must be padded to, larger ones to a multiple of the largest; \"default\" is powers of two from 128 B to 1 MiB, empty accepts any size")
+	maxBodySize := fs.Int64("max-body-size", 64<<20, "size in bytes of the largest request body read, 0 for no limit")
+	maxEntrySize := fs.Int("max-entry-size", 1<<20, "size in bytes of the largest encrypted entry stored, 0 for no limit")
+	maxEntries := fs.Int("max-entries", 10000, "how many entries a vault may hold, 0 for no limit")
+	fs.Parse(args)
+
+	preloginKey, err := loadPreloginKey(*preloginKeyFile)
+	if err != nil {
+		return err
+	}
+	if *legacyKDFShare < 0 || *legacyKDFShare > 1 {
+		return fmt.Errorf("-prelogin-legacy-share must be between 0 and 1, not %v", *legacyKDFShare)
+	}
+	db.LegacyFakeKDFShare = *legacyKDFShare
+	db.Peppers, err = loadPeppers(*pepperFile)
 	if err != nil {
-		panic("failed to connect database")
+		return err
+	}
+	db.EmailKey, err = loadEmailKey(*emailKeyFile)
+	if err != nil {
+		return err
+	}
+	db.PasswordHash, err = passhash.ParsePolicy(*passwordHash)
+	if err != nil {
+		return err
+	}
+	masterKeys, err := loadMasterKeys(*masterKeyFile)
+	if err != nil {
+		return err
+	}
+	paddingPolicy, err := padding.ParsePolicy(*entryPadding)
+	if err != nil {
+		return err
+	}
+	db.Quotas = db.Quota{MaxEntrySize: *maxEntrySize, MaxEntries: *maxEntries}
+
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	store, err := dbConfig.OpenStore(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+	if *hotBackupFile != "" {
+		bolt, ok := store.(*db.BoltStore)
+		if !ok {
+			return fmt.Errorf("-hot-backup-file requires the %s driver, back up SQL databases with the backup command", db.BoltDriver)
+		}
+		go hotBackups(bolt, *hotBackupFile, *hotBackupInterval)
+	}
+	if masterKeys != nil {
+		store = db.NewEnvelopeStore(store.(db.DataKeyStore), masterKeys)
+	}
+
+	return server.Run(*addr, api.APIConfig{Store: store, PreloginKey: preloginKey, Padding: paddingPolicy, MaxBodySize: *maxBodySize})
+}
+
+// hotBackups copies a bolt store to path every interval, without interrupting it
+func hotBackups(store *db.BoltStore, path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := store.BackupFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "hot backup: %v\n", err)
+		}
+	}
+}
+
+// loadPeppers reads the peppers from path or the environment, without either
+// AuthenticationHashes are stored unpeppered
+func loadPeppers(path string) (passhash.Peppers, error) {
+	s := os.Getenv("GOPASS_SERVER_PEPPER")
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return passhash.Peppers{}, err
+		}
+		s = string(b)
+	}
+	return passhash.ParsePeppers(s)
+}
+
+// readSecret reads a secret from path, or the environment variable env when
+// path is empty, returning nil when neither is set
+func readSecret(path, env string) ([]byte, error) {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(b), nil
+	}
+	if key := os.Getenv(env); key != "" {
+		return []byte(key), nil
+	}
+	return nil, nil
+}
+
+// loadPreloginKey reads the prelogin key from path or the environment, when
+// neither is set a random key is used, which lets clients tell unknown emails
+// apart across restarts
+func loadPreloginKey(path string) ([]byte, error) {
+	key, err := readSecret(path, "GOPASS_SERVER_PRELOGIN_KEY")
+	if key != nil || err != nil {
+		return key, err
+	}
+
+	fmt.Fprintln(os.Stderr, "warning: no prelogin key set, using a random one until the server restarts")
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	return key, err
+}
+
+// emailKeyFlag registers the flag selecting the key users are indexed by their email with
+func emailKeyFlag(fs *flag.FlagSet) *string {
+	return fs.String("email-key-file", "", "file holding the secret users are indexed by their email with, overrides $GOPASS_SERVER_EMAIL_KEY")
+}
+
+// loadEmailKey reads the email key from path or the environment, it can't be
+// made up like the prelogin key since users couldn't be found after a restart,
+// and it is required since users indexed without one would be lost once it is set
+func loadEmailKey(path string) ([]byte, error) {
+	key, err := readSecret(path, "GOPASS_SERVER_EMAIL_KEY")
+	if key == nil && err == nil {
+		return nil, fmt.Errorf("no email key, set -email-key-file or $GOPASS_SERVER_EMAIL_KEY")
+	}
+	return key, err
+}
+
+// masterKeyFlag registers the flag selecting the master keys entries are encrypted at rest under
+func masterKeyFlag(fs *flag.FlagSet) *string {
+	return fs.String("master-key-file", "", "file of \"<id> <base64 key>\" lines wrapping the data keys entries are encrypted at rest with, newest first, overrides $GOPASS_SERVER_MASTER_KEY")
+}
+
+// loadMasterKeys reads the master keys from path or the environment, entries
+// aren't encrypted at rest when neither is set
+func loadMasterKeys(path string) (*envelope.KeyFile, error) {
+	s, err := readSecret(path, "GOPASS_SERVER_MASTER_KEY")
+	if s == nil || err != nil {
+		return nil, err
+	}
+	return envelope.ParseKeyFile(string(s))
+}
+
+// rewrapDataKeys wraps every data key with the current master key, after
+// which older master keys can be dropped
+func rewrapDataKeys(args []string) error {
+	fs := flag.NewFlagSet("rewrap-data-keys", flag.ExitOnError)
+	dbf := dbFlags(fs, db.StoreDrivers)
+	masterKeyFile := masterKeyFlag(fs)
+	fs.Parse(args)
+
+	masterKeys, err := loadMasterKeys(*masterKeyFile)
+	if err != nil {
+		return err
+	}
+	if masterKeys == nil {
+		return fmt.Errorf("no master keys, set -master-key-file or $GOPASS_SERVER_MASTER_KEY")
+	}
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	store, err := dbConfig.OpenStore(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+
+	n, err := db.NewEnvelopeStore(store.(db.DataKeyStore), masterKeys).RewrapDataKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "rewrapped %d data keys with master key %s\n", n, masterKeys.KeyID())
+	return nil
+}
+
+func reindexEmails(args []string) error {
+	fs := flag.NewFlagSet("reindex-emails", flag.ExitOnError)
+	dbf := dbFlags(fs, db.StoreDrivers)
+	emailKeyFile := emailKeyFlag(fs)
+	in := fs.String("i", "", "file listing the emails of the users to re-index, one per line, defaults to stdin")
+	fs.Parse(args)
+
+	var err error
+	db.EmailKey, err = loadEmailKey(*emailKeyFile)
+	if err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var emails []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if email := strings.TrimSpace(scanner.Text()); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
-	server.Run(gdb)
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	store, err := dbConfig.OpenStore(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+
+	reindexed, conflicts, err := store.(db.ReindexStore).ReindexUsers(context.Background(), emails)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "re-indexed %d users\n", reindexed)
+	for _, email := range conflicts {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", email, db.ErrEmailIndexConflict)
+	}
+	return nil
+}
+
+const migrateUsage = `usage: gopass-server migrate [flags] [status|up|down|to N]
+
+  status  list every migration and whether it was applied (default)
+  up      apply every pending migration
+  down    revert the last applied migration
+  to N    apply or revert migrations until the schema is at version N
+`
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	dbf := dbFlags(fs, db.Drivers)
+	fs.Parse(args)
+
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	gdb, err := dbConfig.Open(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	current, err := db.SchemaVersion(ctx, gdb)
+	if err != nil {
+		return err
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "", "status":
+		applied, err := db.AppliedMigrations(ctx, gdb)
+		if err != nil {
+			return err
+		}
+		appliedAt := make(map[uint]time.Time)
+		for _, m := range applied {
+			appliedAt[m.Version] = m.AppliedAt
+		}
+		for _, m := range db.Migrations {
+			status := "pending"
+			if t, ok := appliedAt[m.Version]; ok {
+				status = "applied " + t.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s  %s\n", m.Version, m.Name, status)
+		}
+		if current > db.LatestVersion() {
+			return fmt.Errorf("%w: at version %d", db.ErrSchemaTooNew, current)
+		}
+		return nil
+	case "up":
+		return db.MigrateTo(ctx, gdb, db.LatestVersion())
+	case "down":
+		if current == 0 {
+			return fmt.Errorf("no migration to revert")
+		}
+		return db.MigrateTo(ctx, gdb, current-1)
+	case "to":
+		version, err := strconv.ParseUint(fs.Arg(1), 10, 32)
+		if err != nil {
+			return fmt.Errorf("to expects a version: %w", err)
+		}
+		return db.MigrateTo(ctx, gdb, uint(version))
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", cmd)
+	}
+}
+
+// recipientsFlag collects age recipients from repeated -recipient flags
+type recipientsFlag []age.Recipient
+
+func (f *recipientsFlag) String() string {
+	return ""
+}
+
+func (f *recipientsFlag) Set(s string) error {
+	r, err := age.ParseX25519Recipient(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbf := dbFlags(fs, db.Drivers)
+	keyFile := fs.String("key", "", "Ed25519 private key signing the archive, see backup-keygen")
+	out := fs.String("o", "", "file to write the archive to, defaults to stdout")
+	var recipients recipientsFlag
+	fs.Var(&recipients, "recipient", "age recipient to encrypt the archive to, may be repeated")
+	recipientsFile := fs.String("recipients-file", "", "file listing age recipients to encrypt the archive to")
+	fs.Parse(args)
+
+	if *keyFile == "" {
+		return fmt.Errorf("-key is required")
+	}
+	b, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := backup.ParsePrivateKey(b)
+	if err != nil {
+		return err
+	}
+	if *recipientsFile != "" {
+		f, err := os.Open(*recipientsFile)
+		if err != nil {
+			return err
+		}
+		rs, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, rs...)
+	}
+
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	gdb, err := dbConfig.Open(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+	snap, err := backup.Dump(context.Background(), gdb)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return backup.Write(os.Stdout, snap, key, recipients...)
+	}
+	// write next to the destination and rename, so a failed backup never
+	// replaces a good one
+	tmp, err := ioutil.TempFile(filepath.Dir(*out), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := backup.Write(tmp, snap, key, recipients...); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), *out)
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbf := dbFlags(fs, db.Drivers)
+	keyFile := fs.String("verify-key", "", "Ed25519 public key the archive was signed with")
+	identityFile := fs.String("identity", "", "age identity file decrypting an encrypted archive")
+	in := fs.String("i", "", "archive to restore, defaults to stdin")
+	fs.Parse(args)
+
+	if *keyFile == "" {
+		return fmt.Errorf("-verify-key is required")
+	}
+	b, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := backup.ParsePublicKey(b)
+	if err != nil {
+		return err
+	}
+	var identities []age.Identity
+	if *identityFile != "" {
+		f, err := os.Open(*identityFile)
+		if err != nil {
+			return err
+		}
+		identities, err = age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	r := os.Stdin
+	if *in != "" {
+		r, err = os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+	}
+	snap, err := backup.Read(r, key, identities...)
+	if err != nil {
+		return err
+	}
+
+	dbConfig, err := dbf.config()
+	if err != nil {
+		return err
+	}
+	gdb, err := dbConfig.Open(&gorm.Config{})
+	if err != nil {
+		return err
+	}
+	if err := backup.Restore(context.Background(), gdb, snap); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d users, %d vaults and %d entries\n", len(snap.Users), len(snap.Vaults), len(snap.Entries))
+	return nil
+}
+
+func backupKeygen(args []string) error {
+	fs := flag.NewFlagSet("backup-keygen", flag.ExitOnError)
+	out := fs.String("o", "backup.key", "file to write the private key to, the public key is written to <file>.pub")
+	fs.Parse(args)
+
+	private, public, err := backup.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*out, private, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*out+".pub", public, 0644)
 }
@@ -4,13 +4,11 @@ import (
 	"net/http"
 
 	"github.com/rokusei/gopass-server/api"
-	"gorm.io/gorm"
 )
 
-func Run(db *gorm.DB) {
-	apiConfig := api.APIConfig{
-		DB: db,
-	}
+// Run serves the API on addr until it fails, apiConfig.Store must already be
+// opened and migrated
+func Run(addr string, apiConfig api.APIConfig) error {
 	apiHandler := api.NewAPI(apiConfig)
-	http.ListenAndServe(":8080", apiHandler)
+	return http.ListenAndServe(addr, apiHandler)
 }